@@ -0,0 +1,453 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package at
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Control characters used to terminate the data sent after a prompt
+const (
+	// CtrlZ terminates the data and sends it
+	CtrlZ byte = 0x1A
+	// Escape aborts the data input
+	Escape byte = 0x1B
+)
+
+// DefaultDrainTimeout is the default time the final result of an abandoned
+// command is waited for.
+const DefaultDrainTimeout = 5 * time.Second
+
+// ErrClosed is returned when the Modem has been closed
+var ErrClosed = errors.New("at: modem closed")
+
+// Error is returned by Exec when the modem answers a command with an error
+// final result, like ERROR or +CME ERROR.
+type Error struct {
+	// Result is the final result line, like "+CME ERROR: 10"
+	Result string
+}
+
+func (e *Error) Error() string {
+	return "at: " + e.Result
+}
+
+// Response is the answer of the modem to a command.
+type Response struct {
+	// Lines are the intermediate lines, without the echo and the URCs
+	Lines []string
+	// Result is the final result line, like "OK" or "+CME ERROR: 10"
+	Result string
+}
+
+// Handler is a function that handles an unsolicited result code. Handlers
+// are called by the goroutine that reads from the port, so they must not
+// block and must not call Exec.
+type Handler func(line string)
+
+type handler struct {
+	prefix string
+	fn     Handler
+}
+
+// command is the state of the command waiting for its answer.
+type command struct {
+	text   string
+	prefix string   // prefix of the response lines, like "+CSQ"
+	echo   []string // lines of the data not echoed yet
+
+	wantPrompt bool
+	abandoned  bool      // the caller stopped waiting for the prompt
+	drainBy    time.Time // set when abandoned: the final result is waited until then
+	prompt     chan struct{}
+	done       chan struct{}
+
+	// Written by the reader before closing done
+	lines  []string
+	result string
+	failed bool
+	err    error
+}
+
+// Modem sends AT commands to a modem and dispatches the unsolicited result
+// codes received. Exec and ExecWithData may be called concurrently: the
+// commands are sent one at a time.
+type Modem struct {
+	// DrainTimeout is the time the final result of a command abandoned by
+	// its caller is waited for, before sending the next command. If zero,
+	// DefaultDrainTimeout is used.
+	DrainTimeout time.Duration
+
+	port io.ReadWriteCloser
+
+	execLock sync.Mutex
+
+	mu       sync.Mutex
+	handlers []handler
+	pending  *command
+	closed   bool
+	err      error
+	done     chan struct{}
+}
+
+// NewModem creates a new Modem that sends commands on port and starts
+// reading from it. The Modem takes ownership of the port.
+func NewModem(port io.ReadWriteCloser) *Modem {
+	m := &Modem{
+		port: port,
+		done: make(chan struct{}),
+	}
+	go m.readLoop()
+	return m
+}
+
+// Handle registers the handler for the unsolicited result codes that start
+// with prefix, like "RING" or "+CREG". If h is nil the handler registered
+// for prefix is removed. Prefixes are matched in registration order.
+func (m *Modem) Handle(prefix string, h Handler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, hnd := range m.handlers {
+		if hnd.prefix == prefix {
+			if h == nil {
+				m.handlers = append(m.handlers[:i], m.handlers[i+1:]...)
+			} else {
+				m.handlers[i].fn = h
+			}
+			return
+		}
+	}
+	if h != nil {
+		m.handlers = append(m.handlers, handler{prefix: prefix, fn: h})
+	}
+}
+
+// Exec sends a command, like "AT+CSQ", and waits for its final result.
+// If the final result is an error the Response is returned together with
+// an *Error.
+func (m *Modem) Exec(ctx context.Context, cmd string) (*Response, error) {
+	return m.exec(ctx, cmd, nil, false)
+}
+
+// ExecWithData sends a command, waits for the ">" prompt, sends data and
+// then waits for the final result. The data is sent as is, it usually must
+// be terminated with CtrlZ.
+func (m *Modem) ExecWithData(ctx context.Context, cmd string, data []byte) (*Response, error) {
+	return m.exec(ctx, cmd, data, true)
+}
+
+func (m *Modem) exec(ctx context.Context, cmd string, data []byte, withData bool) (*Response, error) {
+	m.execLock.Lock()
+	defer m.execLock.Unlock()
+
+	// A command abandoned by its caller is left pending until its final
+	// result, so that a late answer isn't taken for the answer of the next
+	// command: wait for it to be drained.
+	m.mu.Lock()
+	prev := m.pending
+	m.mu.Unlock()
+	if prev != nil {
+		if err := m.drain(ctx, prev); err != nil {
+			return nil, err
+		}
+	}
+
+	c := &command{
+		text:       cmd,
+		prefix:     responsePrefix(cmd),
+		echo:       echoLines(data),
+		wantPrompt: withData,
+		prompt:     make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	m.mu.Lock()
+	if m.err != nil {
+		err := m.err
+		m.mu.Unlock()
+		return nil, err
+	}
+	m.pending = c
+	m.mu.Unlock()
+
+	if _, err := m.port.Write([]byte(cmd + "\r")); err != nil {
+		m.clearPending(c)
+		return nil, err
+	}
+	if withData {
+		select {
+		case <-c.prompt:
+		case <-c.done:
+			return c.response()
+		case <-ctx.Done():
+			m.abandon(c)
+			m.abandonPrompt(c)
+			return nil, ctx.Err()
+		}
+		if _, err := m.port.Write(data); err != nil {
+			m.clearPending(c)
+			return nil, err
+		}
+	}
+	select {
+	case <-c.done:
+		return c.response()
+	case <-ctx.Done():
+		m.abandon(c)
+		return nil, ctx.Err()
+	}
+}
+
+// abandon marks c as abandoned by its caller: it's left pending until its
+// final result or the drain timeout.
+func (m *Modem) abandon(c *command) {
+	timeout := m.DrainTimeout
+	if timeout <= 0 {
+		timeout = DefaultDrainTimeout
+	}
+	m.mu.Lock()
+	c.drainBy = time.Now().Add(timeout)
+	m.mu.Unlock()
+}
+
+// drain waits for the final result of the abandoned command c. If the modem
+// doesn't answer by the drain timeout, c is dropped and the input buffer of
+// the port, if any, is flushed.
+func (m *Modem) drain(ctx context.Context, c *command) error {
+	m.mu.Lock()
+	deadline := c.drainBy
+	m.mu.Unlock()
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case <-c.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+	}
+	m.clearPending(c)
+	if port, ok := m.port.(interface{ ResetInputBuffer() error }); ok {
+		port.ResetInputBuffer()
+	}
+	return nil
+}
+
+// clearPending removes c from the pending command, after it failed to be
+// sent.
+func (m *Modem) clearPending(c *command) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.pending == c {
+		m.pending = nil
+	}
+}
+
+// abandonPrompt aborts the data input of a command whose caller stopped
+// waiting for the prompt: Escape is sent as soon as the prompt is received,
+// and the modem answers with the final result.
+func (m *Modem) abandonPrompt(c *command) {
+	m.mu.Lock()
+	c.abandoned = true
+	prompted := !c.wantPrompt
+	m.mu.Unlock()
+	if prompted {
+		m.port.Write([]byte{Escape})
+	}
+}
+
+func (c *command) response() (*Response, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	res := &Response{Lines: c.lines, Result: c.result}
+	if c.failed {
+		return res, &Error{Result: c.result}
+	}
+	return res, nil
+}
+
+// Close closes the port and waits for the reading goroutine to terminate.
+// The pending commands fail with ErrClosed.
+func (m *Modem) Close() error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil
+	}
+	m.closed = true
+	m.mu.Unlock()
+	err := m.port.Close()
+	<-m.done
+	return err
+}
+
+// readLoop splits the data received in lines and dispatches them.
+func (m *Modem) readLoop() {
+	defer close(m.done)
+	buf := make([]byte, 256)
+	var line []byte
+	for {
+		n, err := m.port.Read(buf)
+		for _, b := range buf[:n] {
+			if b == '\r' || b == '\n' {
+				if len(line) > 0 {
+					m.dispatch(string(line))
+					line = line[:0]
+				}
+				continue
+			}
+			line = append(line, b)
+		}
+		// The prompt is not terminated by a newline
+		if len(line) > 0 && strings.TrimSpace(string(line)) == ">" && m.promptReceived() {
+			line = line[:0]
+		}
+		if err != nil {
+			m.fail(err)
+			return
+		}
+	}
+}
+
+// promptReceived signals the prompt to the pending command, if it's
+// waiting for it.
+func (m *Modem) promptReceived() bool {
+	m.mu.Lock()
+	c := m.pending
+	if c == nil || !c.wantPrompt {
+		m.mu.Unlock()
+		return false
+	}
+	c.wantPrompt = false
+	close(c.prompt)
+	abandoned := c.abandoned
+	m.mu.Unlock()
+	if abandoned {
+		m.port.Write([]byte{Escape})
+	}
+	return true
+}
+
+// dispatch routes a line to the pending command or to a URC handler.
+func (m *Modem) dispatch(line string) {
+	m.mu.Lock()
+	c := m.pending
+	h := m.handler(line)
+	if c != nil && (h == nil || (c.prefix != "" && strings.HasPrefix(line, c.prefix))) {
+		defer m.mu.Unlock()
+		if line == c.text {
+			return
+		}
+		if final, failed := finalResult(line); final {
+			c.result = line
+			c.failed = failed
+			m.pending = nil
+			close(c.done)
+			return
+		}
+		if c.isDataEcho(line) {
+			return
+		}
+		c.lines = append(c.lines, line)
+		return
+	}
+	m.mu.Unlock()
+	if h != nil {
+		h(line)
+	}
+}
+
+// handler returns the handler registered for line, or nil.
+func (m *Modem) handler(line string) Handler {
+	for _, h := range m.handlers {
+		if strings.HasPrefix(line, h.prefix) {
+			return h.fn
+		}
+	}
+	return nil
+}
+
+// fail terminates the pending command after a read error.
+func (m *Modem) fail(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		err = ErrClosed
+	}
+	m.err = err
+	if c := m.pending; c != nil {
+		c.err = err
+		m.pending = nil
+		close(c.done)
+	}
+}
+
+// isDataEcho returns true if line is the echo of the next line of the data
+// sent after the prompt, and consumes it.
+func (c *command) isDataEcho(line string) bool {
+	if c.wantPrompt || len(c.echo) == 0 || trimControl(line) != c.echo[0] {
+		return false
+	}
+	c.echo = c.echo[1:]
+	return true
+}
+
+// echoLines splits the data sent after a prompt in the lines echoed by the
+// modem.
+func echoLines(data []byte) []string {
+	var lines []string
+	for _, line := range strings.FieldsFunc(string(data), func(r rune) bool { return r == '\r' || r == '\n' }) {
+		if line = trimControl(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// trimControl removes the CtrlZ or Escape that terminates the data.
+func trimControl(line string) string {
+	return strings.TrimRight(line, string([]byte{CtrlZ, Escape}))
+}
+
+// responsePrefix returns the prefix of the response lines of cmd, for
+// example "+CSQ" for "AT+CSQ" or "+CREG" for "AT+CREG?".
+func responsePrefix(cmd string) string {
+	if len(cmd) < 3 || !strings.EqualFold(cmd[:2], "AT") {
+		return ""
+	}
+	name := cmd[2:]
+	if name[0] != '+' && name[0] != '$' && name[0] != '%' && name[0] != '^' {
+		return ""
+	}
+	if i := strings.IndexAny(name, "=?;"); i > 0 {
+		name = name[:i]
+	}
+	return strings.ToUpper(name)
+}
+
+// finalResult returns true if line is a final result code, and if the
+// result is an error.
+func finalResult(line string) (final bool, failed bool) {
+	switch line {
+	case "OK", "CONNECT", "SEND OK":
+		return true, false
+	case "ERROR", "NO CARRIER", "BUSY", "NO ANSWER", "NO DIALTONE", "SEND FAIL":
+		return true, true
+	}
+	switch {
+	case strings.HasPrefix(line, "CONNECT "):
+		return true, false
+	case strings.HasPrefix(line, "+CME ERROR"), strings.HasPrefix(line, "+CMS ERROR"):
+		return true, true
+	}
+	return false, false
+}
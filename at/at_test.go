@@ -0,0 +1,244 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package at
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// simulateModem answers the commands received on conn with the given
+// responses, echoing the commands. A response starting with ">" sends the
+// prompt and waits for the data, terminated by CtrlZ, before sending the
+// rest of the response.
+func simulateModem(conn net.Conn, responses map[string]string) {
+	r := bufio.NewReader(conn)
+	for {
+		cmd, err := r.ReadString('\r')
+		if err != nil {
+			return
+		}
+		cmd = cmd[:len(cmd)-1]
+		conn.Write([]byte(cmd + "\r"))
+		res, ok := responses[cmd]
+		if !ok {
+			res = "\r\nERROR\r\n"
+		}
+		if len(res) > 0 && res[0] == '>' {
+			conn.Write([]byte("\r\n> "))
+			data, err := r.ReadString(CtrlZ)
+			if err != nil {
+				return
+			}
+			conn.Write([]byte(data))
+			res = res[1:]
+		}
+		conn.Write([]byte(res))
+	}
+}
+
+func newTestModem(t *testing.T, responses map[string]string) *Modem {
+	conn, modemConn := net.Pipe()
+	go simulateModem(modemConn, responses)
+	return NewModem(conn)
+}
+
+func TestExec(t *testing.T) {
+	modem := newTestModem(t, map[string]string{
+		"AT":          "\r\nOK\r\n",
+		"AT+CSQ":      "\r\n+CSQ: 21,99\r\n\r\nOK\r\n",
+		"AT+CGSN":     "\r\n490154203237518\r\n\r\nOK\r\n",
+		"AT+CPIN?":    "\r\n+CME ERROR: 10\r\n",
+		"AT+COPS=?":   "\r\n+COPS: (2,\"vodafone\",\"voda\",\"22210\",7)\r\n\r\nOK\r\n",
+		"ATD+3912345": "\r\nNO CARRIER\r\n",
+	})
+	defer modem.Close()
+	ctx := context.Background()
+
+	res, err := modem.Exec(ctx, "AT")
+	require.NoError(t, err)
+	require.Equal(t, "OK", res.Result)
+	require.Empty(t, res.Lines)
+
+	res, err = modem.Exec(ctx, "AT+CSQ")
+	require.NoError(t, err)
+	require.Equal(t, []string{"+CSQ: 21,99"}, res.Lines)
+
+	res, err = modem.Exec(ctx, "AT+CGSN")
+	require.NoError(t, err)
+	require.Equal(t, []string{"490154203237518"}, res.Lines)
+
+	res, err = modem.Exec(ctx, "AT+CPIN?")
+	require.Error(t, err)
+	require.Equal(t, "+CME ERROR: 10", err.(*Error).Result)
+	require.Equal(t, "+CME ERROR: 10", res.Result)
+
+	res, err = modem.Exec(ctx, "AT+COPS=?")
+	require.NoError(t, err)
+	require.Len(t, res.Lines, 1)
+
+	_, err = modem.Exec(ctx, "ATD+3912345")
+	require.Error(t, err)
+	require.Equal(t, "NO CARRIER", err.(*Error).Result)
+
+	_, err = modem.Exec(ctx, "AT+UNKNOWN")
+	require.Error(t, err)
+	require.Equal(t, "ERROR", err.(*Error).Result)
+}
+
+func TestURCDispatch(t *testing.T) {
+	modem := newTestModem(t, map[string]string{
+		"AT+CREG?": "\r\n+CREG: 0,1\r\n\r\nRING\r\n\r\n+QIURC: \"recv\",0\r\n\r\nOK\r\n",
+		"AT+CSQ":   "\r\n+CREG: 5\r\n\r\n+CSQ: 21,99\r\n\r\nOK\r\n",
+	})
+	defer modem.Close()
+
+	urcs := make(chan string, 10)
+	modem.Handle("RING", func(line string) { urcs <- line })
+	modem.Handle("+QIURC", func(line string) { urcs <- line })
+	modem.Handle("+CREG", func(line string) { urcs <- line })
+
+	// The +CREG line is the response of AT+CREG?
+	res, err := modem.Exec(context.Background(), "AT+CREG?")
+	require.NoError(t, err)
+	require.Equal(t, []string{"+CREG: 0,1"}, res.Lines)
+	require.Equal(t, "RING", <-urcs)
+	require.Equal(t, "+QIURC: \"recv\",0", <-urcs)
+
+	// ...but it's a URC while another command is running
+	res, err = modem.Exec(context.Background(), "AT+CSQ")
+	require.NoError(t, err)
+	require.Equal(t, []string{"+CSQ: 21,99"}, res.Lines)
+	require.Equal(t, "+CREG: 5", <-urcs)
+
+	modem.Handle("+CREG", nil)
+	require.Len(t, modem.handlers, 2)
+}
+
+func TestURCWithoutCommand(t *testing.T) {
+	conn, modemConn := net.Pipe()
+	modem := NewModem(conn)
+	defer modem.Close()
+
+	urcs := make(chan string, 10)
+	modem.Handle("RING", func(line string) { urcs <- line })
+	go modemConn.Write([]byte("\r\nRING\r\n\r\n+UNHANDLED: 1\r\n\r\nRING\r\n"))
+	require.Equal(t, "RING", <-urcs)
+	require.Equal(t, "RING", <-urcs)
+}
+
+func TestExecWithData(t *testing.T) {
+	modem := newTestModem(t, map[string]string{
+		`AT+CMGS="+391234567"`: ">\r\n+CMGS: 5\r\n\r\nOK\r\n",
+		"AT+QISEND=0":          "\r\nERROR\r\n",
+		"AT+QISEND=1":          ">\r\nhello\r\n\r\nOK\r\n",
+	})
+	defer modem.Close()
+	ctx := context.Background()
+
+	res, err := modem.ExecWithData(ctx, `AT+CMGS="+391234567"`, append([]byte("hello"), CtrlZ))
+	require.NoError(t, err)
+	require.Equal(t, []string{"+CMGS: 5"}, res.Lines)
+
+	// The command fails before the prompt
+	_, err = modem.ExecWithData(ctx, "AT+QISEND=0", []byte{CtrlZ})
+	require.Error(t, err)
+
+	// Only the whole lines of the data are taken for its echo
+	res, err = modem.ExecWithData(ctx, "AT+QISEND=1", append([]byte("hello world"), CtrlZ))
+	require.NoError(t, err)
+	require.Equal(t, []string{"hello"}, res.Lines)
+}
+
+func TestExecTimeout(t *testing.T) {
+	modem := newTestModem(t, map[string]string{
+		"AT+SLOW": "",
+	})
+	defer modem.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := modem.Exec(ctx, "AT+SLOW")
+	require.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestExecAbandoned(t *testing.T) {
+	conn, modemConn := net.Pipe()
+	modem := NewModem(conn)
+	defer modem.Close()
+	go func() {
+		r := bufio.NewReader(modemConn)
+		r.ReadString('\r')
+		time.Sleep(100 * time.Millisecond)
+		modemConn.Write([]byte("\r\n+SLOW: 1\r\n\r\nOK\r\n"))
+		r.ReadString('\r')
+		modemConn.Write([]byte("\r\n+CSQ: 21,99\r\n\r\nOK\r\n"))
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := modem.Exec(ctx, "AT+SLOW")
+	require.Equal(t, context.DeadlineExceeded, err)
+
+	// The late answer of AT+SLOW is not taken for the answer of AT+CSQ
+	res, err := modem.Exec(context.Background(), "AT+CSQ")
+	require.NoError(t, err)
+	require.Equal(t, []string{"+CSQ: 21,99"}, res.Lines)
+}
+
+func TestClose(t *testing.T) {
+	modem := newTestModem(t, map[string]string{
+		"AT+SLOW": "",
+	})
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		modem.Close()
+	}()
+	_, err := modem.Exec(context.Background(), "AT+SLOW")
+	require.Equal(t, ErrClosed, err)
+
+	_, err = modem.Exec(context.Background(), "AT")
+	require.Equal(t, ErrClosed, err)
+	require.NoError(t, modem.Close())
+}
+
+func TestResponsePrefix(t *testing.T) {
+	require.Equal(t, "+CSQ", responsePrefix("AT+CSQ"))
+	require.Equal(t, "+CREG", responsePrefix("AT+CREG?"))
+	require.Equal(t, "+CGDCONT", responsePrefix("at+cgdcont=1,\"IP\""))
+	require.Equal(t, "", responsePrefix("ATI"))
+	require.Equal(t, "", responsePrefix("AT"))
+}
+
+func TestExecAbandonedWithoutAnswer(t *testing.T) {
+	modem := newTestModem(t, map[string]string{
+		"AT+HANG": "",
+		"AT":      "\r\nOK\r\n",
+	})
+	defer modem.Close()
+	modem.DrainTimeout = 50 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := modem.Exec(ctx, "AT+HANG")
+	require.Equal(t, context.DeadlineExceeded, err)
+
+	// The modem never answers AT+HANG: it's dropped after the drain timeout
+	ctx2, cancel2 := context.WithTimeout(context.Background(), time.Second)
+	defer cancel2()
+	start := time.Now()
+	res, err := modem.Exec(ctx2, "AT")
+	require.NoError(t, err)
+	require.Equal(t, "OK", res.Result)
+	require.True(t, time.Since(start) >= 20*time.Millisecond)
+}
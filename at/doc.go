@@ -0,0 +1,46 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+/*
+Package at implements an engine to drive modems with AT commands over a
+serial port.
+
+A Modem owns the port: a single goroutine reads all the lines sent by the
+modem and routes each of them either to the command waiting for its
+answer, or to the handler registered for unsolicited result codes (URC):
+
+	modem := at.NewModem(port)
+	defer modem.Close()
+
+	modem.Handle("RING", func(line string) {
+		fmt.Println("incoming call")
+	})
+
+	res, err := modem.Exec(ctx, "AT+CSQ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(res.Lines) // [+CSQ: 21,99]
+
+A line is routed to a URC handler, even while a command is in progress,
+if it starts with a registered prefix that is not the prefix of the
+command response: during "AT+CREG?" a "+CREG: 0,1" line is part of the
+response, while a "RING" or a "+QIURC" line is dispatched to its handler.
+
+The echo of the commands is discarded, so it's not required to disable
+it with ATE0. Commands that wait for a ">" prompt, like AT+CMGS, are sent
+with ExecWithData:
+
+	res, err := modem.ExecWithData(ctx, `AT+CMGS="+391234567"`,
+		append([]byte("hello"), at.CtrlZ))
+
+When the context of a command is done before its final result, Exec
+returns at once, but the next command is sent only after the final result
+of the abandoned one has been received, so that it isn't mistaken for the
+answer of the next command. If the modem doesn't answer within the
+DrainTimeout of the Modem, the abandoned command is dropped.
+*/
+package at
@@ -0,0 +1,220 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+// Package bridge forwards the traffic between two serial ports, to sniff
+// the communication of two devices connected through it.
+//
+// Each device is attached to one of the ports with a null-modem cable, so
+// the DTR and RTS outputs of a device are seen as DSR and CTS on the port
+// it is attached to. The bridge polls these inputs and drives the DTR and
+// RTS outputs of the other port accordingly, when both ports support it.
+//
+// The serial line doesn't carry the mode of the devices: a change of mode
+// is applied to both ports with SetMode.
+//
+//   b := bridge.New(plc, hmi)
+//   b.Traffic = func(t time.Time, dir bridge.Direction, data []byte) {
+//       fmt.Printf("%s %s % X\n", t.Format("15:04:05.000"), dir, data)
+//   }
+//   err := b.Run()
+package bridge
+
+import (
+	"sync"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// Direction is the direction of the data forwarded.
+type Direction int
+
+const (
+	// AToB is the direction from the first port to the second one
+	AToB Direction = iota
+	// BToA is the direction from the second port to the first one
+	BToA
+)
+
+func (d Direction) String() string {
+	if d == AToB {
+		return "A->B"
+	}
+	return "B->A"
+}
+
+const (
+	// DefaultStatusInterval is the default interval between the polls of
+	// the modem status lines
+	DefaultStatusInterval = 100 * time.Millisecond
+	// DefaultBufferSize is the default size of the buffer of each direction
+	DefaultBufferSize = 1024
+)
+
+// Bridge forwards the data and the control lines between two ports.
+type Bridge struct {
+	// StatusInterval is the interval between the polls of the modem status
+	// lines, 0 disables the mirroring of the control lines
+	StatusInterval time.Duration
+	// BufferSize is the size of the buffer of each direction
+	BufferSize int
+	// Traffic, if set, is called with the data read from a port before it
+	// is written to the other one
+	Traffic func(t time.Time, dir Direction, data []byte)
+	// Lines, if set, is called when the DTR and RTS outputs are changed
+	// to follow the DSR and CTS inputs of the other port
+	Lines func(t time.Time, dir Direction, dtr, rts bool)
+
+	ports [2]serial.Port
+	// mu serializes the calls to Traffic and Lines
+	mu        sync.Mutex
+	closed    chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// New creates a new Bridge between the ports a and b. The bridge takes the
+// ownership of the ports and closes them when Run returns.
+func New(a, b serial.Port) *Bridge {
+	return &Bridge{
+		StatusInterval: DefaultStatusInterval,
+		BufferSize:     DefaultBufferSize,
+		ports:          [2]serial.Port{a, b},
+		closed:         make(chan struct{}),
+	}
+}
+
+// ends returns the source and the destination of a direction.
+func (b *Bridge) ends(dir Direction) (serial.Port, serial.Port) {
+	return b.ports[dir], b.ports[1-dir]
+}
+
+// Run forwards the data in both directions until Close is called or an
+// operation on a port fails, then it closes the ports. It returns nil if
+// the bridge has been closed with Close, or the error occurred.
+func (b *Bridge) Run() error {
+	errs := make(chan error, 2)
+	go func() { errs <- b.forward(AToB) }()
+	go func() { errs <- b.forward(BToA) }()
+	var wg sync.WaitGroup
+	if b.StatusInterval > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.mirror()
+		}()
+	}
+
+	err := <-errs
+	select {
+	case <-b.closed:
+		err = nil
+	default:
+	}
+	if closeErr := b.Close(); err == nil {
+		err = closeErr
+	}
+	<-errs
+	wg.Wait()
+	return err
+}
+
+// forward copies the data in a direction until a read or write fails.
+func (b *Bridge) forward(dir Direction) error {
+	src, dst := b.ends(dir)
+	buf := make([]byte, b.BufferSize)
+	for {
+		n, err := src.Read(buf)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			// Read timeout
+			select {
+			case <-b.closed:
+				return nil
+			default:
+				continue
+			}
+		}
+		if b.Traffic != nil {
+			now := time.Now()
+			b.mu.Lock()
+			b.Traffic(now, dir, buf[:n])
+			b.mu.Unlock()
+		}
+		if _, err := dst.Write(buf[:n]); err != nil {
+			return err
+		}
+	}
+}
+
+// mirror polls the modem status lines of both ports until the bridge is
+// closed. The mirroring in a direction stops as soon as one of the ports
+// doesn't support the operations required.
+func (b *Bridge) mirror() {
+	ticker := time.NewTicker(b.StatusInterval)
+	defer ticker.Stop()
+	var last [2]*serial.ModemStatusBits
+	supported := [2]bool{true, true}
+	for {
+		for dir := AToB; dir <= BToA; dir++ {
+			if !supported[dir] {
+				continue
+			}
+			src, dst := b.ends(dir)
+			bits, err := src.GetModemStatusBits()
+			if err != nil {
+				supported[dir] = false
+				continue
+			}
+			if prev := last[dir]; prev != nil && prev.DSR == bits.DSR && prev.CTS == bits.CTS {
+				continue
+			}
+			if dst.SetDTR(bits.DSR) != nil || dst.SetRTS(bits.CTS) != nil {
+				supported[dir] = false
+				continue
+			}
+			last[dir] = bits
+			if b.Lines != nil {
+				now := time.Now()
+				b.mu.Lock()
+				b.Lines(now, dir, bits.DSR, bits.CTS)
+				b.mu.Unlock()
+			}
+		}
+
+		select {
+		case <-b.closed:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// SetMode sets the mode of both ports.
+func (b *Bridge) SetMode(mode *serial.Mode) error {
+	for _, port := range b.ports {
+		if err := port.SetMode(mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close stops the bridge and closes both ports. It returns the first
+// error returned closing the ports.
+func (b *Bridge) Close() error {
+	b.closeOnce.Do(func() {
+		close(b.closed)
+		for _, port := range b.ports {
+			if err := port.Close(); err != nil && b.closeErr == nil {
+				b.closeErr = err
+			}
+		}
+	})
+	return b.closeErr
+}
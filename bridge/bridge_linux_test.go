@@ -0,0 +1,58 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package bridge
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.bug.st/serial"
+	"go.bug.st/serial/internal/ptytest"
+)
+
+func TestBridge(t *testing.T) {
+	mode := &serial.Mode{BaudRate: 9600}
+	plc, a, err := ptytest.Pair(mode)
+	require.NoError(t, err)
+	defer plc.Close()
+	hmi, b, err := ptytest.Pair(mode)
+	require.NoError(t, err)
+	defer hmi.Close()
+
+	bridge := New(a, b)
+	var log bytes.Buffer
+	bridge.Traffic = func(t time.Time, dir Direction, data []byte) {
+		fmt.Fprintf(&log, "%s %q\n", dir, data)
+	}
+	done := make(chan error)
+	go func() { done <- bridge.Run() }()
+
+	exchange := func(src, dst serial.Port, data string) {
+		_, err := src.Write([]byte(data))
+		require.NoError(t, err)
+		buf := make([]byte, len(data))
+		_, err = io.ReadFull(dst, buf)
+		require.NoError(t, err)
+		require.Equal(t, data, string(buf))
+	}
+	exchange(hmi, plc, "\x01\x03\x00\x00\x00\x02")
+	exchange(plc, hmi, "\x01\x03\x04\x00\x2a\x00\x07")
+
+	mode = &serial.Mode{BaudRate: 19200, Parity: serial.EvenParity}
+	require.NoError(t, bridge.SetMode(mode))
+	require.Equal(t, mode, a.Mode())
+	require.Equal(t, mode, b.Mode())
+
+	require.NoError(t, bridge.Close())
+	require.NoError(t, <-done)
+	require.Equal(t, "B->A \"\\x01\\x03\\x00\\x00\\x00\\x02\"\n"+
+		"A->B \"\\x01\\x03\\x04\\x00*\\x00\\a\"\n", log.String())
+}
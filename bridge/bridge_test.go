@@ -0,0 +1,126 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package bridge
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.bug.st/serial"
+)
+
+// linePort is a port without data that reports the modem status set by
+// the test and records the DTR and RTS outputs.
+type linePort struct {
+	serial.Port
+	mu       sync.Mutex
+	status   serial.ModemStatusBits
+	dtr, rts bool
+	closed   chan struct{}
+	readErr  error
+}
+
+func newLinePort() *linePort {
+	return &linePort{closed: make(chan struct{})}
+}
+
+func (p *linePort) Read(b []byte) (int, error) {
+	<-p.closed
+	return 0, p.readErr
+}
+
+func (p *linePort) setStatus(dsr, cts bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.status.DSR = dsr
+	p.status.CTS = cts
+}
+
+func (p *linePort) outputs() (bool, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.dtr, p.rts
+}
+
+func (p *linePort) GetModemStatusBits() (*serial.ModemStatusBits, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	status := p.status
+	return &status, nil
+}
+
+func (p *linePort) SetDTR(dtr bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.dtr = dtr
+	return nil
+}
+
+func (p *linePort) SetRTS(rts bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rts = rts
+	return nil
+}
+
+func (p *linePort) Close() error {
+	select {
+	case <-p.closed:
+	default:
+		close(p.closed)
+	}
+	return nil
+}
+
+func TestMirrorLines(t *testing.T) {
+	a, b := newLinePort(), newLinePort()
+	bridge := New(a, b)
+	bridge.StatusInterval = 5 * time.Millisecond
+	changes := make(chan string, 10)
+	bridge.Lines = func(t time.Time, dir Direction, dtr, rts bool) {
+		changes <- dir.String()
+	}
+	done := make(chan error)
+	go func() { done <- bridge.Run() }()
+	// The initial state is applied to both ports
+	require.ElementsMatch(t, []string{"A->B", "B->A"}, []string{<-changes, <-changes})
+
+	a.setStatus(true, false)
+	require.Equal(t, "A->B", <-changes)
+	dtr, rts := b.outputs()
+	require.True(t, dtr)
+	require.False(t, rts)
+
+	b.setStatus(false, true)
+	require.Equal(t, "B->A", <-changes)
+	dtr, rts = a.outputs()
+	require.False(t, dtr)
+	require.True(t, rts)
+
+	require.NoError(t, bridge.Close())
+	require.NoError(t, <-done)
+}
+
+func TestRunError(t *testing.T) {
+	a, b := newLinePort(), newLinePort()
+	a.readErr = errors.New("device unplugged")
+	bridge := New(a, b)
+	done := make(chan error)
+	go func() { done <- bridge.Run() }()
+
+	// A failure on a port stops the bridge and closes the other port
+	a.Close()
+	require.EqualError(t, <-done, "device unplugged")
+	select {
+	case <-b.closed:
+	default:
+		t.Fatal("port not closed")
+	}
+}
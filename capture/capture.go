@@ -0,0 +1,191 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package capture
+
+import (
+	"sync"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// Op is an operation on a serial port
+type Op string
+
+// Operations recorded
+const (
+	OpStart       Op = "start"
+	OpRX          Op = "rx"
+	OpTX          Op = "tx"
+	OpMode        Op = "mode"
+	OpDTR         Op = "dtr"
+	OpRTS         Op = "rts"
+	OpStatus      Op = "status"
+	OpBreak       Op = "break"
+	OpResetInput  Op = "reset-input"
+	OpResetOutput Op = "reset-output"
+	OpClose       Op = "close"
+)
+
+// Event is an operation recorded. Only the fields related to the operation
+// are set.
+type Event struct {
+	// Time is the time elapsed since the start of the capture
+	Time time.Duration
+	Op   Op
+	// Data is the data received or sent
+	Data []byte
+	// Mode is the mode set
+	Mode *serial.Mode
+	// Value is the state of DTR or RTS
+	Value bool
+	// Status is the state of the modem lines
+	Status *serial.ModemStatusBits
+	// Duration is the duration of a break
+	Duration time.Duration
+	// Start is the wall clock time of the start of the capture
+	Start time.Time
+	// Err is the error returned by the operation, if any
+	Err string
+}
+
+// Writer writes the events of a capture.
+type Writer interface {
+	WriteEvent(e *Event) error
+}
+
+// Port is a serial.Port that records the operations on the wrapped port.
+type Port struct {
+	port  serial.Port
+	w     Writer
+	start time.Time
+
+	mu  sync.Mutex
+	err error
+}
+
+// New returns a Port that records the operations on port with w. The
+// start event is written immediately.
+func New(port serial.Port, w Writer) *Port {
+	p := &Port{port: port, w: w, start: time.Now()}
+	p.record(&Event{Op: OpStart, Start: p.start})
+	return p
+}
+
+// record writes an event, with the time elapsed until now. The first
+// error of the Writer is returned by Close.
+func (p *Port) record(e *Event) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e.Time = time.Since(p.start)
+	if err := p.w.WriteEvent(e); err != nil && p.err == nil {
+		p.err = err
+	}
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// SetMode sets the mode of the port.
+func (p *Port) SetMode(mode *serial.Mode) error {
+	err := p.port.SetMode(mode)
+	m := *mode
+	p.record(&Event{Op: OpMode, Mode: &m, Err: errString(err)})
+	return err
+}
+
+// Read reads from the port.
+func (p *Port) Read(b []byte) (int, error) {
+	n, err := p.port.Read(b)
+	if n > 0 || err != nil {
+		p.record(&Event{Op: OpRX, Data: append([]byte{}, b[:n]...), Err: errString(err)})
+	}
+	return n, err
+}
+
+// Write writes to the port.
+func (p *Port) Write(b []byte) (int, error) {
+	n, err := p.port.Write(b)
+	p.record(&Event{Op: OpTX, Data: append([]byte{}, b[:n]...), Err: errString(err)})
+	return n, err
+}
+
+// ResetInputBuffer purges the input buffer of the port.
+func (p *Port) ResetInputBuffer() error {
+	err := p.port.ResetInputBuffer()
+	p.record(&Event{Op: OpResetInput, Err: errString(err)})
+	return err
+}
+
+// ResetOutputBuffer purges the output buffer of the port.
+func (p *Port) ResetOutputBuffer() error {
+	err := p.port.ResetOutputBuffer()
+	p.record(&Event{Op: OpResetOutput, Err: errString(err)})
+	return err
+}
+
+// SetDTR sets the DTR line.
+func (p *Port) SetDTR(dtr bool) error {
+	err := p.port.SetDTR(dtr)
+	p.record(&Event{Op: OpDTR, Value: dtr, Err: errString(err)})
+	return err
+}
+
+// SetRTS sets the RTS line.
+func (p *Port) SetRTS(rts bool) error {
+	err := p.port.SetRTS(rts)
+	p.record(&Event{Op: OpRTS, Value: rts, Err: errString(err)})
+	return err
+}
+
+// GetModemStatusBits returns the modem lines of the port.
+func (p *Port) GetModemStatusBits() (*serial.ModemStatusBits, error) {
+	bits, err := p.port.GetModemStatusBits()
+	e := &Event{Op: OpStatus, Err: errString(err)}
+	if bits != nil {
+		status := *bits
+		e.Status = &status
+	}
+	p.record(e)
+	return bits, err
+}
+
+// SetReadTimeout sets the read timeout of the port, if it implements
+// serial.ReadTimeouter. It's not recorded.
+func (p *Port) SetReadTimeout(t time.Duration) error {
+	if timeouter, ok := p.port.(serial.ReadTimeouter); ok {
+		return timeouter.SetReadTimeout(t)
+	}
+	return serial.ErrFunctionNotImplemented
+}
+
+// Break sends a break, if the port implements serial.Breaker.
+func (p *Port) Break(d time.Duration) error {
+	var err error = serial.ErrFunctionNotImplemented
+	if breaker, ok := p.port.(serial.Breaker); ok {
+		err = breaker.Break(d)
+	}
+	p.record(&Event{Op: OpBreak, Duration: d, Err: errString(err)})
+	return err
+}
+
+// Close closes the port. If the port is closed successfully it returns
+// the first error occurred writing the capture.
+func (p *Port) Close() error {
+	err := p.port.Close()
+	p.record(&Event{Op: OpClose, Err: errString(err)})
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
@@ -0,0 +1,160 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package capture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.bug.st/serial"
+)
+
+// fakePort returns the data in rx to Read and discards the writes.
+type fakePort struct {
+	serial.Port
+	rx [][]byte
+}
+
+func (p *fakePort) Read(b []byte) (int, error) {
+	if len(p.rx) == 0 {
+		return 0, errors.New("port closed")
+	}
+	n := copy(b, p.rx[0])
+	p.rx = p.rx[1:]
+	return n, nil
+}
+
+func (p *fakePort) Write(b []byte) (int, error)        { return len(b), nil }
+func (p *fakePort) SetMode(*serial.Mode) error         { return nil }
+func (p *fakePort) SetDTR(bool) error                  { return nil }
+func (p *fakePort) SetRTS(bool) error                  { return errors.New("not supported") }
+func (p *fakePort) Break(time.Duration) error          { return nil }
+func (p *fakePort) SetReadTimeout(time.Duration) error { return nil }
+func (p *fakePort) ResetInputBuffer() error            { return nil }
+func (p *fakePort) Close() error                       { return nil }
+func (p *fakePort) GetModemStatusBits() (*serial.ModemStatusBits, error) {
+	return &serial.ModemStatusBits{CTS: true, DCD: true}, nil
+}
+
+// session runs some operations on a captured port.
+func session(t *testing.T, w Writer) {
+	port := New(&fakePort{rx: [][]byte{{}, []byte("\r\nOK\r\n")}}, w)
+	mode, _ := serial.ParseMode("115200,7E1")
+	require.NoError(t, port.SetMode(mode))
+	require.NoError(t, port.SetDTR(false))
+	require.Error(t, port.SetRTS(true))
+	_, err := port.Write([]byte("AT\r"))
+	require.NoError(t, err)
+	require.NoError(t, port.SetReadTimeout(time.Second))
+	buf := make([]byte, 16)
+	n, err := port.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, 0, n)
+	_, err = port.Read(buf)
+	require.NoError(t, err)
+	_, err = port.GetModemStatusBits()
+	require.NoError(t, err)
+	require.NoError(t, port.Break(250*time.Millisecond))
+	require.NoError(t, port.ResetInputBuffer())
+	require.NoError(t, port.Close())
+}
+
+func TestJSONCapture(t *testing.T) {
+	var out bytes.Buffer
+	session(t, NewJSONWriter(&out))
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 10)
+	require.Contains(t, lines[0], `"op":"start","time":"`)
+	require.Contains(t, lines[1], `"op":"mode","mode":"115200,7E1"}`)
+	require.Contains(t, lines[2], `"op":"dtr","value":false}`)
+	require.Contains(t, lines[3], `"op":"rts","value":true,"error":"not supported"}`)
+	require.Contains(t, lines[4], `"op":"tx","data":"41540d"}`)
+	require.Contains(t, lines[5], `"op":"rx","data":"0d0a4f4b0d0a"}`)
+	require.Contains(t, lines[6], `"op":"status","status":{"cts":true,"dsr":false,"ri":false,"dcd":true}}`)
+	require.Contains(t, lines[7], `"op":"break","duration":250000000}`)
+	require.Contains(t, lines[8], `"op":"reset-input"}`)
+	require.Contains(t, lines[9], `"op":"close"}`)
+
+	r := NewJSONReader(&out)
+	var events []*Event
+	for {
+		e, err := r.ReadEvent()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		if len(events) > 0 {
+			require.True(t, e.Time >= events[len(events)-1].Time)
+		}
+		events = append(events, e)
+	}
+	require.Len(t, events, 10)
+	require.False(t, events[0].Start.IsZero())
+	require.Equal(t, "115200,7E1", events[1].Mode.String())
+	require.True(t, events[3].Value)
+	require.Equal(t, "not supported", events[3].Err)
+	require.Equal(t, []byte("AT\r"), events[4].Data)
+	require.Equal(t, &serial.ModemStatusBits{CTS: true, DCD: true}, events[6].Status)
+	require.Equal(t, 250*time.Millisecond, events[7].Duration)
+
+	_, err := NewJSONReader(strings.NewReader(`{"t":0,"op":"rx","data":"zz"}`)).ReadEvent()
+	require.EqualError(t, err, "capture: invalid data in event 1: encoding/hex: invalid byte: U+007A 'z'")
+}
+
+func TestPcapngCapture(t *testing.T) {
+	var out bytes.Buffer
+	w, err := NewPcapngWriter(&out)
+	require.NoError(t, err)
+	session(t, w)
+
+	blocks := [][]byte{}
+	types := []uint32{}
+	data := out.Bytes()
+	for len(data) > 0 {
+		require.True(t, len(data) >= 12)
+		length := binary.LittleEndian.Uint32(data[4:])
+		require.Zero(t, length%4)
+		require.Equal(t, data[4:8], data[length-4:length])
+		types = append(types, binary.LittleEndian.Uint32(data))
+		blocks = append(blocks, data[8:length-4])
+		data = data[length:]
+	}
+	require.Equal(t, []uint32{blockSHB, blockIDB, 6, 6, 6, 6, 6, 6, 6, 6, 6}, types)
+	require.Equal(t, uint32(byteOrderMagic), binary.LittleEndian.Uint32(blocks[0]))
+	require.Equal(t, uint16(LinkTypeUser0), binary.LittleEndian.Uint16(blocks[1]))
+
+	packet := func(i int) ([]byte, []byte) {
+		b := blocks[i]
+		n := binary.LittleEndian.Uint32(b[12:])
+		end := 20 + (n+3)&^3
+		return b[20 : 20+n], b[end:]
+	}
+	p, opts := packet(2)
+	require.Equal(t, "\x02115200,7E1", string(p))
+	require.Empty(t, opts)
+	p, opts = packet(4)
+	require.Equal(t, []byte{4, 1}, p)
+	require.Equal(t, "\x01\x00\x0d\x00not supported\x00\x00\x00\x00\x00\x00\x00", string(opts))
+	p, _ = packet(5)
+	require.Equal(t, "\x01AT\r", string(p))
+	p, _ = packet(6)
+	require.Equal(t, "\x00\r\nOK\r\n", string(p))
+	p, _ = packet(7)
+	require.Equal(t, []byte{5, 0x09}, p)
+
+	// The timestamps are absolute, in nanoseconds
+	b := blocks[2]
+	ts := int64(binary.LittleEndian.Uint32(b[4:]))<<32 | int64(binary.LittleEndian.Uint32(b[8:]))
+	require.WithinDuration(t, time.Now(), time.Unix(0, ts), time.Minute)
+}
@@ -0,0 +1,73 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+/*
+Package capture records the traffic and the control operations of a
+serial port, with the time elapsed since the start of the capture.
+
+A Port wraps a serial.Port and writes an Event for each operation to a
+Writer:
+
+	f, _ := os.Create("session.jsonl")
+	defer f.Close()
+	port = capture.New(port, capture.NewJSONWriter(f))
+
+The reads that return no data (because the read timeout expired) and the
+changes of the read timeout are not recorded.
+
+JSON format
+
+NewJSONWriter writes an event per line as a JSON object with these keys:
+
+	t         nanoseconds since the start of the capture (monotonic clock)
+	op        the operation (see below)
+	data      rx, tx: the bytes transferred, hex encoded
+	mode      mode: the new mode, like "115200,8N1"
+	value     dtr, rts: the new state of the line
+	status    status: the modem lines, like {"cts":true,"dsr":false,"ri":false,"dcd":true}
+	duration  break: the duration in nanoseconds
+	time      start: the wall clock time of the start, in RFC 3339 format
+	error     the error returned by the operation, if any
+
+The operations are:
+
+	start         beginning of the capture, always the first event
+	rx            data received by Read
+	tx            data sent by Write
+	mode          SetMode
+	dtr, rts      SetDTR, SetRTS
+	status        GetModemStatusBits
+	break         Break
+	reset-input   ResetInputBuffer
+	reset-output  ResetOutputBuffer
+	close         Close
+
+For example:
+
+	{"t":0,"op":"start","time":"2020-06-01T10:00:00.123456789Z"}
+	{"t":105220,"op":"tx","data":"41540d"}
+	{"t":3120931,"op":"rx","data":"0d0a4f4b0d0a"}
+
+pcapng format
+
+NewPcapngWriter writes a pcapng file with a single interface of link type
+LinkTypeUser0 (147) and nanosecond timestamps. The start event sets the
+time base and each other event is a packet: the first byte is the code of
+the operation and the rest depends on the operation:
+
+	0 rx, 1 tx        the bytes transferred
+	2 mode            the mode as text, like "115200,8N1"
+	3 dtr, 4 rts      1 byte, 0 or 1
+	5 status          1 byte: CTS 0x01, DSR 0x02, RI 0x04, DCD 0x08
+	6 break           the duration in nanoseconds, 8 bytes little endian
+	7 reset-input, 8 reset-output, 9 close
+	                  nothing
+
+The errors are stored in the comment of the packets. Wireshark opens the
+files as raw data: the dissector in serial_capture.lua, copied in the
+Wireshark plugins folder, decodes the operations.
+*/
+package capture
@@ -0,0 +1,119 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package capture
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// jsonEvent is the JSON representation of an Event.
+type jsonEvent struct {
+	T        int64        `json:"t"`
+	Op       Op           `json:"op"`
+	Data     string       `json:"data,omitempty"`
+	Mode     *serial.Mode `json:"mode,omitempty"`
+	Value    *bool        `json:"value,omitempty"`
+	Status   *jsonStatus  `json:"status,omitempty"`
+	Duration int64        `json:"duration,omitempty"`
+	Time     *time.Time   `json:"time,omitempty"`
+	Error    string       `json:"error,omitempty"`
+}
+
+type jsonStatus struct {
+	CTS bool `json:"cts"`
+	DSR bool `json:"dsr"`
+	RI  bool `json:"ri"`
+	DCD bool `json:"dcd"`
+}
+
+// JSONWriter writes the events as lines of JSON.
+type JSONWriter struct {
+	enc *json.Encoder
+}
+
+// NewJSONWriter creates a new JSONWriter that writes to w.
+func NewJSONWriter(w io.Writer) *JSONWriter {
+	return &JSONWriter{enc: json.NewEncoder(w)}
+}
+
+// WriteEvent writes an event on a line.
+func (w *JSONWriter) WriteEvent(e *Event) error {
+	res := &jsonEvent{
+		T:        int64(e.Time),
+		Op:       e.Op,
+		Data:     hex.EncodeToString(e.Data),
+		Mode:     e.Mode,
+		Duration: int64(e.Duration),
+		Error:    e.Err,
+	}
+	switch e.Op {
+	case OpDTR, OpRTS:
+		value := e.Value
+		res.Value = &value
+	case OpStart:
+		start := e.Start
+		res.Time = &start
+	}
+	if e.Status != nil {
+		res.Status = &jsonStatus{CTS: e.Status.CTS, DSR: e.Status.DSR, RI: e.Status.RI, DCD: e.Status.DCD}
+	}
+	return w.enc.Encode(res)
+}
+
+// JSONReader reads the events written by a JSONWriter.
+type JSONReader struct {
+	dec  *json.Decoder
+	line int
+}
+
+// NewJSONReader creates a new JSONReader that reads from r.
+func NewJSONReader(r io.Reader) *JSONReader {
+	return &JSONReader{dec: json.NewDecoder(r)}
+}
+
+// ReadEvent reads the next event. It returns io.EOF at the end of the
+// capture.
+func (r *JSONReader) ReadEvent() (*Event, error) {
+	var res jsonEvent
+	if err := r.dec.Decode(&res); err != nil {
+		if err == io.EOF {
+			return nil, err
+		}
+		return nil, fmt.Errorf("capture: invalid event %d: %s", r.line+1, err)
+	}
+	r.line++
+	data, err := hex.DecodeString(res.Data)
+	if err != nil {
+		return nil, fmt.Errorf("capture: invalid data in event %d: %s", r.line, err)
+	}
+	e := &Event{
+		Time:     time.Duration(res.T),
+		Op:       res.Op,
+		Mode:     res.Mode,
+		Duration: time.Duration(res.Duration),
+		Err:      res.Error,
+	}
+	if len(data) > 0 {
+		e.Data = data
+	}
+	if res.Value != nil {
+		e.Value = *res.Value
+	}
+	if res.Status != nil {
+		e.Status = &serial.ModemStatusBits{CTS: res.Status.CTS, DSR: res.Status.DSR, RI: res.Status.RI, DCD: res.Status.DCD}
+	}
+	if res.Time != nil {
+		e.Start = *res.Time
+	}
+	return e, nil
+}
@@ -0,0 +1,165 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package capture
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+// LinkTypeUser0 is the link type of the pcapng captures, the first of
+// the link types reserved for private use.
+const LinkTypeUser0 = 147
+
+// pcapng block types and options
+const (
+	blockSHB      uint32 = 0x0A0D0D0A
+	blockIDB      uint32 = 0x00000001
+	blockEPB      uint32 = 0x00000006
+	byteOrderMagic       = 0x1A2B3C4D
+	optEndOfOpt   uint16 = 0
+	optComment    uint16 = 1
+	optTSResol    uint16 = 9
+)
+
+// opCodes are the codes of the operations in the pcapng packets.
+var opCodes = map[Op]byte{
+	OpRX:          0,
+	OpTX:          1,
+	OpMode:        2,
+	OpDTR:         3,
+	OpRTS:         4,
+	OpStatus:      5,
+	OpBreak:       6,
+	OpResetInput:  7,
+	OpResetOutput: 8,
+	OpClose:       9,
+}
+
+// PcapngWriter writes the events as the packets of a pcapng file.
+type PcapngWriter struct {
+	w     io.Writer
+	start time.Time
+}
+
+// NewPcapngWriter creates a new PcapngWriter that writes to w. The file
+// header is written immediately.
+func NewPcapngWriter(w io.Writer) (*PcapngWriter, error) {
+	p := &PcapngWriter{w: w, start: time.Now()}
+
+	shb := make([]byte, 16)
+	binary.LittleEndian.PutUint32(shb[0:], byteOrderMagic)
+	binary.LittleEndian.PutUint16(shb[4:], 1) // major version
+	binary.LittleEndian.PutUint16(shb[6:], 0) // minor version
+	binary.LittleEndian.PutUint64(shb[8:], 0xFFFFFFFFFFFFFFFF)
+	if err := p.writeBlock(blockSHB, shb); err != nil {
+		return nil, err
+	}
+
+	idb := make([]byte, 8)
+	binary.LittleEndian.PutUint16(idb[0:], LinkTypeUser0)
+	// Timestamps in nanoseconds
+	idb = appendOption(idb, optTSResol, []byte{9})
+	idb = appendOption(idb, optEndOfOpt, nil)
+	if err := p.writeBlock(blockIDB, idb); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// appendOption appends an option padded to 32 bits.
+func appendOption(b []byte, code uint16, value []byte) []byte {
+	var header [4]byte
+	binary.LittleEndian.PutUint16(header[0:], code)
+	binary.LittleEndian.PutUint16(header[2:], uint16(len(value)))
+	b = append(b, header[:]...)
+	return pad(append(b, value...))
+}
+
+func pad(b []byte) []byte {
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// writeBlock writes a block with the given type and body, padded to 32
+// bits.
+func (p *PcapngWriter) writeBlock(typ uint32, body []byte) error {
+	length := uint32(12 + len(body))
+	block := make([]byte, 8, length)
+	binary.LittleEndian.PutUint32(block[0:], typ)
+	binary.LittleEndian.PutUint32(block[4:], length)
+	block = append(block, body...)
+	block = append(block, block[4:8]...)
+	_, err := p.w.Write(block)
+	return err
+}
+
+// WriteEvent writes an event as an enhanced packet block. The start event
+// sets the time base of the following packets.
+func (p *PcapngWriter) WriteEvent(e *Event) error {
+	code, ok := opCodes[e.Op]
+	if !ok {
+		if e.Op == OpStart {
+			p.start = e.Start
+		}
+		return nil
+	}
+
+	packet := []byte{code}
+	switch e.Op {
+	case OpRX, OpTX:
+		packet = append(packet, e.Data...)
+	case OpMode:
+		if e.Mode != nil {
+			packet = append(packet, e.Mode.String()...)
+		}
+	case OpDTR, OpRTS:
+		if e.Value {
+			packet = append(packet, 1)
+		} else {
+			packet = append(packet, 0)
+		}
+	case OpStatus:
+		var bits byte
+		if s := e.Status; s != nil {
+			if s.CTS {
+				bits |= 0x01
+			}
+			if s.DSR {
+				bits |= 0x02
+			}
+			if s.RI {
+				bits |= 0x04
+			}
+			if s.DCD {
+				bits |= 0x08
+			}
+		}
+		packet = append(packet, bits)
+	case OpBreak:
+		var d [8]byte
+		binary.LittleEndian.PutUint64(d[:], uint64(e.Duration))
+		packet = append(packet, d[:]...)
+	}
+
+	ts := uint64(p.start.Add(e.Time).UnixNano())
+	body := make([]byte, 20, 20+len(packet)+16)
+	binary.LittleEndian.PutUint32(body[0:], 0) // interface
+	binary.LittleEndian.PutUint32(body[4:], uint32(ts>>32))
+	binary.LittleEndian.PutUint32(body[8:], uint32(ts))
+	binary.LittleEndian.PutUint32(body[12:], uint32(len(packet)))
+	binary.LittleEndian.PutUint32(body[16:], uint32(len(packet)))
+	body = pad(append(body, packet...))
+	if e.Err != "" {
+		body = appendOption(body, optComment, []byte(e.Err))
+		body = appendOption(body, optEndOfOpt, nil)
+	}
+	return p.writeBlock(blockEPB, body)
+}
@@ -0,0 +1,490 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package capture
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+var (
+	// ErrClosed is returned by the operations on a closed Replay
+	ErrClosed = errors.New("capture: replay closed")
+	// ErrInvalidTimeout is returned by SetReadTimeout for negative
+	// timeouts other than serial.NoTimeout
+	ErrInvalidTimeout = errors.New("capture: invalid timeout")
+)
+
+// ReadJSON reads all the events of a capture written by a JSONWriter.
+func ReadJSON(r io.Reader) ([]*Event, error) {
+	jr := NewJSONReader(r)
+	var events []*Event
+	for {
+		e, err := jr.ReadEvent()
+		if err == io.EOF {
+			return events, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+}
+
+// MismatchError is returned when an operation on a Replay doesn't match
+// the capture.
+type MismatchError struct {
+	// Event is the index of the event expected, len(events) after the end
+	// of the capture
+	Event int
+	// Time is the time of the event expected
+	Time time.Duration
+	// Expected and Got describe the operation expected and the one
+	// performed
+	Expected string
+	Got      string
+	// Offset is the position of the first byte that differs in a write,
+	// -1 for the other operations
+	Offset int
+}
+
+func (e *MismatchError) Error() string {
+	res := fmt.Sprintf("capture: mismatch with event %d at %s\n\texpected: %s\n\t     got: %s", e.Event, e.Time, e.Expected, e.Got)
+	if e.Offset >= 0 {
+		res += fmt.Sprintf("\n\t          first difference at byte %d", e.Offset)
+	}
+	return res
+}
+
+// Replay is a serial.Port that plays back a capture: Read returns the
+// data received in the capture and the other operations are checked
+// against the ones recorded.
+//
+// The data received is available to Read after the operations recorded
+// before it have been performed. In strict mode each Write must match a
+// recorded write and the control operations must be performed in the
+// recorded order. In lenient mode only the bytes written are checked, as a
+// continuous stream, and the control operations are accepted as they
+// come.
+type Replay struct {
+	// Strict enables the strict mode
+	Strict bool
+	// RealTime delays the data received as in the capture, relatively to
+	// the last operation matched. Otherwise the data is available as soon
+	// as possible.
+	RealTime bool
+
+	events []*Event
+
+	mu          sync.Mutex
+	changed     chan struct{}
+	out         int // next operation expected
+	outPos      int // bytes of the next write matched (lenient mode)
+	in          int // next data received
+	inPos       int // bytes of the next data received already read
+	base        time.Time
+	baseTime    time.Duration
+	readTimeout time.Duration
+	closed      bool
+	err         error
+}
+
+// NewReplay creates a new Replay of the given events, in lenient mode
+// and without delays.
+func NewReplay(events []*Event) *Replay {
+	return &Replay{
+		events:      events,
+		changed:     make(chan struct{}),
+		out:         -1,
+		in:          -1,
+		base:        time.Now(),
+		readTimeout: serial.NoTimeout,
+	}
+}
+
+// expected returns true if the event must be matched by an operation.
+func (r *Replay) expected(e *Event) bool {
+	switch e.Op {
+	case OpTX:
+		return true
+	case OpStart, OpRX, OpClose:
+		return false
+	}
+	return r.Strict
+}
+
+// next returns the index of the next operation expected.
+func (r *Replay) next() int {
+	if r.out < 0 {
+		r.out = 0
+		r.skip()
+	}
+	return r.out
+}
+
+func (r *Replay) skip() {
+	for r.out < len(r.events) && !r.expected(r.events[r.out]) {
+		r.out++
+	}
+}
+
+// consume marks the next operation expected as performed.
+func (r *Replay) consume() {
+	e := r.events[r.out]
+	r.out++
+	r.outPos = 0
+	r.skip()
+	r.base = time.Now()
+	r.baseTime = e.Time
+	r.notify()
+}
+
+func (r *Replay) notify() {
+	close(r.changed)
+	r.changed = make(chan struct{})
+}
+
+// mismatch records and returns a MismatchError for the next operation
+// expected.
+func (r *Replay) mismatch(got string, offset int) error {
+	err := r.pending(got, offset)
+	if r.err == nil {
+		r.err = err
+	}
+	return err
+}
+
+// pending returns a MismatchError for the next operation expected.
+func (r *Replay) pending(got string, offset int) *MismatchError {
+	err := &MismatchError{Event: r.out, Expected: "end of the capture", Got: got, Offset: offset}
+	if r.out < len(r.events) {
+		e := r.events[r.out]
+		err.Time = e.Time
+		err.Expected = describe(e, r.outPos)
+	}
+	return err
+}
+
+// describe returns a description of the operation of an event, with the
+// data written from pos.
+func describe(e *Event, pos int) string {
+	switch e.Op {
+	case OpTX:
+		return fmt.Sprintf("write %q", e.Data[pos:])
+	case OpMode:
+		if e.Mode != nil {
+			return fmt.Sprintf("set mode %s", e.Mode)
+		}
+	case OpDTR, OpRTS:
+		return fmt.Sprintf("set %s %v", e.Op, e.Value)
+	case OpStatus:
+		return "get modem status"
+	case OpResetInput:
+		return "reset input buffer"
+	case OpResetOutput:
+		return "reset output buffer"
+	}
+	return string(e.Op)
+}
+
+// check matches a control operation in strict mode and returns the event
+// matched, or nil in lenient mode.
+func (r *Replay) check(op Op, got string, match func(e *Event) bool) (*Event, error) {
+	if r.closed {
+		return nil, ErrClosed
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if !r.Strict {
+		return nil, nil
+	}
+	i := r.next()
+	if i == len(r.events) || r.events[i].Op != op || (match != nil && !match(r.events[i])) {
+		return nil, r.mismatch(got, -1)
+	}
+	r.consume()
+	return r.events[i], nil
+}
+
+// recordedError returns the error recorded in an event.
+func recordedError(e *Event) error {
+	if e == nil || e.Err == "" {
+		return nil
+	}
+	return errors.New(e.Err)
+}
+
+// Write checks the data against the writes recorded.
+func (r *Replay) Write(b []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return 0, ErrClosed
+	}
+	if r.err != nil {
+		return 0, r.err
+	}
+	got := fmt.Sprintf("write %q", b)
+	i := r.next()
+
+	if r.Strict {
+		if i == len(r.events) || r.events[i].Op != OpTX {
+			return 0, r.mismatch(got, -1)
+		}
+		e := r.events[i]
+		if d := diff(e.Data, b); d >= 0 {
+			return 0, r.mismatch(got, d)
+		}
+		r.consume()
+		return len(b), recordedError(e)
+	}
+
+	for pos := 0; pos < len(b); {
+		if r.out == len(r.events) {
+			return pos, r.mismatch(fmt.Sprintf("write %q", b[pos:]), -1)
+		}
+		expected := r.events[r.out].Data[r.outPos:]
+		if len(expected) == 0 {
+			r.consume()
+			continue
+		}
+		n := len(b) - pos
+		if n > len(expected) {
+			n = len(expected)
+		}
+		if d := diff(expected[:n], b[pos:pos+n]); d >= 0 {
+			return pos, r.mismatch(fmt.Sprintf("write %q", b[pos:]), d)
+		}
+		pos += n
+		r.outPos += n
+		if r.outPos == len(r.events[r.out].Data) {
+			r.consume()
+		}
+	}
+	return len(b), nil
+}
+
+// diff returns the position of the first byte that differs, or -1 if
+// the data is equal.
+func diff(expected, got []byte) int {
+	for i := range expected {
+		if i == len(got) || expected[i] != got[i] {
+			return i
+		}
+	}
+	if len(got) > len(expected) {
+		return len(expected)
+	}
+	return -1
+}
+
+// Read returns the data received in the capture, once the operations
+// recorded before it have been performed. When there is no data available
+// it waits until the read timeout expires, like a silent device.
+func (r *Replay) Read(b []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var deadline <-chan time.Time
+	if r.readTimeout >= 0 {
+		timer := time.NewTimer(r.readTimeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	for {
+		if r.closed {
+			return 0, ErrClosed
+		}
+		var delay *time.Timer
+		if i := r.nextRX(); i < r.next() {
+			e := r.events[i]
+			wait := time.Duration(0)
+			if r.RealTime && e.Time > r.baseTime {
+				wait = time.Until(r.base.Add(e.Time - r.baseTime))
+			}
+			if wait <= 0 {
+				n := copy(b, e.Data[r.inPos:])
+				r.inPos += n
+				if r.inPos < len(e.Data) {
+					return n, nil
+				}
+				r.in++
+				r.inPos = 0
+				return n, recordedError(e)
+			}
+			delay = time.NewTimer(wait)
+		}
+
+		changed := r.changed
+		r.mu.Unlock()
+		if delay == nil {
+			select {
+			case <-changed:
+			case <-deadline:
+				r.mu.Lock()
+				return 0, nil
+			}
+		} else {
+			select {
+			case <-changed:
+			case <-delay.C:
+			case <-deadline:
+				delay.Stop()
+				r.mu.Lock()
+				return 0, nil
+			}
+			delay.Stop()
+		}
+		r.mu.Lock()
+	}
+}
+
+// nextRX returns the index of the next data received, len(events) if
+// there is no more data.
+func (r *Replay) nextRX() int {
+	if r.in < 0 {
+		r.in = 0
+	}
+	for r.in < len(r.events) && r.events[r.in].Op != OpRX {
+		r.in++
+	}
+	return r.in
+}
+
+// SetMode checks the mode against the capture.
+func (r *Replay) SetMode(mode *serial.Mode) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e, err := r.check(OpMode, fmt.Sprintf("set mode %s", mode), func(e *Event) bool {
+		return e.Mode != nil && e.Mode.String() == mode.String()
+	})
+	if err != nil {
+		return err
+	}
+	return recordedError(e)
+}
+
+// SetDTR checks the DTR change against the capture.
+func (r *Replay) SetDTR(dtr bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e, err := r.check(OpDTR, fmt.Sprintf("set dtr %v", dtr), func(e *Event) bool { return e.Value == dtr })
+	if err != nil {
+		return err
+	}
+	return recordedError(e)
+}
+
+// SetRTS checks the RTS change against the capture.
+func (r *Replay) SetRTS(rts bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e, err := r.check(OpRTS, fmt.Sprintf("set rts %v", rts), func(e *Event) bool { return e.Value == rts })
+	if err != nil {
+		return err
+	}
+	return recordedError(e)
+}
+
+// Break checks the break against the capture, the duration is not
+// checked.
+func (r *Replay) Break(d time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e, err := r.check(OpBreak, "break", nil)
+	if err != nil {
+		return err
+	}
+	return recordedError(e)
+}
+
+// ResetInputBuffer checks the reset against the capture.
+func (r *Replay) ResetInputBuffer() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e, err := r.check(OpResetInput, "reset input buffer", nil)
+	if err != nil {
+		return err
+	}
+	return recordedError(e)
+}
+
+// ResetOutputBuffer checks the reset against the capture.
+func (r *Replay) ResetOutputBuffer() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e, err := r.check(OpResetOutput, "reset output buffer", nil)
+	if err != nil {
+		return err
+	}
+	return recordedError(e)
+}
+
+// GetModemStatusBits returns the modem lines recorded. In lenient mode
+// they are the last ones recorded before the next write expected.
+func (r *Replay) GetModemStatusBits() (*serial.ModemStatusBits, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e, err := r.check(OpStatus, "get modem status", nil)
+	if err != nil {
+		return nil, err
+	}
+	if e == nil {
+		for i := r.next() - 1; i >= 0 && e == nil; i-- {
+			if r.events[i].Op == OpStatus {
+				e = r.events[i]
+			}
+		}
+	}
+	if e == nil || e.Status == nil {
+		return &serial.ModemStatusBits{}, recordedError(e)
+	}
+	status := *e.Status
+	return &status, recordedError(e)
+}
+
+// SetReadTimeout sets the timeout of Read.
+func (r *Replay) SetReadTimeout(timeout time.Duration) error {
+	if timeout < 0 && timeout != serial.NoTimeout {
+		return ErrInvalidTimeout
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.readTimeout = timeout
+	return nil
+}
+
+// Close closes the Replay, the pending reads return ErrClosed.
+func (r *Replay) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.closed {
+		r.closed = true
+		r.notify()
+	}
+	return nil
+}
+
+// Verify returns the first mismatch occurred, or a MismatchError if some
+// operations of the capture have not been performed.
+func (r *Replay) Verify() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	if r.next() < len(r.events) {
+		return r.pending("end of the replay", -1)
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package capture
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.bug.st/serial"
+)
+
+const modemSession = `{"t":0,"op":"start","time":"2020-06-01T10:00:00Z"}
+{"t":1000,"op":"mode","mode":"115200,8N1"}
+{"t":2000,"op":"dtr","value":true}
+{"t":3000,"op":"tx","data":"41540d"}
+{"t":100000000,"op":"rx","data":"0d0a4f4b0d0a"}
+{"t":100001000,"op":"status","status":{"cts":true,"dsr":true,"ri":false,"dcd":false}}
+{"t":100002000,"op":"tx","data":"41542b4353513f0d"}
+{"t":150000000,"op":"rx","data":"0d0a2b4353513a2032312c39390d0a"}
+{"t":150000100,"op":"rx","data":"0d0a4f4b0d0a"}
+{"t":200000000,"op":"close"}
+`
+
+func newTestReplay(t *testing.T) *Replay {
+	events, err := ReadJSON(strings.NewReader(modemSession))
+	require.NoError(t, err)
+	require.Len(t, events, 10)
+	return NewReplay(events)
+}
+
+func TestReplayStrict(t *testing.T) {
+	r := newTestReplay(t)
+	r.Strict = true
+	reader := serial.NewReader(r)
+
+	// The data received is not available before the write
+	line, err := reader.ReadLine(20 * time.Millisecond)
+	require.Equal(t, serial.ReadTimeout, err.(*serial.PortError).Code())
+	require.Empty(t, line)
+
+	mode, _ := serial.ParseMode("115200,8N1")
+	require.NoError(t, r.SetMode(mode))
+	require.NoError(t, r.SetDTR(true))
+	_, err = r.Write([]byte("AT\r"))
+	require.NoError(t, err)
+	line, err = reader.ReadLine(time.Second)
+	require.NoError(t, err)
+	require.Equal(t, "", line)
+	line, err = reader.ReadLine(time.Second)
+	require.NoError(t, err)
+	require.Equal(t, "OK", line)
+	bits, err := r.GetModemStatusBits()
+	require.NoError(t, err)
+	require.Equal(t, &serial.ModemStatusBits{CTS: true, DSR: true}, bits)
+
+	// The writes must have the same size of the recorded ones
+	_, err = r.Write([]byte("AT+"))
+	require.EqualError(t, err, `capture: mismatch with event 6 at 100.002ms
+	expected: write "AT+CSQ?\r"
+	     got: write "AT+"
+	          first difference at byte 3`)
+	// After a mismatch every operation fails
+	_, err = r.Write([]byte("AT+CSQ?\r"))
+	require.Equal(t, err, r.Verify())
+}
+
+func TestReplayStrictControl(t *testing.T) {
+	r := newTestReplay(t)
+	r.Strict = true
+	err := r.SetDTR(true)
+	require.EqualError(t, err, `capture: mismatch with event 1 at 1µs
+	expected: set mode 115200,8N1
+	     got: set dtr true`)
+	mismatch := err.(*MismatchError)
+	require.Equal(t, -1, mismatch.Offset)
+}
+
+func TestReplayLenient(t *testing.T) {
+	r := newTestReplay(t)
+	require.NoError(t, r.SetReadTimeout(time.Second))
+
+	// The control operations are not checked and the writes can be split
+	// differently
+	require.NoError(t, r.SetDTR(false))
+	_, err := r.Write([]byte("A"))
+	require.NoError(t, err)
+	_, err = r.Write([]byte("T\rAT+C"))
+	require.NoError(t, err)
+	buf := make([]byte, 64)
+	n, err := r.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "\r\nOK\r\n", string(buf[:n]))
+	bits, err := r.GetModemStatusBits()
+	require.NoError(t, err)
+	require.True(t, bits.CTS)
+
+	// Verify reports the operations missing
+	require.EqualError(t, r.Verify(), `capture: mismatch with event 6 at 100.002ms
+	expected: write "SQ?\r"
+	     got: end of the replay`)
+
+	_, err = r.Write([]byte("SQ?\r"))
+	require.NoError(t, err)
+	var received bytes.Buffer
+	for received.Len() < 21 {
+		n, err := r.Read(buf)
+		require.NoError(t, err)
+		received.Write(buf[:n])
+	}
+	require.Equal(t, "\r\n+CSQ: 21,99\r\n\r\nOK\r\n", received.String())
+	require.NoError(t, r.Verify())
+
+	_, err = r.Write([]byte("ATZ\r"))
+	require.EqualError(t, err, `capture: mismatch with event 10 at 0s
+	expected: end of the capture
+	     got: write "ATZ\r"`)
+}
+
+func TestReplayTiming(t *testing.T) {
+	for _, realTime := range []bool{false, true} {
+		r := newTestReplay(t)
+		r.RealTime = realTime
+		_, err := r.Write([]byte("AT\r"))
+		require.NoError(t, err)
+		start := time.Now()
+		n, err := r.Read(make([]byte, 64))
+		require.NoError(t, err)
+		require.Equal(t, 6, n)
+		elapsed := time.Since(start)
+		if realTime {
+			// The response was received 100ms after the write
+			require.True(t, elapsed > 90*time.Millisecond, elapsed)
+		} else {
+			require.True(t, elapsed < 50*time.Millisecond, elapsed)
+		}
+	}
+}
+
+func TestReplayClose(t *testing.T) {
+	r := newTestReplay(t)
+	read := make(chan error)
+	go func() {
+		_, err := r.Read(make([]byte, 16))
+		read <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	require.NoError(t, r.Close())
+	require.Equal(t, ErrClosed, <-read)
+	_, err := r.Write([]byte("AT\r"))
+	require.Equal(t, ErrClosed, err)
+	require.Equal(t, ErrInvalidTimeout, r.SetReadTimeout(-2))
+}
+
+// TestCaptureAndReplay records a session and plays it back.
+func TestCaptureAndReplay(t *testing.T) {
+	var out bytes.Buffer
+	session(t, NewJSONWriter(&out))
+	events, err := ReadJSON(&out)
+	require.NoError(t, err)
+
+	r := NewReplay(events)
+	r.Strict = true
+	r.SetReadTimeout(0)
+	// The first read of the session returned no data and wasn't recorded
+	_, err = r.Read(make([]byte, 16))
+	require.NoError(t, err)
+	mode, _ := serial.ParseMode("115200,7E1")
+	require.NoError(t, r.SetMode(mode))
+	require.NoError(t, r.SetDTR(false))
+	require.EqualError(t, r.SetRTS(true), "not supported")
+	_, err = r.Write([]byte("AT\r"))
+	require.NoError(t, err)
+	n, err := r.Read(make([]byte, 16))
+	require.NoError(t, err)
+	require.Equal(t, 6, n)
+	_, err = r.GetModemStatusBits()
+	require.NoError(t, err)
+	require.NoError(t, r.Break(time.Second))
+	require.NoError(t, r.ResetInputBuffer())
+	require.NoError(t, r.Verify())
+}
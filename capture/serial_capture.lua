@@ -0,0 +1,62 @@
+--
+-- Copyright 2014-2020 Cristian Maglie. All rights reserved.
+-- Use of this source code is governed by a BSD-style
+-- license that can be found in the LICENSE file.
+--
+
+-- Wireshark dissector for the pcapng captures of go.bug.st/serial/capture,
+-- recorded with the link type USER0 (147). Copy it in the Wireshark
+-- plugins folder.
+
+local proto = Proto("serialcap", "Serial port capture")
+
+local ops = {
+	[0] = "RX", [1] = "TX", [2] = "Mode", [3] = "DTR", [4] = "RTS",
+	[5] = "Modem status", [6] = "Break", [7] = "Reset input",
+	[8] = "Reset output", [9] = "Close",
+}
+
+local f_op = ProtoField.uint8("serialcap.op", "Operation", base.DEC, ops)
+local f_data = ProtoField.bytes("serialcap.data", "Data")
+local f_mode = ProtoField.string("serialcap.mode", "Mode")
+local f_value = ProtoField.uint8("serialcap.value", "Value", base.DEC, { [0] = "off", [1] = "on" })
+local f_status = ProtoField.uint8("serialcap.status", "Modem status", base.HEX)
+local f_cts = ProtoField.bool("serialcap.status.cts", "CTS", 8, nil, 0x01)
+local f_dsr = ProtoField.bool("serialcap.status.dsr", "DSR", 8, nil, 0x02)
+local f_ri = ProtoField.bool("serialcap.status.ri", "RI", 8, nil, 0x04)
+local f_dcd = ProtoField.bool("serialcap.status.dcd", "DCD", 8, nil, 0x08)
+local f_duration = ProtoField.uint64("serialcap.duration", "Duration (ns)")
+
+proto.fields = { f_op, f_data, f_mode, f_value, f_status, f_cts, f_dsr, f_ri, f_dcd, f_duration }
+
+function proto.dissector(buf, pinfo, tree)
+	pinfo.cols.protocol = "SERIAL"
+	local op = buf(0, 1):uint()
+	local t = tree:add(proto, buf())
+	t:add(f_op, buf(0, 1))
+	local info = ops[op] or "Unknown"
+	local n = buf:len() - 1
+
+	if (op == 0 or op == 1) and n > 0 then
+		t:add(f_data, buf(1, n))
+		info = info .. " " .. n .. " bytes"
+	elseif op == 2 and n > 0 then
+		t:add(f_mode, buf(1, n))
+		info = info .. " " .. buf(1, n):string()
+	elseif (op == 3 or op == 4) and n > 0 then
+		t:add(f_value, buf(1, 1))
+		info = info .. (buf(1, 1):uint() == 1 and " on" or " off")
+	elseif op == 5 and n > 0 then
+		local s = t:add(f_status, buf(1, 1))
+		s:add(f_cts, buf(1, 1))
+		s:add(f_dsr, buf(1, 1))
+		s:add(f_ri, buf(1, 1))
+		s:add(f_dcd, buf(1, 1))
+	elseif op == 6 and n >= 8 then
+		t:add_le(f_duration, buf(1, 8))
+	end
+	pinfo.cols.info = info
+end
+
+local encaps = wtap_encaps or wtap
+DissectorTable.get("wtap_encap"):add(encaps.USER0, proto)
@@ -0,0 +1,96 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+// modbusgw is a Modbus TCP to Modbus RTU gateway: it accepts Modbus TCP
+// connections and forwards the requests to the devices attached to a
+// serial port.
+//
+// $ modbusgw -port /dev/ttyUSB0 -mode 19200,8E1 -listen :502 -unit-timeout 7=2s
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.bug.st/serial"
+	"go.bug.st/serial/modbus"
+)
+
+// unitTimeouts is a flag.Value that collects a list of unit=timeout pairs
+type unitTimeouts map[byte]time.Duration
+
+func (u unitTimeouts) String() string {
+	res := []string{}
+	for unit, timeout := range u {
+		res = append(res, fmt.Sprintf("%d=%s", unit, timeout))
+	}
+	return strings.Join(res, ",")
+}
+
+func (u unitTimeouts) Set(value string) error {
+	for _, item := range strings.Split(value, ",") {
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid unit timeout %q, expected unit=timeout", item)
+		}
+		unit, err := strconv.ParseUint(parts[0], 10, 8)
+		if err != nil {
+			return fmt.Errorf("invalid unit %q", parts[0])
+		}
+		timeout, err := time.ParseDuration(parts[1])
+		if err != nil {
+			return err
+		}
+		u[byte(unit)] = timeout
+	}
+	return nil
+}
+
+func main() {
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run() error {
+	portName := flag.String("port", "", "serial port connected to the RTU bus")
+	modeString := flag.String("mode", "9600,8E1", "serial port configuration")
+	listen := flag.String("listen", ":502", "TCP address to listen on")
+	timeout := flag.Duration("timeout", modbus.DefaultTimeout, "response timeout of the RTU devices")
+	retries := flag.Int("retries", 0, "number of retries after a timeout or a corrupted response")
+	perUnit := unitTimeouts{}
+	flag.Var(perUnit, "unit-timeout", "response timeout of specific units, as a list of unit=timeout")
+	flag.Parse()
+
+	if *portName == "" {
+		return errors.New("a serial port must be specified with -port")
+	}
+	mode, err := serial.ParseMode(*modeString)
+	if err != nil {
+		return err
+	}
+	port, err := serial.Open(*portName, mode)
+	if err != nil {
+		return err
+	}
+
+	client := modbus.NewClient(port, mode)
+	defer client.Close()
+	client.Timeout = *timeout
+	client.Retries = *retries
+	for unit, timeout := range perUnit {
+		client.SetUnitTimeout(unit, timeout)
+	}
+
+	log.Printf("Forwarding Modbus TCP requests from %s to %s (%s)", *listen, *portName, mode)
+	gateway := modbus.NewGateway(client)
+	return gateway.ListenAndServe(*listen)
+}
@@ -0,0 +1,140 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+// serialsniff forwards the traffic between two serial ports and logs it,
+// to sniff the communication of two devices connected through it.
+//
+// $ serialsniff -a /dev/ttyUSB0 -b /dev/ttyUSB1 -mode 19200,8E1
+//
+// The data is logged with its direction and timestamp, together with the
+// changes of the control lines mirrored between the ports. A mode typed on
+// the standard input, like 9600,8N1, is applied to both ports.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"time"
+
+	"go.bug.st/serial"
+	"go.bug.st/serial/bridge"
+	"go.bug.st/serial/capture"
+)
+
+const timeFormat = "15:04:05.000000"
+
+// dump writes data as lines of 16 bytes, in hexadecimal and as text.
+func dump(w io.Writer, t time.Time, dir bridge.Direction, data []byte) {
+	prefix := fmt.Sprintf("%s %s", t.Format(timeFormat), dir)
+	for len(data) > 0 {
+		n := len(data)
+		if n > 16 {
+			n = 16
+		}
+		var hex, text strings.Builder
+		for _, b := range data[:n] {
+			fmt.Fprintf(&hex, " %02X", b)
+			if b >= 0x20 && b < 0x7F {
+				text.WriteByte(b)
+			} else {
+				text.WriteByte('.')
+			}
+		}
+		fmt.Fprintf(w, "%s %-48s  |%s|\n", prefix, hex.String(), text.String())
+		prefix = strings.Repeat(" ", len(prefix))
+		data = data[n:]
+	}
+}
+
+func main() {
+	portA := flag.String("a", "", "serial port of the first device")
+	portB := flag.String("b", "", "serial port of the second device")
+	modeString := flag.String("mode", "9600,8N1", "serial port configuration of both ports")
+	status := flag.Duration("status", bridge.DefaultStatusInterval, "polling interval of the modem status lines, 0 to disable their mirroring")
+	capturePath := flag.String("capture", "", "also record the traffic of the first port in a JSON lines capture")
+	flag.Parse()
+
+	if *portA == "" || *portB == "" {
+		log.Fatal("both serial ports must be specified with -a and -b")
+	}
+	mode, err := serial.ParseMode(*modeString)
+	if err != nil {
+		log.Fatal(err)
+	}
+	a, err := serial.Open(*portA, mode)
+	if err != nil {
+		log.Fatal(err)
+	}
+	b, err := serial.Open(*portB, mode)
+	if err != nil {
+		a.Close()
+		log.Fatal(err)
+	}
+	if *capturePath != "" {
+		f, err := os.Create(*capturePath)
+		if err != nil {
+			a.Close()
+			b.Close()
+			log.Fatal(err)
+		}
+		defer f.Close()
+		a = capture.New(a, capture.NewJSONWriter(f))
+	}
+
+	var out sync.Mutex
+	printf := func(format string, args ...interface{}) {
+		out.Lock()
+		defer out.Unlock()
+		fmt.Printf(format, args...)
+	}
+	sniffer := bridge.New(a, b)
+	sniffer.StatusInterval = *status
+	sniffer.Traffic = func(t time.Time, dir bridge.Direction, data []byte) {
+		out.Lock()
+		defer out.Unlock()
+		dump(os.Stdout, t, dir, data)
+	}
+	sniffer.Lines = func(t time.Time, dir bridge.Direction, dtr, rts bool) {
+		printf("%s %s DTR=%v RTS=%v\n", t.Format(timeFormat), dir, dtr, rts)
+	}
+	printf("--- A=%s B=%s %s ---\n", *portA, *portB, mode)
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		sniffer.Close()
+	}()
+	go func() {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" {
+				continue
+			}
+			mode, err := serial.ParseMode(line)
+			if err == nil {
+				err = sniffer.SetMode(mode)
+			}
+			if err != nil {
+				printf("--- %s ---\n", err)
+				continue
+			}
+			printf("%s --- mode %s ---\n", time.Now().Format(timeFormat), mode)
+		}
+	}()
+
+	if err := sniffer.Run(); err != nil {
+		log.Fatal(err)
+	}
+}
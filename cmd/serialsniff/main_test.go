@@ -0,0 +1,26 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.bug.st/serial/bridge"
+)
+
+func TestDump(t *testing.T) {
+	var out strings.Builder
+	ts := time.Date(2020, 6, 1, 10, 30, 15, 123456000, time.UTC)
+	dump(&out, ts, bridge.BToA, []byte("\x01\x03 data of the request\r\n"))
+	require.Equal(t, ""+
+		"10:30:15.123456 B->A  01 03 20 64 61 74 61 20 6F 66 20 74 68 65 20 72  |.. data of the r|\n"+
+		"                      65 71 75 65 73 74 0D 0A                          |equest..|\n",
+		out.String())
+}
@@ -0,0 +1,112 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+// serialterm is an interactive terminal for serial ports: the keys pressed
+// are sent to the port and the data received is displayed.
+//
+// $ serialterm -port /dev/ttyUSB0 -mode 115200,8N1 -eol crlf -recv-eol crlf
+//
+// Ctrl-] exits, Ctrl-T opens a menu to toggle DTR and RTS, send a BREAK,
+// change the baud rate, the local echo and the hex display. The modem
+// status lines are displayed when they change.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+func main() {
+	portName := flag.String("port", "", "serial port to open")
+	modeString := flag.String("mode", "9600,8N1", "serial port configuration")
+	echo := flag.Bool("echo", false, "display the characters typed")
+	hex := flag.Bool("hex", false, "display the data received as hexadecimal")
+	eol := flag.String("eol", "cr", "line ending sent by Enter: cr, lf or crlf")
+	recvEOL := flag.String("recv-eol", "raw", "line ending of the data received, displayed as a new line: raw, cr, lf or crlf")
+	status := flag.Duration("status", 200*time.Millisecond, "polling interval of the modem status lines, 0 to disable")
+	flag.Parse()
+
+	if *portName == "" {
+		log.Fatal("a serial port must be specified with -port")
+	}
+	mode, err := serial.ParseMode(*modeString)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sendEOL, ok := lineEndings[*eol]
+	if !ok || sendEOL == "" {
+		log.Fatalf("invalid line ending %q", *eol)
+	}
+	displayEOL, ok := lineEndings[*recvEOL]
+	if !ok {
+		log.Fatalf("invalid line ending %q", *recvEOL)
+	}
+
+	port, err := serial.Open(*portName, mode)
+	if err != nil {
+		log.Fatal(err)
+	}
+	term := newTerminal(port, mode, os.Stdout)
+	term.eol = sendEOL
+	term.recvEOL = displayEOL
+	term.echo = *echo
+	term.hex = *hex
+
+	restore, err := makeRaw()
+	if err != nil {
+		port.Close()
+		log.Fatal(err)
+	}
+	fmt.Printf("--- %s %s | Ctrl-] exit, Ctrl-T h help ---\r\n", *portName, mode)
+
+	errs := make(chan error, 2)
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, err := port.Read(buf)
+			if err != nil {
+				errs <- err
+				return
+			}
+			term.receive(buf[:n])
+		}
+	}()
+	go func() {
+		buf := make([]byte, 256)
+		for {
+			n, err := os.Stdin.Read(buf)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if cont, err := term.input(buf[:n]); !cont || err != nil {
+				errs <- err
+				return
+			}
+		}
+	}()
+	if *status > 0 {
+		go func() {
+			for {
+				term.updateStatus()
+				time.Sleep(*status)
+			}
+		}()
+	}
+
+	err = <-errs
+	restore()
+	port.Close()
+	if err != nil && err != io.EOF {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,39 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// makeRaw puts the console in raw mode and returns a function that
+// restores its previous state. The escape sequences are passed through in
+// both directions where the console supports them.
+func makeRaw() (func(), error) {
+	in := windows.Handle(os.Stdin.Fd())
+	out := windows.Handle(os.Stdout.Fd())
+	var inMode, outMode uint32
+	if err := windows.GetConsoleMode(in, &inMode); err != nil {
+		return nil, err
+	}
+	raw := inMode &^ (windows.ENABLE_ECHO_INPUT | windows.ENABLE_LINE_INPUT | windows.ENABLE_PROCESSED_INPUT)
+	if err := windows.SetConsoleMode(in, raw|windows.ENABLE_VIRTUAL_TERMINAL_INPUT); err != nil {
+		// Older consoles don't support the escape sequences
+		if err := windows.SetConsoleMode(in, raw); err != nil {
+			return nil, err
+		}
+	}
+	if windows.GetConsoleMode(out, &outMode) == nil {
+		windows.SetConsoleMode(out, outMode|windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING)
+	}
+	return func() {
+		windows.SetConsoleMode(in, inMode)
+		windows.SetConsoleMode(out, outMode)
+	}, nil
+}
@@ -0,0 +1,346 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// Special keys
+const (
+	menuKey byte = 0x14 // Ctrl-T
+	exitKey byte = 0x1D // Ctrl-]
+	escKey  byte = 0x1B
+	bsKey   byte = 0x08
+	delKey  byte = 0x7F
+)
+
+// breakDuration is the duration of the breaks sent from the menu.
+const breakDuration = 250 * time.Millisecond
+
+// lineEndings are the values accepted for the -eol and -recv-eol flags.
+var lineEndings = map[string]string{
+	"raw":  "",
+	"cr":   "\r",
+	"lf":   "\n",
+	"crlf": "\r\n",
+}
+
+// inputState is the state of the keyboard input.
+type inputState int
+
+const (
+	passThrough inputState = iota
+	menu
+	modePrompt
+)
+
+// terminal connects the keyboard and the screen to a serial port.
+type terminal struct {
+	port serial.Port
+	out  io.Writer
+	// eol is sent when Enter is pressed
+	eol string
+	// recvEOL is the line ending of the received data, translated to a
+	// new line on the screen ("" to display the data unchanged)
+	recvEOL string
+
+	mu   sync.Mutex
+	mode serial.Mode
+	echo bool
+	hex  bool
+	// column is the number of bytes on the current line in hex mode, in
+	// text mode it's 1 if the cursor is not at the start of a line
+	column  int
+	lastCR  bool
+	dtr     bool
+	rts     bool
+	status  *serial.ModemStatusBits
+	state   inputState
+	command []byte
+}
+
+func newTerminal(port serial.Port, mode *serial.Mode, out io.Writer) *terminal {
+	return &terminal{
+		port: port,
+		mode: *mode,
+		out:  out,
+		eol:  "\r",
+		dtr:  true,
+		rts:  true,
+	}
+}
+
+// printf writes a message of the terminal on a line of its own.
+func (t *terminal) printf(format string, args ...interface{}) {
+	if t.column > 0 {
+		io.WriteString(t.out, "\r\n")
+		t.column = 0
+	}
+	fmt.Fprintf(t.out, "--- "+format+" ---\r\n", args...)
+}
+
+// receive displays the data received from the port.
+func (t *terminal) receive(data []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.hex {
+		t.writeHex(data)
+		return
+	}
+	if t.recvEOL == "" {
+		t.writeText(data)
+		return
+	}
+	res := make([]byte, 0, len(data)*2)
+	for _, b := range data {
+		switch {
+		case b == '\r' && t.recvEOL == "\r":
+			res = append(res, '\r', '\n')
+		case b == '\n' && t.recvEOL == "\n":
+			res = append(res, '\r', '\n')
+		case b == '\n' && t.recvEOL == "\r\n" && t.lastCR:
+			res = append(res, '\r', '\n')
+		case b == '\r' && t.recvEOL == "\r\n":
+			// Wait for the LF
+		case b == '\n' || b == '\r':
+			// Line endings of other kinds are ignored
+		default:
+			if t.lastCR && t.recvEOL == "\r\n" {
+				res = append(res, '\r')
+			}
+			res = append(res, b)
+		}
+		t.lastCR = b == '\r'
+	}
+	t.writeText(res)
+}
+
+// writeText displays text and keeps track of the line start.
+func (t *terminal) writeText(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	t.out.Write(data)
+	if data[len(data)-1] == '\n' {
+		t.column = 0
+	} else {
+		t.column = 1
+	}
+}
+
+// writeHex displays data as hexadecimal, 16 bytes per line.
+func (t *terminal) writeHex(data []byte) {
+	var res strings.Builder
+	for _, b := range data {
+		fmt.Fprintf(&res, "%02X ", b)
+		t.column++
+		if t.column == 16 {
+			res.WriteString("\r\n")
+			t.column = 0
+		}
+	}
+	io.WriteString(t.out, res.String())
+}
+
+// input handles the keys pressed by the user. It returns false when the
+// user asks to exit.
+func (t *terminal) input(keys []byte) (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var send []byte
+	flush := func() error {
+		if len(send) == 0 {
+			return nil
+		}
+		if t.echo {
+			t.writeEcho(send)
+		}
+		_, err := t.port.Write(send)
+		send = send[:0]
+		return err
+	}
+
+	for _, key := range keys {
+		switch t.state {
+		case passThrough:
+			switch key {
+			case exitKey:
+				return false, flush()
+			case menuKey:
+				if err := flush(); err != nil {
+					return false, err
+				}
+				t.state = menu
+			case '\r':
+				send = append(send, t.eol...)
+			default:
+				send = append(send, key)
+			}
+		case menu:
+			t.state = passThrough
+			if key == menuKey {
+				send = append(send, key)
+				continue
+			}
+			cont, err := t.menu(key)
+			if !cont || err != nil {
+				return cont, err
+			}
+		case modePrompt:
+			t.promptKey(key)
+		}
+	}
+	return true, flush()
+}
+
+// writeEcho displays the data sent.
+func (t *terminal) writeEcho(data []byte) {
+	if t.hex {
+		t.writeHex(data)
+		return
+	}
+	text := strings.Replace(string(data), "\r\n", "\n", -1)
+	text = strings.Replace(strings.Replace(text, "\r", "\n", -1), "\n", "\r\n", -1)
+	t.writeText([]byte(text))
+}
+
+// menu executes the command of the key pressed after the menu key.
+func (t *terminal) menu(key byte) (bool, error) {
+	// Commands can be given with or without Ctrl
+	if key < 0x20 {
+		key |= 0x60
+	}
+	switch key {
+	case 'q':
+		return false, nil
+	case 'd':
+		if err := t.port.SetDTR(!t.dtr); err != nil {
+			return false, err
+		}
+		t.dtr = !t.dtr
+		t.printf("DTR %s", onOff(t.dtr))
+	case 'r':
+		if err := t.port.SetRTS(!t.rts); err != nil {
+			return false, err
+		}
+		t.rts = !t.rts
+		t.printf("RTS %s", onOff(t.rts))
+	case 'b':
+		breaker, ok := t.port.(serial.Breaker)
+		if !ok {
+			t.printf("BREAK not supported by the port")
+			break
+		}
+		if err := breaker.Break(breakDuration); err != nil {
+			return false, err
+		}
+		t.printf("BREAK sent")
+	case 'e':
+		t.echo = !t.echo
+		t.printf("local echo %s", onOff(t.echo))
+	case 'x':
+		t.hex = !t.hex
+		t.printf("hex display %s", onOff(t.hex))
+	case 'p':
+		t.state = modePrompt
+		t.command = t.command[:0]
+		t.printf("current mode %s", t.mode)
+		io.WriteString(t.out, "--- new baud rate or mode: ")
+		t.column = 1
+	case 's':
+		t.printStatus(t.status)
+	default:
+		t.printf("Ctrl-T followed by: d DTR, r RTS, b BREAK, p baud/mode, e echo, x hex, s status, q quit; Ctrl-T Ctrl-T sends Ctrl-T")
+	}
+	return true, nil
+}
+
+// promptKey edits the mode typed by the user and applies it on Enter.
+func (t *terminal) promptKey(key byte) {
+	switch key {
+	case '\r', '\n':
+		t.state = passThrough
+		io.WriteString(t.out, "\r\n")
+		t.column = 0
+		mode, err := t.parseMode(string(t.command))
+		if err != nil {
+			t.printf("%s", err)
+			return
+		}
+		if err := t.port.SetMode(mode); err != nil {
+			t.printf("%s", err)
+			return
+		}
+		t.mode = *mode
+		t.printf("mode %s", t.mode)
+	case escKey, menuKey:
+		t.state = passThrough
+		io.WriteString(t.out, "\r\n")
+		t.column = 0
+	case bsKey, delKey:
+		if len(t.command) > 0 {
+			t.command = t.command[:len(t.command)-1]
+			io.WriteString(t.out, "\b \b")
+		}
+	default:
+		if key >= 0x20 && key < 0x7F {
+			t.command = append(t.command, key)
+			t.out.Write([]byte{key})
+		}
+	}
+}
+
+// parseMode parses a new mode: a baud rate alone keeps the framing of
+// the current mode.
+func (t *terminal) parseMode(s string) (*serial.Mode, error) {
+	s = strings.TrimSpace(s)
+	if baud, err := strconv.Atoi(s); err == nil && baud > 0 {
+		mode := t.mode
+		mode.BaudRate = baud
+		return &mode, nil
+	}
+	return serial.ParseMode(s)
+}
+
+// printStatus displays the mode and the modem lines.
+func (t *terminal) printStatus(bits *serial.ModemStatusBits) {
+	lines := "modem status not available"
+	if bits != nil {
+		lines = fmt.Sprintf("CTS %s, DSR %s, RI %s, DCD %s", onOff(bits.CTS), onOff(bits.DSR), onOff(bits.RI), onOff(bits.DCD))
+	}
+	t.printf("%s | DTR %s, RTS %s | %s", t.mode, onOff(t.dtr), onOff(t.rts), lines)
+}
+
+// updateStatus reads the modem status bits and displays them if they
+// changed since the last update.
+func (t *terminal) updateStatus() {
+	bits, err := t.port.GetModemStatusBits()
+	if err != nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.status == nil || *t.status != *bits {
+		t.status = bits
+		t.printStatus(bits)
+	}
+}
+
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
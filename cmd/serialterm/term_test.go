@@ -0,0 +1,103 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.bug.st/serial"
+)
+
+// recordingPort records the data written and the control changes.
+type recordingPort struct {
+	serial.Port
+	written bytes.Buffer
+	dtr     bool
+	rts     bool
+	breaks  int
+	mode    *serial.Mode
+}
+
+func (p *recordingPort) Write(b []byte) (int, error)  { return p.written.Write(b) }
+func (p *recordingPort) SetDTR(dtr bool) error        { p.dtr = dtr; return nil }
+func (p *recordingPort) SetRTS(rts bool) error        { p.rts = rts; return nil }
+func (p *recordingPort) Break(time.Duration) error    { p.breaks++; return nil }
+func (p *recordingPort) SetMode(m *serial.Mode) error { p.mode = m; return nil }
+
+func newTestTerminal() (*terminal, *recordingPort, *bytes.Buffer) {
+	port := &recordingPort{dtr: true, rts: true}
+	screen := &bytes.Buffer{}
+	mode, _ := serial.ParseMode("9600,7E1")
+	return newTerminal(port, mode, screen), port, screen
+}
+
+func TestReceiveLineEndings(t *testing.T) {
+	tests := []struct {
+		recvEOL string
+		in      []string
+		out     string
+	}{
+		{"", []string{"a\rb\n"}, "a\rb\n"},
+		{"\r", []string{"a\rb\r\n"}, "a\r\nb\r\n"},
+		{"\n", []string{"a\nb\r\n"}, "a\r\nb\r\n"},
+		{"\r\n", []string{"a\r", "\nb\rc\n"}, "a\r\nb\rc"},
+	}
+	for _, test := range tests {
+		term, _, screen := newTestTerminal()
+		term.recvEOL = test.recvEOL
+		for _, in := range test.in {
+			term.receive([]byte(in))
+		}
+		require.Equal(t, test.out, screen.String(), "%q", test.recvEOL)
+	}
+
+	term, _, screen := newTestTerminal()
+	term.hex = true
+	term.receive(bytes.Repeat([]byte{0xAB}, 17))
+	require.Equal(t, "AB AB AB AB AB AB AB AB AB AB AB AB AB AB AB AB \r\nAB ", screen.String())
+}
+
+func TestInput(t *testing.T) {
+	term, port, screen := newTestTerminal()
+	term.eol = "\r\n"
+	term.echo = true
+	cont, err := term.input([]byte("AT\r"))
+	require.NoError(t, err)
+	require.True(t, cont)
+	require.Equal(t, "AT\r\n", port.written.String())
+	require.Equal(t, "AT\r\n", screen.String())
+
+	// Menu commands, with or without Ctrl
+	port.written.Reset()
+	_, err = term.input([]byte{menuKey, 'd', menuKey, 0x12, menuKey, 'b', menuKey, menuKey})
+	require.NoError(t, err)
+	require.False(t, port.dtr)
+	require.False(t, port.rts)
+	require.Equal(t, 1, port.breaks)
+	require.Equal(t, []byte{menuKey}, port.written.Bytes())
+
+	// A baud rate alone keeps the framing
+	_, err = term.input([]byte{menuKey, 'p', '1', '9', '2', '0', '1', bsKey, '0', '\r'})
+	require.NoError(t, err)
+	require.Equal(t, "19200,7E1", port.mode.String())
+	_, err = term.input([]byte{menuKey, 'p'})
+	require.NoError(t, err)
+	_, err = term.input([]byte("115200,8N1\r"))
+	require.NoError(t, err)
+	require.Equal(t, "115200,8N1", port.mode.String())
+	_, err = term.input([]byte{menuKey, 'p', 'x', '\r'})
+	require.NoError(t, err)
+	require.Equal(t, "115200,8N1", term.mode.String())
+
+	cont, err = term.input([]byte{'a', exitKey, 'b'})
+	require.NoError(t, err)
+	require.False(t, cont)
+	require.Equal(t, "\x14a", port.written.String())
+}
@@ -0,0 +1,314 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package cmux
+
+import (
+	"sync"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// Thresholds of the receive buffer of a channel: when the buffered data
+// exceeds bufferHigh the peer is asked to stop sending, and it's allowed
+// to resume when the data drops below bufferLow.
+const (
+	bufferHigh = 16 * 1024
+	bufferLow  = 4 * 1024
+)
+
+// breakUnit is the unit of the length of a break signal.
+const breakUnit = 200 * time.Millisecond
+
+// Channel is a logical channel (DLCI) of a multiplexer. It implements
+// serial.Port: the modem status bits are exchanged with the peer through
+// the modem status command (MSC), while SetMode has no effect.
+type Channel struct {
+	mux  *Mux
+	dlci int
+
+	mu          sync.Mutex
+	mode        serial.Mode
+	buf         []byte
+	readTimeout time.Duration
+	dtr, rts    bool
+	peer        byte // V.24 signals received from the peer
+	stopped     bool // the peer can't accept frames
+	throttled   bool // the peer has been asked to stop sending
+	closed      bool
+
+	readable chan struct{}
+	writable chan struct{}
+	done     chan struct{}
+}
+
+func newChannel(m *Mux, dlci int) *Channel {
+	return &Channel{
+		mux:         m,
+		dlci:        dlci,
+		readTimeout: serial.NoTimeout,
+		dtr:         true,
+		rts:         true,
+		readable:    make(chan struct{}, 1),
+		writable:    make(chan struct{}, 1),
+		done:        make(chan struct{}),
+	}
+}
+
+// DLCI returns the data link connection identifier of the channel.
+func (c *Channel) DLCI() int {
+	return c.dlci
+}
+
+// signal wakes up a goroutine waiting on ch.
+func (c *Channel) signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// receive appends the data received from the peer to the buffer.
+func (c *Channel) receive(data []byte) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.buf = append(c.buf, data...)
+	throttle := !c.throttled && len(c.buf) > bufferHigh
+	if throttle {
+		c.throttled = true
+	}
+	c.mu.Unlock()
+	c.signal(c.readable)
+	if throttle {
+		// The reading goroutine can't wait for the response
+		go c.sendSignals()
+	}
+}
+
+// setPeerSignals records the V.24 signals received with an MSC.
+func (c *Channel) setPeerSignals(signals byte) {
+	c.mu.Lock()
+	c.peer = signals
+	c.stopped = signals&signalFC != 0
+	c.mu.Unlock()
+	c.signal(c.writable)
+}
+
+// sendSignals sends the local V.24 signals to the peer.
+func (c *Channel) sendSignals() error {
+	_, err := c.mux.command(&message{typ: msgMSC, command: true, value: c.signals()})
+	return err
+}
+
+// signals returns the value of an MSC with the local V.24 signals.
+func (c *Channel) signals() []byte {
+	c.mu.Lock()
+	signals := eaBit
+	if c.dtr {
+		signals |= signalRTC
+	}
+	if c.rts {
+		signals |= signalRTR
+	}
+	if c.throttled {
+		signals |= signalFC
+	}
+	c.mu.Unlock()
+	return []byte{byte(c.dlci<<2) | crBit | eaBit, signals}
+}
+
+// SetMode records the mode, it has no effect on the channel.
+func (c *Channel) SetMode(mode *serial.Mode) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.mode = *mode
+	return nil
+}
+
+// Read reads the data received on the channel.
+func (c *Channel) Read(p []byte) (int, error) {
+	c.mu.Lock()
+	timeout := c.readTimeout
+	c.mu.Unlock()
+	var expired <-chan time.Time
+	if timeout != serial.NoTimeout {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	for {
+		c.mu.Lock()
+		if len(c.buf) > 0 {
+			n := copy(p, c.buf)
+			c.buf = c.buf[:copy(c.buf, c.buf[n:])]
+			resume := c.throttled && len(c.buf) < bufferLow
+			if resume {
+				c.throttled = false
+			}
+			c.mu.Unlock()
+			if resume {
+				c.sendSignals()
+			}
+			return n, nil
+		}
+		closed := c.closed
+		c.mu.Unlock()
+		if closed {
+			return 0, ErrClosed
+		}
+
+		select {
+		case <-c.readable:
+		case <-c.done:
+		case <-expired:
+			return 0, nil
+		}
+	}
+}
+
+// Write sends data on the channel, split into frames of the size set for
+// the multiplexer. Write blocks while the peer has stopped the flow.
+func (c *Channel) Write(p []byte) (int, error) {
+	size := c.mux.frameSize()
+	written := 0
+	for written < len(p) {
+		if err := c.waitWritable(); err != nil {
+			return written, err
+		}
+		n := len(p) - written
+		if n > size {
+			n = size
+		}
+		if err := c.mux.sendUIH(c.dlci, p[written:written+n]); err != nil {
+			return written, err
+		}
+		written += n
+	}
+	return written, nil
+}
+
+func (c *Channel) waitWritable() error {
+	for {
+		c.mu.Lock()
+		closed, stopped := c.closed, c.stopped
+		c.mu.Unlock()
+		if closed {
+			return ErrClosed
+		}
+		if !stopped && !c.mux.flowStopped() {
+			return nil
+		}
+		select {
+		case <-c.writable:
+		case <-c.done:
+		}
+	}
+}
+
+// ResetInputBuffer discards the data received and not read yet.
+func (c *Channel) ResetInputBuffer() error {
+	c.mu.Lock()
+	c.buf = c.buf[:0]
+	resume := c.throttled
+	c.throttled = false
+	c.mu.Unlock()
+	if resume {
+		return c.sendSignals()
+	}
+	return nil
+}
+
+// ResetOutputBuffer is a no-op, the data is sent immediately.
+func (c *Channel) ResetOutputBuffer() error {
+	return nil
+}
+
+// SetDTR sets the DTR signal, sent to the peer as RTC.
+func (c *Channel) SetDTR(dtr bool) error {
+	c.mu.Lock()
+	c.dtr = dtr
+	c.mu.Unlock()
+	return c.sendSignals()
+}
+
+// SetRTS sets the RTS signal, sent to the peer as RTR.
+func (c *Channel) SetRTS(rts bool) error {
+	c.mu.Lock()
+	c.rts = rts
+	c.mu.Unlock()
+	return c.sendSignals()
+}
+
+// GetModemStatusBits returns the signals received from the peer: RTC as
+// DSR, RTR as CTS, IC as RI and DV as DCD.
+func (c *Channel) GetModemStatusBits() (*serial.ModemStatusBits, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return &serial.ModemStatusBits{
+		DSR: c.peer&signalRTC != 0,
+		CTS: c.peer&signalRTR != 0,
+		RI:  c.peer&signalIC != 0,
+		DCD: c.peer&signalDV != 0,
+	}, nil
+}
+
+// Break sends a break signal to the peer with an MSC, it implements
+// serial.Breaker. The duration is rounded to units of 200ms, up to 3s.
+func (c *Channel) Break(d time.Duration) error {
+	units := (d + breakUnit - 1) / breakUnit
+	if units > 15 {
+		units = 15
+	}
+	value := append(c.signals(), byte(units)<<4|breakSignal|eaBit)
+	_, err := c.mux.command(&message{typ: msgMSC, command: true, value: value})
+	return err
+}
+
+// SetReadTimeout sets the timeout of Read.
+func (c *Channel) SetReadTimeout(timeout time.Duration) error {
+	if timeout < 0 && timeout != serial.NoTimeout {
+		return ErrInvalidTimeout
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readTimeout = timeout
+	return nil
+}
+
+// Close closes the channel with a DISC frame.
+func (c *Channel) Close() error {
+	c.mu.Lock()
+	closed := c.closed
+	c.mu.Unlock()
+	if closed {
+		return nil
+	}
+	err := c.mux.connect(c.dlci, frameDISC)
+	c.mux.remove(c)
+	c.closeLocal()
+	if err == ErrClosed || err == ErrRefused {
+		// The channel is already closed on the peer side
+		err = nil
+	}
+	return err
+}
+
+// closeLocal marks the channel as closed and wakes up the pending reads
+// and writes.
+func (c *Channel) closeLocal() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	close(c.done)
+}
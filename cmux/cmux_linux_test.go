@@ -0,0 +1,291 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package cmux
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.bug.st/serial"
+	"go.bug.st/serial/internal/ptytest"
+)
+
+// peer is an in-process multiplexer responder: it accepts the channels
+// (except the refused ones), echoes the data received and answers the
+// control channel commands.
+type peer struct {
+	port *ptytest.Port
+
+	mu      sync.Mutex
+	refuse  map[int]bool
+	frames  []*frame
+	signals map[int]byte
+	breaks  map[int]byte
+	cld     bool
+}
+
+func newTestMux(t *testing.T) (*Mux, *peer) {
+	port, master, err := ptytest.Pair(&serial.Mode{BaudRate: 115200})
+	require.NoError(t, err)
+	p := &peer{port: master, refuse: map[int]bool{}, signals: map[int]byte{}, breaks: map[int]byte{}}
+	go p.run()
+	m := NewMux(port)
+	m.FrameSize = 64
+	m.Timeout = 200 * time.Millisecond
+	return m, p
+}
+
+func (p *peer) run() {
+	buf := make([]byte, 1)
+	fr := &frameReader{readByte: func() (byte, error) {
+		for {
+			n, err := p.port.Read(buf)
+			if err != nil {
+				return 0, err
+			}
+			if n == 1 {
+				return buf[0], nil
+			}
+		}
+	}}
+	for {
+		f, err := fr.next()
+		if err != nil {
+			return
+		}
+		p.mu.Lock()
+		p.frames = append(p.frames, f)
+		p.mu.Unlock()
+
+		switch f.control {
+		case frameSABM:
+			p.mu.Lock()
+			refused := p.refuse[f.dlci]
+			p.mu.Unlock()
+			if refused {
+				p.send(&frame{dlci: f.dlci, cr: true, control: frameDM, pf: true})
+			} else {
+				p.send(&frame{dlci: f.dlci, cr: true, control: frameUA, pf: true})
+			}
+		case frameDISC:
+			p.send(&frame{dlci: f.dlci, cr: true, control: frameUA, pf: true})
+		case frameUIH:
+			if f.dlci != 0 {
+				p.send(&frame{dlci: f.dlci, control: frameUIH, info: f.info})
+				continue
+			}
+			for _, msg := range parseMessages(f.info) {
+				if !msg.command {
+					continue
+				}
+				p.mu.Lock()
+				switch msg.typ {
+				case msgMSC:
+					p.signals[int(msg.value[0]>>2)] = msg.value[1]
+					if len(msg.value) > 2 {
+						p.breaks[int(msg.value[0]>>2)] = msg.value[2]
+					}
+				case msgCLD:
+					p.cld = true
+				}
+				p.mu.Unlock()
+				res := &message{typ: msg.typ, value: msg.value}
+				p.send(&frame{dlci: 0, control: frameUIH, info: res.encode()})
+			}
+		}
+	}
+}
+
+func (p *peer) send(f *frame) {
+	p.port.Write(f.encode())
+}
+
+func (p *peer) sendMSC(dlci int, signals byte) {
+	msg := &message{typ: msgMSC, command: true, value: []byte{byte(dlci<<2) | crBit | eaBit, signals | eaBit}}
+	p.send(&frame{dlci: 0, control: frameUIH, info: msg.encode()})
+}
+
+func (p *peer) received(dlci int, control byte) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, f := range p.frames {
+		if f.dlci == dlci && f.control == control {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *peer) signal(dlci int) byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.signals[dlci]
+}
+
+func TestMuxChannels(t *testing.T) {
+	m, p := newTestMux(t)
+	defer p.port.Close()
+	require.NoError(t, m.Start())
+
+	ch1, err := m.Open(1)
+	require.NoError(t, err)
+	ch2, err := m.Open(2)
+	require.NoError(t, err)
+	_, err = m.Open(2)
+	require.Equal(t, ErrInvalidDLCI, err)
+	_, err = m.Open(62)
+	require.Equal(t, ErrInvalidDLCI, err)
+
+	// DTR and RTS are asserted when the channel is opened
+	require.Equal(t, eaBit|signalRTC|signalRTR, p.signal(1))
+
+	// The data is split in frames and echoed back by the peer
+	data := bytes.Repeat([]byte("0123456789"), 30)
+	n, err := ch1.Write(data)
+	require.NoError(t, err)
+	require.Equal(t, len(data), n)
+	_, err = ch2.Write([]byte("$GPGGA\r\n"))
+	require.NoError(t, err)
+
+	r1 := serial.NewReader(ch1)
+	echo, err := r1.ReadExactly(len(data), time.Second)
+	require.NoError(t, err)
+	require.Equal(t, data, echo)
+	line, err := serial.NewReader(ch2).ReadLine(time.Second)
+	require.NoError(t, err)
+	require.Equal(t, "$GPGGA", line)
+
+	require.NoError(t, ch2.Close())
+	require.True(t, p.received(2, frameDISC))
+	_, err = ch2.Write([]byte("x"))
+	require.Equal(t, ErrClosed, err)
+
+	require.NoError(t, m.Close())
+	require.True(t, p.received(1, frameDISC))
+	p.mu.Lock()
+	require.True(t, p.cld)
+	p.mu.Unlock()
+	_, err = ch1.Read(make([]byte, 10))
+	require.Equal(t, ErrClosed, err)
+}
+
+func TestMuxModemStatus(t *testing.T) {
+	m, p := newTestMux(t)
+	defer p.port.Close()
+	require.NoError(t, m.Start())
+	defer m.Close()
+
+	ch, err := m.Open(3)
+	require.NoError(t, err)
+	require.NoError(t, ch.SetDTR(false))
+	require.Equal(t, eaBit|signalRTR, p.signal(3))
+	require.NoError(t, ch.SetRTS(false))
+	require.Equal(t, eaBit, p.signal(3))
+
+	// The break length is sent in units of 200ms
+	require.NoError(t, ch.Break(500*time.Millisecond))
+	p.mu.Lock()
+	require.Equal(t, byte(3<<4)|breakSignal|eaBit, p.breaks[3])
+	p.mu.Unlock()
+
+	p.sendMSC(3, signalRTC|signalDV)
+	require.Eventually(t, func() bool {
+		bits, err := ch.GetModemStatusBits()
+		require.NoError(t, err)
+		return bits.DSR && bits.DCD && !bits.CTS && !bits.RI
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestMuxFlowControl(t *testing.T) {
+	m, p := newTestMux(t)
+	defer p.port.Close()
+	require.NoError(t, m.Start())
+	defer m.Close()
+
+	ch, err := m.Open(1)
+	require.NoError(t, err)
+	p.sendMSC(1, signalFC|signalRTC|signalRTR)
+	require.Eventually(t, func() bool {
+		ch.mu.Lock()
+		defer ch.mu.Unlock()
+		return ch.stopped
+	}, time.Second, 10*time.Millisecond)
+
+	written := make(chan error)
+	go func() {
+		_, err := ch.Write([]byte("blocked"))
+		written <- err
+	}()
+	select {
+	case <-written:
+		t.Fatal("write not blocked by flow control")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	p.sendMSC(1, signalRTC|signalRTR)
+	require.NoError(t, <-written)
+	require.NoError(t, ch.SetReadTimeout(time.Second))
+	buf := make([]byte, 16)
+	n, err := ch.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "blocked", string(buf[:n]))
+
+	// A read timeout returns no data and no error
+	require.NoError(t, ch.SetReadTimeout(50*time.Millisecond))
+	n, err = ch.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, 0, n)
+}
+
+func TestMuxPeerClose(t *testing.T) {
+	m, p := newTestMux(t)
+	defer p.port.Close()
+	p.mu.Lock()
+	p.refuse[5] = true
+	p.mu.Unlock()
+	require.NoError(t, m.Start())
+
+	_, err := m.Open(5)
+	require.Equal(t, ErrRefused, err)
+
+	ch, err := m.Open(1)
+	require.NoError(t, err)
+
+	// The peer closes the channel
+	p.send(&frame{dlci: 1, control: frameDISC, pf: true})
+	_, err = ch.Read(make([]byte, 10))
+	require.Equal(t, ErrClosed, err)
+
+	// The peer closes the multiplexer
+	cld := &message{typ: msgCLD, command: true}
+	p.send(&frame{dlci: 0, control: frameUIH, info: cld.encode()})
+	require.Eventually(t, func() bool {
+		_, err := m.Open(2)
+		return err == ErrClosed
+	}, time.Second, 10*time.Millisecond)
+	require.NoError(t, m.Close())
+}
+
+func TestMuxCloseBeforeStart(t *testing.T) {
+	m, p := newTestMux(t)
+	defer p.port.Close()
+
+	closed := make(chan error, 1)
+	go func() { closed <- m.Close() }()
+	select {
+	case err := <-closed:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Close of a multiplexer not started hangs")
+	}
+	require.Equal(t, ErrClosed, m.Start())
+	_, err := m.Open(1)
+	require.Equal(t, ErrClosed, err)
+}
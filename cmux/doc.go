@@ -0,0 +1,34 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+/*
+Package cmux implements the basic mode of the GSM 07.10 (3GPP TS 27.010)
+multiplexer, used by cellular modules to carry several logical channels
+on a single serial port.
+
+The modem must be switched to multiplexer mode with the AT+CMUX command,
+then the multiplexer is started and each logical channel (DLCI) is opened
+as a serial.Port:
+
+	// AT+CMUX=0 has been sent and answered with OK
+	mux := cmux.NewMux(port)
+	mux.FrameSize = 127
+	if err := mux.Start(); err != nil {
+		log.Fatal(err)
+	}
+	defer mux.Close()
+
+	control, err := mux.Open(1) // AT commands
+	if err != nil {
+		log.Fatal(err)
+	}
+	gnss, err := mux.Open(2) // NMEA sentences
+
+The DTR and RTS signals of a channel, and the flow control, are carried
+by the modem status command (MSC). Closing the multiplexer closes all the
+channels and sends the close down command (CLD) to the modem.
+*/
+package cmux
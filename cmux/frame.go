@@ -0,0 +1,268 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package cmux
+
+const (
+	// flag delimits the basic mode frames
+	flag byte = 0xF9
+
+	eaBit byte = 0x01 // extension bit of address, length and type fields
+	crBit byte = 0x02 // command/response bit
+	pfBit byte = 0x10 // poll/final bit of the control field
+)
+
+// Frame types (control field without the P/F bit)
+const (
+	frameSABM byte = 0x2F
+	frameUA   byte = 0x63
+	frameDM   byte = 0x0F
+	frameDISC byte = 0x43
+	frameUIH  byte = 0xEF
+	frameUI   byte = 0x03
+)
+
+// Types of the control channel messages, with the EA bit and without the
+// C/R bit
+const (
+	msgPN    byte = 0x81 // parameter negotiation
+	msgPSC   byte = 0x41 // power saving control
+	msgCLD   byte = 0xC1 // multiplexer close down
+	msgTEST  byte = 0x21 // test command
+	msgFCon  byte = 0xA1 // flow control on
+	msgFCoff byte = 0x61 // flow control off
+	msgMSC   byte = 0xE1 // modem status command
+	msgNSC   byte = 0x11 // non supported command response
+)
+
+// V.24 signals of the modem status command
+const (
+	signalFC  byte = 0x02 // flow control: the sender can't accept frames
+	signalRTC byte = 0x04 // ready to communicate (DTR/DSR)
+	signalRTR byte = 0x08 // ready to receive (RTS/CTS)
+	signalIC  byte = 0x40 // incoming call (RI)
+	signalDV  byte = 0x80 // data valid (DCD)
+)
+
+// breakSignal is set in the optional break octet of the modem status
+// command, whose high nibble is the length of the break in units of 200ms.
+const breakSignal byte = 0x02
+
+// maxInfoSize is the largest information field that can be encoded in a
+// basic mode frame.
+const maxInfoSize = 0x7FFF
+
+// frame is a basic mode frame.
+type frame struct {
+	dlci    int
+	cr      bool
+	control byte // frame type, without the P/F bit
+	pf      bool
+	info    []byte
+}
+
+// encode returns the frame, enclosed in flags.
+func (f *frame) encode() []byte {
+	out := make([]byte, 0, len(f.info)+7)
+	out = append(out, flag)
+	addr := byte(f.dlci<<2) | eaBit
+	if f.cr {
+		addr |= crBit
+	}
+	control := f.control
+	if f.pf {
+		control |= pfBit
+	}
+	out = append(out, addr, control)
+	out = appendLength(out, len(f.info))
+	headerEnd := len(out)
+	out = append(out, f.info...)
+	// The FCS covers the information field only for UI frames
+	checked := out[1:headerEnd]
+	if f.control == frameUI {
+		checked = out[1:]
+	}
+	return append(out, fcs(checked), flag)
+}
+
+// appendLength appends a length field, in one or two bytes.
+func appendLength(dst []byte, n int) []byte {
+	if n < 0x80 {
+		return append(dst, byte(n<<1)|eaBit)
+	}
+	return append(dst, byte(n<<1), byte(n>>7))
+}
+
+var fcsTable [256]byte
+
+func init() {
+	for i := range fcsTable {
+		crc := byte(i)
+		for j := 0; j < 8; j++ {
+			if crc&1 != 0 {
+				crc = (crc >> 1) ^ 0xE0
+			} else {
+				crc >>= 1
+			}
+		}
+		fcsTable[i] = crc
+	}
+}
+
+func crc8(data []byte) byte {
+	crc := byte(0xFF)
+	for _, b := range data {
+		crc = fcsTable[crc^b]
+	}
+	return crc
+}
+
+// fcs returns the frame check sequence of the given header (or of the
+// whole frame for UI frames).
+func fcs(data []byte) byte {
+	return 0xFF - crc8(data)
+}
+
+// checkFCS verifies the frame check sequence received.
+func checkFCS(data []byte, fcs byte) bool {
+	return fcsTable[crc8(data)^fcs] == 0xCF
+}
+
+// frameReader parses the basic mode frames from a byte stream. Corrupted
+// frames are skipped.
+type frameReader struct {
+	readByte func() (byte, error)
+	synced   bool // an opening flag has been received
+}
+
+func (fr *frameReader) next() (*frame, error) {
+	for {
+		b, err := fr.readByte()
+		if err != nil {
+			return nil, err
+		}
+		if !fr.synced {
+			fr.synced = b == flag
+			continue
+		}
+		if b == flag {
+			continue
+		}
+		f, err := fr.parse(b)
+		if err != nil {
+			return nil, err
+		}
+		if f != nil {
+			return f, nil
+		}
+	}
+}
+
+// parse reads the rest of a frame starting with the address addr. It
+// returns nil if the frame is corrupted.
+func (fr *frameReader) parse(addr byte) (*frame, error) {
+	fr.synced = false
+	if addr&eaBit == 0 {
+		return nil, nil
+	}
+	control, err := fr.readByte()
+	if err != nil {
+		return nil, err
+	}
+	header := []byte{addr, control}
+	l, err := fr.readByte()
+	if err != nil {
+		return nil, err
+	}
+	header = append(header, l)
+	length := int(l >> 1)
+	if l&eaBit == 0 {
+		l2, err := fr.readByte()
+		if err != nil {
+			return nil, err
+		}
+		header = append(header, l2)
+		length |= int(l2) << 7
+	}
+	info := make([]byte, length)
+	for i := range info {
+		if info[i], err = fr.readByte(); err != nil {
+			return nil, err
+		}
+	}
+	sum, err := fr.readByte()
+	if err != nil {
+		return nil, err
+	}
+	closing, err := fr.readByte()
+	if err != nil {
+		return nil, err
+	}
+	// The closing flag may also open the next frame
+	fr.synced = closing == flag
+	if !fr.synced {
+		return nil, nil
+	}
+
+	f := &frame{
+		dlci:    int(addr >> 2),
+		cr:      addr&crBit != 0,
+		control: control &^ pfBit,
+		pf:      control&pfBit != 0,
+		info:    info,
+	}
+	checked := header
+	if f.control == frameUI {
+		checked = append(checked, info...)
+	}
+	if !checkFCS(checked, sum) {
+		return nil, nil
+	}
+	return f, nil
+}
+
+// message is a message sent on the control channel (DLCI 0).
+type message struct {
+	typ     byte // type, without the C/R bit
+	command bool
+	value   []byte
+}
+
+func (msg *message) encode() []byte {
+	typ := msg.typ
+	if msg.command {
+		typ |= crBit
+	}
+	out := appendLength([]byte{typ}, len(msg.value))
+	return append(out, msg.value...)
+}
+
+// parseMessages parses the control channel messages contained in info.
+func parseMessages(info []byte) []*message {
+	var res []*message
+	for len(info) >= 2 {
+		typ := info[0]
+		length := int(info[1] >> 1)
+		n := 2
+		if info[1]&eaBit == 0 {
+			if len(info) < 3 {
+				break
+			}
+			length |= int(info[2]) << 7
+			n = 3
+		}
+		if len(info) < n+length {
+			break
+		}
+		res = append(res, &message{
+			typ:     typ &^ crBit,
+			command: typ&crBit != 0,
+			value:   info[n : n+length],
+		})
+		info = info[n+length:]
+	}
+	return res
+}
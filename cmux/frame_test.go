@@ -0,0 +1,91 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package cmux
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func readFrames(t *testing.T, data []byte) []*frame {
+	r := bytes.NewReader(data)
+	fr := &frameReader{readByte: r.ReadByte}
+	var res []*frame
+	for {
+		f, err := fr.next()
+		if err == io.EOF {
+			return res
+		}
+		require.NoError(t, err)
+		res = append(res, f)
+	}
+}
+
+func TestFrameEncode(t *testing.T) {
+	// Well known frames: SABM and UA on the control channel
+	sabm := &frame{dlci: 0, cr: true, control: frameSABM, pf: true}
+	require.Equal(t, []byte{0xF9, 0x03, 0x3F, 0x01, 0x1C, 0xF9}, sabm.encode())
+	ua := &frame{dlci: 0, cr: true, control: frameUA, pf: true}
+	require.Equal(t, []byte{0xF9, 0x03, 0x73, 0x01, 0xD7, 0xF9}, ua.encode())
+
+	// UIH on DLCI 1 with "AT\r"
+	uih := &frame{dlci: 1, cr: true, control: frameUIH, info: []byte("AT\r")}
+	out := uih.encode()
+	require.Equal(t, []byte{0xF9, 0x07, 0xEF, 0x07, 'A', 'T', '\r'}, out[:7])
+	require.True(t, checkFCS(out[1:4], out[7]))
+	require.Equal(t, flag, out[8])
+}
+
+func TestFrameRoundTrip(t *testing.T) {
+	large := bytes.Repeat([]byte{flag, 0x00, 0x55}, 100)
+	frames := []*frame{
+		{dlci: 0, cr: true, control: frameSABM, pf: true, info: []byte{}},
+		{dlci: 5, control: frameUIH, info: []byte("hello")},
+		{dlci: 61, cr: true, control: frameUIH, info: large},
+		{dlci: 2, control: frameUI, info: []byte{flag, flag}},
+	}
+	var stream []byte
+	stream = append(stream, 0x00, 0x12) // noise before the first flag
+	for _, f := range frames {
+		stream = append(stream, f.encode()...)
+	}
+	require.Equal(t, frames, readFrames(t, stream))
+}
+
+func TestFrameCorrupted(t *testing.T) {
+	good := (&frame{dlci: 1, control: frameUIH, info: []byte("ok")}).encode()
+	bad := (&frame{dlci: 1, control: frameUIH, info: []byte("ko")}).encode()
+	bad[2] ^= 0x01 // corrupt the control field, covered by the FCS
+
+	// The closing flag of the corrupted frame opens the next one
+	stream := append(append([]byte{}, bad...), good[1:]...)
+	frames := readFrames(t, stream)
+	require.Len(t, frames, 1)
+	require.Equal(t, []byte("ok"), frames[0].info)
+
+	// Frames sharing the flag are both received
+	stream = append(append([]byte{}, good...), good[1:]...)
+	require.Len(t, readFrames(t, stream), 2)
+}
+
+func TestMessages(t *testing.T) {
+	msc := &message{typ: msgMSC, command: true, value: []byte{0x07, 0x0D}}
+	require.Equal(t, []byte{0xE3, 0x05, 0x07, 0x0D}, msc.encode())
+
+	info := append(msc.encode(), (&message{typ: msgCLD}).encode()...)
+	msgs := parseMessages(info)
+	require.Len(t, msgs, 2)
+	require.Equal(t, msgMSC, msgs[0].typ)
+	require.True(t, msgs[0].command)
+	require.Equal(t, []byte{0x07, 0x0D}, msgs[0].value)
+	require.Equal(t, msgCLD, msgs[1].typ)
+	require.False(t, msgs[1].command)
+	require.Empty(t, msgs[1].value)
+}
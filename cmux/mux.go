@@ -0,0 +1,464 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package cmux
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// Default values of the multiplexer parameters
+const (
+	// DefaultFrameSize is the default maximum size of the information field
+	// of a frame (N1) in basic mode
+	DefaultFrameSize = 31
+	// DefaultTimeout is the default time to wait for an acknowledgement (T1)
+	DefaultTimeout = time.Second
+	// DefaultRetries is the default maximum number of retransmissions (N2)
+	DefaultRetries = 3
+)
+
+// pollInterval is the read timeout used on the port, to check periodically
+// if the multiplexer has been closed.
+const pollInterval = 100 * time.Millisecond
+
+var (
+	// ErrClosed is returned when the multiplexer or the channel has been
+	// closed
+	ErrClosed = errors.New("cmux: closed")
+	// ErrTimeout is returned when the peer doesn't acknowledge a command
+	ErrTimeout = errors.New("cmux: timeout")
+	// ErrRefused is returned when the peer refuses to open a channel
+	ErrRefused = errors.New("cmux: channel refused")
+	// ErrInvalidDLCI is returned when opening a channel with a DLCI out of
+	// the range 1-61, or already open
+	ErrInvalidDLCI = errors.New("cmux: invalid DLCI")
+	// ErrInvalidTimeout is returned when setting a negative read timeout
+	ErrInvalidTimeout = errors.New("cmux: invalid timeout")
+)
+
+// Mux is a basic mode GSM 07.10 (3GPP TS 27.010) multiplexer running on a
+// serial port, acting as initiator. Each channel opened is a serial.Port.
+//
+// FrameSize, Timeout and Retries must be set, if needed, before calling
+// Start and must match the parameters of the peer (usually set with
+// AT+CMUX).
+type Mux struct {
+	// FrameSize is the maximum size of the information field of the
+	// frames sent (N1). If zero, DefaultFrameSize is used.
+	FrameSize int
+	// Timeout is the time to wait for the acknowledgement of a command
+	// (T1). If zero, DefaultTimeout is used.
+	Timeout time.Duration
+	// Retries is the number of retransmissions of an unacknowledged
+	// command (N2). If zero, DefaultRetries is used.
+	Retries int
+
+	port serial.Port
+
+	writeLock sync.Mutex
+	ctrlLock  sync.Mutex
+
+	mu       sync.Mutex
+	channels map[int]*Channel
+	acks     map[int]chan byte
+	ctrlWait chan *message
+	ctrlType byte
+	flowOff  bool
+	err      error
+	started  bool // the reading goroutine is running, it closes done
+	closing  uint32
+	done     chan struct{}
+
+	rbuf       []byte
+	rpos, rend int
+}
+
+// NewMux creates a new multiplexer on port. The modem must already be
+// in multiplexer mode, usually entered with the AT+CMUX command.
+func NewMux(port serial.Port) *Mux {
+	return &Mux{
+		port:     port,
+		channels: map[int]*Channel{},
+		acks:     map[int]chan byte{},
+		done:     make(chan struct{}),
+		rbuf:     make([]byte, 1024),
+	}
+}
+
+func (m *Mux) frameSize() int {
+	if m.FrameSize > 0 && m.FrameSize <= maxInfoSize {
+		return m.FrameSize
+	}
+	return DefaultFrameSize
+}
+
+func (m *Mux) timeout() time.Duration {
+	if m.Timeout > 0 {
+		return m.Timeout
+	}
+	return DefaultTimeout
+}
+
+func (m *Mux) retries() int {
+	if m.Retries > 0 {
+		return m.Retries
+	}
+	return DefaultRetries
+}
+
+// Start opens the control channel (DLCI 0).
+func (m *Mux) Start() error {
+	m.mu.Lock()
+	err := m.err
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	timeouter, ok := m.port.(serial.ReadTimeouter)
+	if !ok {
+		return serial.ErrFunctionNotImplemented
+	}
+	if err := timeouter.SetReadTimeout(pollInterval); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.started = true
+	m.mu.Unlock()
+	go m.readLoop()
+	if err := m.connect(0, frameSABM); err != nil {
+		m.stop()
+		return err
+	}
+	return nil
+}
+
+// Open opens the channel with the given DLCI, in the range 1-61, and
+// asserts its DTR and RTS signals.
+func (m *Mux) Open(dlci int) (*Channel, error) {
+	if dlci < 1 || dlci > 61 {
+		return nil, ErrInvalidDLCI
+	}
+	c := newChannel(m, dlci)
+	m.mu.Lock()
+	if m.err != nil {
+		err := m.err
+		m.mu.Unlock()
+		return nil, err
+	}
+	if m.channels[dlci] != nil {
+		m.mu.Unlock()
+		return nil, ErrInvalidDLCI
+	}
+	m.channels[dlci] = c
+	m.mu.Unlock()
+
+	if err := m.connect(dlci, frameSABM); err != nil {
+		m.remove(c)
+		return nil, err
+	}
+	if err := c.sendSignals(); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (m *Mux) remove(c *Channel) {
+	m.mu.Lock()
+	if m.channels[c.dlci] == c {
+		delete(m.channels, c.dlci)
+	}
+	m.mu.Unlock()
+}
+
+// Close closes all the channels and the multiplexer with a close down
+// command. The port is not closed: after the close down the modem is
+// usually back in AT command mode. Closing a multiplexer not started only
+// marks it as closed.
+func (m *Mux) Close() error {
+	m.mu.Lock()
+	if !m.started {
+		m.mu.Unlock()
+		m.shutdown(ErrClosed)
+		return nil
+	}
+	channels := make([]*Channel, 0, len(m.channels))
+	for _, c := range m.channels {
+		channels = append(channels, c)
+	}
+	m.mu.Unlock()
+	for _, c := range channels {
+		c.Close()
+	}
+
+	_, err := m.command(&message{typ: msgCLD, command: true})
+	if err == ErrClosed {
+		err = nil
+	}
+	m.stop()
+	return err
+}
+
+// stop terminates the reading goroutine and restores the port read
+// timeout.
+func (m *Mux) stop() {
+	atomic.StoreUint32(&m.closing, 1)
+	<-m.done
+	m.port.(serial.ReadTimeouter).SetReadTimeout(serial.NoTimeout)
+}
+
+// shutdown marks the multiplexer and all its channels as closed.
+func (m *Mux) shutdown(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err == nil {
+		m.err = err
+	}
+	for _, c := range m.channels {
+		c.closeLocal()
+	}
+	m.channels = map[int]*Channel{}
+	if m.ctrlWait != nil {
+		close(m.ctrlWait)
+		m.ctrlWait = nil
+	}
+	for dlci, ack := range m.acks {
+		close(ack)
+		delete(m.acks, dlci)
+	}
+}
+
+// connect sends a SABM or DISC frame and waits for the acknowledgement.
+func (m *Mux) connect(dlci int, control byte) error {
+	ack := make(chan byte, 1)
+	m.mu.Lock()
+	if m.err != nil {
+		err := m.err
+		m.mu.Unlock()
+		return err
+	}
+	m.acks[dlci] = ack
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		if m.acks[dlci] == ack {
+			delete(m.acks, dlci)
+		}
+		m.mu.Unlock()
+	}()
+
+	f := &frame{dlci: dlci, cr: true, control: control, pf: true}
+	for i := 0; i <= m.retries(); i++ {
+		if err := m.send(f); err != nil {
+			return err
+		}
+		select {
+		case res, ok := <-ack:
+			if !ok {
+				return ErrClosed
+			}
+			if res == frameDM {
+				return ErrRefused
+			}
+			return nil
+		case <-time.After(m.timeout()):
+		}
+	}
+	return ErrTimeout
+}
+
+// command sends a control channel command and waits for its response.
+func (m *Mux) command(msg *message) (*message, error) {
+	m.ctrlLock.Lock()
+	defer m.ctrlLock.Unlock()
+
+	wait := make(chan *message, 1)
+	m.mu.Lock()
+	if m.err != nil {
+		err := m.err
+		m.mu.Unlock()
+		return nil, err
+	}
+	m.ctrlWait = wait
+	m.ctrlType = msg.typ
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		if m.ctrlWait == wait {
+			m.ctrlWait = nil
+		}
+		m.mu.Unlock()
+	}()
+
+	for i := 0; i <= m.retries(); i++ {
+		if err := m.sendUIH(0, msg.encode()); err != nil {
+			return nil, err
+		}
+		select {
+		case res, ok := <-wait:
+			if !ok {
+				return nil, ErrClosed
+			}
+			return res, nil
+		case <-time.After(m.timeout()):
+		}
+	}
+	return nil, ErrTimeout
+}
+
+func (m *Mux) send(f *frame) error {
+	m.writeLock.Lock()
+	defer m.writeLock.Unlock()
+	_, err := m.port.Write(f.encode())
+	return err
+}
+
+func (m *Mux) sendUIH(dlci int, info []byte) error {
+	return m.send(&frame{dlci: dlci, cr: true, control: frameUIH, info: info})
+}
+
+// respond sends a response frame.
+func (m *Mux) respond(dlci int, control byte) error {
+	return m.send(&frame{dlci: dlci, control: control, pf: true})
+}
+
+// readByte returns the next byte received from the port.
+func (m *Mux) readByte() (byte, error) {
+	for m.rpos == m.rend {
+		if atomic.LoadUint32(&m.closing) == 1 {
+			return 0, ErrClosed
+		}
+		n, err := m.port.Read(m.rbuf)
+		if err != nil {
+			return 0, err
+		}
+		m.rpos, m.rend = 0, n
+	}
+	b := m.rbuf[m.rpos]
+	m.rpos++
+	return b, nil
+}
+
+// readLoop receives and dispatches the frames until the multiplexer is
+// closed.
+func (m *Mux) readLoop() {
+	defer close(m.done)
+	fr := &frameReader{readByte: m.readByte}
+	for {
+		f, err := fr.next()
+		if err != nil {
+			m.shutdown(err)
+			return
+		}
+		if !m.handleFrame(f) {
+			m.shutdown(ErrClosed)
+			return
+		}
+	}
+}
+
+// handleFrame processes a frame received, it returns false if the peer
+// has closed the multiplexer.
+func (m *Mux) handleFrame(f *frame) bool {
+	switch f.control {
+	case frameUA, frameDM:
+		m.mu.Lock()
+		if ack := m.acks[f.dlci]; ack != nil {
+			select {
+			case ack <- f.control:
+			default:
+			}
+		}
+		m.mu.Unlock()
+	case frameUIH, frameUI:
+		if f.dlci == 0 {
+			return m.handleMessages(f.info)
+		}
+		m.mu.Lock()
+		c := m.channels[f.dlci]
+		m.mu.Unlock()
+		if c != nil {
+			c.receive(f.info)
+		}
+	case frameDISC:
+		if f.dlci == 0 {
+			m.respond(0, frameUA)
+			return false
+		}
+		m.mu.Lock()
+		c := m.channels[f.dlci]
+		m.mu.Unlock()
+		if c == nil {
+			m.respond(f.dlci, frameDM)
+			break
+		}
+		m.respond(f.dlci, frameUA)
+		m.remove(c)
+		c.closeLocal()
+	case frameSABM:
+		// Channels can be opened only by the initiator
+		m.respond(f.dlci, frameDM)
+	}
+	return true
+}
+
+// handleMessages processes the control channel messages, it returns false
+// if the peer has closed the multiplexer.
+func (m *Mux) handleMessages(info []byte) bool {
+	for _, msg := range parseMessages(info) {
+		if !msg.command {
+			m.mu.Lock()
+			if m.ctrlWait != nil && (msg.typ == m.ctrlType || msg.typ == msgNSC) {
+				m.ctrlWait <- msg
+				m.ctrlWait = nil
+			}
+			m.mu.Unlock()
+			continue
+		}
+
+		res := &message{typ: msg.typ, value: msg.value}
+		switch msg.typ {
+		case msgMSC:
+			if len(msg.value) >= 2 {
+				m.mu.Lock()
+				c := m.channels[int(msg.value[0]>>2)]
+				m.mu.Unlock()
+				if c != nil {
+					c.setPeerSignals(msg.value[1])
+				}
+			}
+		case msgFCon, msgFCoff:
+			m.mu.Lock()
+			m.flowOff = msg.typ == msgFCoff
+			for _, c := range m.channels {
+				c.signal(c.writable)
+			}
+			m.mu.Unlock()
+		case msgCLD:
+			m.sendUIH(0, res.encode())
+			return false
+		case msgTEST, msgPSC:
+		default:
+			res = &message{typ: msgNSC, value: []byte{msg.typ | crBit}}
+		}
+		m.sendUIH(0, res.encode())
+	}
+	return true
+}
+
+// flowStopped returns true if the peer has stopped the transmission on all
+// the channels.
+func (m *Mux) flowStopped() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.flowOff
+}
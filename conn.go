@@ -0,0 +1,276 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serial
+
+import (
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Addr is the net.Addr of a Conn, it carries the name of the port.
+type Addr struct {
+	Name string
+}
+
+// Network returns "serial".
+func (a *Addr) Network() string {
+	return "serial"
+}
+
+func (a *Addr) String() string {
+	return a.Name
+}
+
+// deadlinePort is implemented by the ports whose reads and writes wait in
+// the runtime poller, that implements the deadlines. The deadlines of the
+// Conn are kept apart from the read timeout of the port.
+type deadlinePort interface {
+	setConnReadDeadline(t time.Time) error
+	setConnWriteDeadline(t time.Time) error
+	connRead(p []byte) (int, error)
+	connWrite(p []byte) (int, error)
+}
+
+// connPollInterval is the longest read timeout set by a Read on the ports
+// without the runtime poller, to check periodically if the read deadline
+// has been changed.
+const connPollInterval = 100 * time.Millisecond
+
+// Conn adapts a Port to the net.Conn interface, to run the protocols
+// written for network connections over a serial line.
+//
+// The deadlines interrupt the reads and the writes in progress when they
+// expire, also if they're set while waiting. On the ports without the
+// runtime poller a Read waits in steps of at most connPollInterval, so a
+// deadline changed during a Read applies within that interval, while an
+// expired Write is aborted by resetting the output buffer of the port, or
+// by closing the port if it fails. The data of a Write interrupted may
+// have been sent in part, or discarded from the output buffer after being
+// reported as written. A Read or Write interrupted by a deadline returns a
+// net.Error wrapping os.ErrDeadlineExceeded. On the ports that don't
+// implement ReadTimeouter the read deadline is not supported.
+type Conn struct {
+	port     Port
+	deadline deadlinePort // nil if the port has no poller
+	addr     *Addr
+
+	readMu  sync.Mutex
+	writeMu sync.Mutex
+
+	mu            sync.Mutex
+	readDeadline  time.Time
+	writeDeadline time.Time
+	writing       bool
+	writeTimer    *time.Timer // aborts the Write in progress
+	writeExpired  bool
+
+	closeOnce sync.Once
+}
+
+// NewConn creates a new Conn that reads and writes port. The name is
+// returned as both the local and the remote address. The Conn takes the
+// ownership of the port: it must not be read or written directly anymore,
+// and it's closed by Close.
+func NewConn(port Port, name string) *Conn {
+	c := &Conn{port: port, addr: &Addr{Name: name}}
+	if d, ok := port.(deadlinePort); ok {
+		if d.setConnReadDeadline(time.Time{}) == nil && d.setConnWriteDeadline(time.Time{}) == nil {
+			c.deadline = d
+		}
+	}
+	return c
+}
+
+func (c *Conn) opError(op string, err error) error {
+	if os.IsTimeout(err) {
+		err = os.ErrDeadlineExceeded
+	}
+	return &net.OpError{Op: op, Net: "serial", Source: c.addr, Addr: c.addr, Err: err}
+}
+
+// Read reads the data received by the port.
+func (c *Conn) Read(b []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	if c.deadline != nil {
+		n, err := c.deadline.connRead(b)
+		if err != nil {
+			return n, c.opError("read", err)
+		}
+		return n, nil
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	_, polled := c.port.(ReadTimeouter)
+	for {
+		c.mu.Lock()
+		deadline := c.readDeadline
+		c.mu.Unlock()
+		timeout := NoTimeout
+		if polled {
+			timeout = connPollInterval
+		}
+		if !deadline.IsZero() {
+			left := time.Until(deadline)
+			if left <= 0 {
+				return 0, c.opError("read", os.ErrDeadlineExceeded)
+			}
+			if timeout == NoTimeout || left < timeout {
+				timeout = left
+			}
+		}
+		if err := setReadTimeout(c.port, timeout); err != nil {
+			return 0, c.opError("read", err)
+		}
+		n, err := c.port.Read(b)
+		if err != nil {
+			return n, c.opError("read", err)
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// Write writes data to the port.
+func (c *Conn) Write(b []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.deadline != nil {
+		n, err := c.deadline.connWrite(b)
+		if err != nil {
+			return n, c.opError("write", err)
+		}
+		return n, nil
+	}
+
+	c.mu.Lock()
+	if !c.writeDeadline.IsZero() && !time.Now().Before(c.writeDeadline) {
+		c.mu.Unlock()
+		return 0, c.opError("write", os.ErrDeadlineExceeded)
+	}
+	c.writing = true
+	c.writeExpired = false
+	c.startWriteTimer()
+	c.mu.Unlock()
+
+	n, err := c.port.Write(b)
+
+	c.mu.Lock()
+	c.writing = false
+	c.stopWriteTimer()
+	expired := c.writeExpired
+	c.mu.Unlock()
+	if expired {
+		// The output buffer has been reset: the data written may be lost
+		return n, c.opError("write", os.ErrDeadlineExceeded)
+	}
+	if err != nil {
+		return n, c.opError("write", err)
+	}
+	return n, nil
+}
+
+// startWriteTimer starts the timer that aborts the Write in progress when
+// the write deadline expires. It must be called with c.mu held.
+func (c *Conn) startWriteTimer() {
+	c.stopWriteTimer()
+	if c.writeDeadline.IsZero() {
+		return
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(time.Until(c.writeDeadline), func() {
+		c.mu.Lock()
+		if c.writeTimer != timer {
+			// The Write is over, or the deadline has been changed
+			c.mu.Unlock()
+			return
+		}
+		c.writeExpired = true
+		c.mu.Unlock()
+		if c.port.ResetOutputBuffer() != nil {
+			c.port.Close()
+		}
+	})
+	c.writeTimer = timer
+}
+
+// stopWriteTimer stops the timer started by startWriteTimer. It must be
+// called with c.mu held.
+func (c *Conn) stopWriteTimer() {
+	if c.writeTimer != nil {
+		c.writeTimer.Stop()
+		c.writeTimer = nil
+	}
+}
+
+// Close closes the port. The pending reads and writes are interrupted.
+func (c *Conn) Close() error {
+	err := c.opError("close", &PortError{code: PortClosed})
+	c.closeOnce.Do(func() {
+		err = nil
+		if cerr := c.port.Close(); cerr != nil {
+			err = c.opError("close", cerr)
+		}
+	})
+	return err
+}
+
+// LocalAddr returns the address of the port.
+func (c *Conn) LocalAddr() net.Addr {
+	return c.addr
+}
+
+// RemoteAddr returns the address of the port, like LocalAddr.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+// SetDeadline sets both the read and the write deadlines.
+func (c *Conn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+// SetReadDeadline sets the deadline of the reads, also of those already
+// waiting. A zero value disables the deadline.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	if c.deadline != nil {
+		if err := c.deadline.setConnReadDeadline(t); err != nil {
+			return c.opError("set", err)
+		}
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	return nil
+}
+
+// SetWriteDeadline sets the deadline of the writes, also of those already
+// waiting. A zero value disables the deadline.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	if c.deadline != nil {
+		if err := c.deadline.setConnWriteDeadline(t); err != nil {
+			return c.opError("set", err)
+		}
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeDeadline = t
+	if c.writing {
+		c.startWriteTimer()
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serial_test
+
+import (
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.bug.st/serial"
+	"go.bug.st/serial/internal/ptytest"
+)
+
+type Calculator struct{}
+
+func (Calculator) Add(args [2]int, res *int) error {
+	*res = args[0] + args[1]
+	return nil
+}
+
+func TestConnJSONRPC(t *testing.T) {
+	port, master, err := ptytest.Pair(&serial.Mode{BaudRate: 115200})
+	require.NoError(t, err)
+	client := serial.NewConn(port, "slave")
+	server := serial.NewConn(master, "master")
+	defer client.Close()
+	defer server.Close()
+
+	rpcServer := rpc.NewServer()
+	require.NoError(t, rpcServer.Register(Calculator{}))
+	go rpcServer.ServeCodec(jsonrpc.NewServerCodec(server))
+
+	rpcClient := jsonrpc.NewClient(client)
+	var sum int
+	require.NoError(t, rpcClient.Call("Calculator.Add", [2]int{40, 2}, &sum))
+	require.Equal(t, 42, sum)
+	require.NoError(t, rpcClient.Call("Calculator.Add", [2]int{-1, 1}, &sum))
+	require.Equal(t, 0, sum)
+}
+
+func TestConnDeadlines(t *testing.T) {
+	port, master, err := ptytest.Pair(&serial.Mode{BaudRate: 9600})
+	require.NoError(t, err)
+	defer master.Close()
+	conn := serial.NewConn(port, "slave")
+	defer conn.Close()
+
+	// A deadline set during a Read interrupts it
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		conn.SetReadDeadline(time.Now())
+	}()
+	buf := make([]byte, 16)
+	_, err = conn.Read(buf)
+	require.True(t, err.(net.Error).Timeout(), err)
+
+	// The data received after a timeout is not lost
+	require.NoError(t, conn.SetReadDeadline(time.Time{}))
+	_, err = master.Write([]byte("hello"))
+	require.NoError(t, err)
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(buf[:n]))
+
+	// Nobody reads the master, so the write blocks once the buffers of the
+	// pseudo-terminal are full
+	require.NoError(t, conn.SetWriteDeadline(time.Now().Add(50*time.Millisecond)))
+	data := make([]byte, 1024*1024)
+	n, err = conn.Write(data)
+	require.True(t, err.(net.Error).Timeout(), err)
+	require.True(t, n < len(data))
+}
+
+func TestConnDeadlinesApartFromReadTimeout(t *testing.T) {
+	port, master, err := ptytest.Pair(&serial.Mode{BaudRate: 9600})
+	require.NoError(t, err)
+	defer master.Close()
+	conn := serial.NewConn(port, "slave")
+	defer conn.Close()
+
+	// A Read of the port doesn't change the read deadline of the Conn
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(200*time.Millisecond)))
+	require.NoError(t, port.(serial.ReadTimeouter).SetReadTimeout(10*time.Millisecond))
+	buf := make([]byte, 16)
+	n, err := port.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, 0, n)
+	start := time.Now()
+	_, err = conn.Read(buf)
+	require.True(t, err.(net.Error).Timeout(), err)
+	require.True(t, time.Since(start) >= 150*time.Millisecond)
+
+	// The deadlines of the Conn don't apply to the port
+	require.NoError(t, conn.SetDeadline(time.Now()))
+	require.NoError(t, port.(serial.ReadTimeouter).SetReadTimeout(50*time.Millisecond))
+	start = time.Now()
+	n, err = port.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, 0, n)
+	require.True(t, time.Since(start) >= 40*time.Millisecond)
+	n, err = port.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.Equal(t, 5, n)
+}
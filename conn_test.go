@@ -0,0 +1,173 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serial
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var _ net.Conn = (*Conn)(nil)
+
+// blockingPort is a fakePort whose writes block until released or, unless
+// keepOnReset, aborted by ResetOutputBuffer.
+type blockingPort struct {
+	*fakePort
+	release     chan struct{}
+	abort       chan struct{}
+	keepOnReset bool
+	resets      int32
+	written     int32
+}
+
+func newBlockingPort() *blockingPort {
+	return &blockingPort{
+		fakePort: newFakePort(),
+		release:  make(chan struct{}),
+		abort:    make(chan struct{}, 1),
+	}
+}
+
+func (p *blockingPort) Write(b []byte) (int, error) {
+	select {
+	case <-p.release:
+		atomic.AddInt32(&p.written, int32(len(b)))
+		return len(b), nil
+	case <-p.abort:
+		return 0, errors.New("write aborted")
+	case <-p.closed:
+		return 0, &PortError{code: PortClosed}
+	}
+}
+
+func (p *blockingPort) ResetOutputBuffer() error {
+	atomic.AddInt32(&p.resets, 1)
+	if p.keepOnReset {
+		return nil
+	}
+	select {
+	case p.abort <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func requireTimeout(t *testing.T, err error) {
+	netErr, ok := err.(net.Error)
+	require.True(t, ok, err)
+	require.True(t, netErr.Timeout())
+	require.True(t, errors.Is(err, os.ErrDeadlineExceeded), err)
+}
+
+func TestConnReadDeadline(t *testing.T) {
+	port := newBlockingPort()
+	port.SetReadTimeout(time.Second)
+	conn := NewConn(port, "/dev/ttyUSB0")
+	defer conn.Close()
+	require.Equal(t, "serial", conn.LocalAddr().Network())
+	require.Equal(t, "/dev/ttyUSB0", conn.RemoteAddr().String())
+	require.Equal(t, time.Second, port.timeout)
+
+	buf := make([]byte, 16)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(50*time.Millisecond)))
+	start := time.Now()
+	_, err := conn.Read(buf)
+	requireTimeout(t, err)
+	require.True(t, time.Since(start) >= 50*time.Millisecond)
+
+	// An expired deadline fails at once
+	_, err = conn.Read(buf)
+	requireTimeout(t, err)
+
+	// A deadline set during a Read interrupts it
+	require.NoError(t, conn.SetReadDeadline(time.Time{}))
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		conn.SetReadDeadline(time.Now())
+	}()
+	start = time.Now()
+	_, err = conn.Read(buf)
+	requireTimeout(t, err)
+	require.True(t, time.Since(start) < connPollInterval+50*time.Millisecond)
+
+	// The data received after a timeout is not lost
+	port.data <- []byte("hello")
+	require.NoError(t, conn.SetReadDeadline(time.Time{}))
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(buf[:n]))
+	require.Equal(t, connPollInterval, port.timeout)
+}
+
+func TestConnWriteDeadline(t *testing.T) {
+	port := newBlockingPort()
+	conn := NewConn(port, "COM3")
+	defer conn.Close()
+
+	require.NoError(t, conn.SetWriteDeadline(time.Now().Add(50*time.Millisecond)))
+	_, err := conn.Write([]byte("hello"))
+	requireTimeout(t, err)
+	require.Equal(t, int32(1), atomic.LoadInt32(&port.resets))
+
+	// A deadline set during a Write interrupts it
+	require.NoError(t, conn.SetWriteDeadline(time.Time{}))
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		conn.SetWriteDeadline(time.Now())
+	}()
+	_, err = conn.Write([]byte("hello"))
+	requireTimeout(t, err)
+	require.Equal(t, int32(2), atomic.LoadInt32(&port.resets))
+
+	// The writes interrupted are not completed later
+	require.NoError(t, conn.SetWriteDeadline(time.Time{}))
+	go func() { port.release <- struct{}{} }()
+	n, err := conn.Write([]byte("world"))
+	require.NoError(t, err)
+	require.Equal(t, 5, n)
+	require.Equal(t, int32(5), atomic.LoadInt32(&port.written))
+
+	// A Write completed after the output buffer has been reset times out,
+	// the data may have been discarded
+	port.keepOnReset = true
+	require.NoError(t, conn.SetWriteDeadline(time.Now().Add(20*time.Millisecond)))
+	go func() {
+		for atomic.LoadInt32(&port.resets) < 3 {
+			time.Sleep(5 * time.Millisecond)
+		}
+		port.release <- struct{}{}
+	}()
+	n, err = conn.Write([]byte("again"))
+	requireTimeout(t, err)
+	require.Equal(t, 5, n)
+}
+
+func TestConnClose(t *testing.T) {
+	conn := NewConn(newBlockingPort(), "COM3")
+	errs := make(chan error, 2)
+	go func() {
+		_, err := conn.Read(make([]byte, 4))
+		errs <- err
+	}()
+	go func() {
+		_, err := conn.Write([]byte("hello"))
+		errs <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	require.NoError(t, conn.Close())
+	for i := 0; i < 2; i++ {
+		err := <-errs
+		require.True(t, errors.Is(err, ErrPortClosed), err)
+	}
+	require.Error(t, conn.Close())
+}
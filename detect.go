@@ -0,0 +1,171 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serial
+
+import (
+	"regexp"
+	"time"
+)
+
+// DefaultListenTime is the default time DetectBaudRate receives data
+// with each candidate mode.
+const DefaultListenTime = 500 * time.Millisecond
+
+// DefaultBaudRateCandidates are the modes tried by DetectBaudRate when no
+// candidates are given: the common baud rates with 8N1 framing.
+var DefaultBaudRateCandidates = []*Mode{
+	{BaudRate: 1200},
+	{BaudRate: 2400},
+	{BaudRate: 4800},
+	{BaudRate: 9600},
+	{BaudRate: 19200},
+	{BaudRate: 38400},
+	{BaudRate: 57600},
+	{BaudRate: 115200},
+}
+
+// Probe configures the detection of DetectBaudRate.
+type Probe struct {
+	// Data, if not empty, is sent after each change of mode to solicit a
+	// response from the device
+	Data []byte
+	// Expect, if set, is the pattern of the expected response
+	Expect *regexp.Regexp
+	// Listen is how long the data is received with each mode,
+	// DefaultListenTime if zero
+	Listen time.Duration
+}
+
+// DetectBaudRate sets the port to each of the candidate modes in turn, or
+// to DefaultBaudRateCandidates if none are given, and scores the data
+// received with each one. The port is left in the mode that scored best,
+// that is returned with a confidence between 0 and 1. If probe is nil the
+// port is only listened for DefaultListenTime.
+//
+// The data scores higher the more it is made of printable characters and
+// the less it contains framing and parity errors, that the drivers report
+// as NUL characters. A match of the expected response of the probe
+// outweighs both. The confidence is the margin of the best score over the
+// second best one, so it is low when more modes receive similar data.
+//
+// If no data is received with any mode a BaudRateNotDetected error is
+// returned. The read timeout of the ports returned by Open is restored at
+// the end, while other ports are left with NoTimeout.
+func DetectBaudRate(port Port, candidates []*Mode, probe *Probe) (*Mode, float64, error) {
+	if len(candidates) == 0 {
+		candidates = DefaultBaudRateCandidates
+	}
+	if probe == nil {
+		probe = &Probe{}
+	}
+	listen := probe.Listen
+	if listen <= 0 {
+		listen = DefaultListenTime
+	}
+	if _, ok := port.(ReadTimeouter); !ok {
+		return nil, 0, &PortError{code: FunctionNotImplemented}
+	}
+	defer setReadTimeout(port, readTimeoutOf(port))
+
+	var best *Mode
+	bestScore, secondScore := 0.0, 0.0
+	received := false
+	for _, mode := range candidates {
+		data, err := listenMode(port, mode, probe.Data, listen)
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(data) > 0 {
+			received = true
+		}
+		score := scoreData(data, probe.Expect)
+		if best == nil || score > bestScore {
+			if best != nil {
+				secondScore = bestScore
+			}
+			best, bestScore = mode, score
+		} else if score > secondScore {
+			secondScore = score
+		}
+	}
+	if !received {
+		return nil, 0, &PortError{code: BaudRateNotDetected}
+	}
+	if err := port.SetMode(best); err != nil {
+		return nil, 0, err
+	}
+	return best, bestScore - secondScore, nil
+}
+
+// listenMode sets mode, sends the probe and returns the data received in
+// the listen time.
+func listenMode(port Port, mode *Mode, probe []byte, listen time.Duration) ([]byte, error) {
+	if err := port.SetMode(mode); err != nil {
+		return nil, err
+	}
+	if err := port.ResetInputBuffer(); err != nil {
+		return nil, err
+	}
+	if len(probe) > 0 {
+		if _, err := port.Write(probe); err != nil {
+			return nil, err
+		}
+	}
+
+	deadline := time.Now().Add(listen)
+	data := []byte{}
+	buf := make([]byte, 256)
+	for {
+		t := time.Until(deadline)
+		if t <= 0 {
+			return data, nil
+		}
+		if err := setReadTimeout(port, t); err != nil {
+			return nil, err
+		}
+		n, err := port.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, buf[:n]...)
+	}
+}
+
+// readTimeoutOf returns the read timeout of the ports returned by Open, and
+// NoTimeout for the others.
+func readTimeoutOf(port Port) time.Duration {
+	if p, ok := port.(interface{ getReadTimeout() time.Duration }); ok {
+		return p.getReadTimeout()
+	}
+	return NoTimeout
+}
+
+// scoreData returns a score between 0 and 1 of the data received with a
+// mode.
+func scoreData(data []byte, expect *regexp.Regexp) float64 {
+	if len(data) == 0 {
+		return 0
+	}
+	printable, errors := 0, 0
+	for _, b := range data {
+		switch {
+		case b == 0:
+			errors++
+		case b >= 0x20 && b < 0x7F, b == '\r', b == '\n', b == '\t':
+			printable++
+		}
+	}
+	score := float64(printable) / float64(len(data))
+	score *= 1 - float64(errors)/float64(len(data))
+	if expect == nil {
+		return score
+	}
+	if expect.Match(data) {
+		return 0.5 + score/2
+	}
+	return score / 2
+}
@@ -0,0 +1,97 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serial_test
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.bug.st/serial"
+	"go.bug.st/serial/internal/ptytest"
+	"golang.org/x/sys/unix"
+)
+
+// simulateDevice answers to each probe received by master, with a response
+// at 9600 baud and with garbage at the other baud rates.
+func simulateDevice(master *ptytest.Port) {
+	raw, err := master.SyscallConn()
+	if err != nil {
+		return
+	}
+	buf := make([]byte, 64)
+	for {
+		if _, err := master.Read(buf); err != nil {
+			return
+		}
+		var speed uint32
+		raw.Control(func(fd uintptr) {
+			// The pseudo-terminal shares the settings of the slave side
+			if termios, err := unix.IoctlGetTermios(int(fd), unix.TCGETS); err == nil {
+				speed = termios.Cflag & unix.CBAUD
+			}
+		})
+		if speed == unix.B9600 {
+			master.Write([]byte("\r\nOK\r\n"))
+		} else {
+			master.Write([]byte{0x00, 0xF8, 0x80, 0x00, 0x78, 0xFE})
+		}
+	}
+}
+
+func TestDetectBaudRate(t *testing.T) {
+	port, master, err := ptytest.Pair(&serial.Mode{BaudRate: 1200})
+	require.NoError(t, err)
+	defer master.Close()
+	defer port.Close()
+	go simulateDevice(master)
+
+	candidates := []*serial.Mode{{BaudRate: 2400}, {BaudRate: 9600}, {BaudRate: 115200}}
+	probe := &serial.Probe{
+		Data:   []byte("AT\r"),
+		Expect: regexp.MustCompile(`OK\r\n`),
+		Listen: 100 * time.Millisecond,
+	}
+	mode, confidence, err := serial.DetectBaudRate(port, candidates, probe)
+	require.NoError(t, err)
+	require.Equal(t, 9600, mode.BaudRate)
+	require.True(t, confidence > 0.9, confidence)
+
+	// Without the expected response the text still wins over the garbage
+	probe.Expect = nil
+	mode, confidence, err = serial.DetectBaudRate(port, candidates, probe)
+	require.NoError(t, err)
+	require.Equal(t, 9600, mode.BaudRate)
+	require.True(t, confidence > 0.5, confidence)
+}
+
+func TestDetectBaudRateSilentDevice(t *testing.T) {
+	port, master, err := ptytest.Pair(&serial.Mode{BaudRate: 9600})
+	require.NoError(t, err)
+	defer master.Close()
+	defer port.Close()
+
+	require.NoError(t, port.(serial.ReadTimeouter).SetReadTimeout(20*time.Millisecond))
+	candidates := []*serial.Mode{{BaudRate: 9600}, {BaudRate: 19200}}
+	_, _, err = serial.DetectBaudRate(port, candidates, &serial.Probe{Listen: 50 * time.Millisecond})
+	require.Error(t, err)
+	require.Equal(t, serial.BaudRateNotDetected, err.(*serial.PortError).Code())
+
+	// The read timeout of the port is restored
+	read := make(chan error, 1)
+	go func() {
+		_, err := port.Read(make([]byte, 1))
+		read <- err
+	}()
+	select {
+	case err := <-read:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("read timeout not restored")
+	}
+}
@@ -0,0 +1,27 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serial
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScoreData(t *testing.T) {
+	require.Equal(t, 0.0, scoreData(nil, nil))
+	require.Equal(t, 1.0, scoreData([]byte("Temperature: 21.5\r\n"), nil))
+	require.Equal(t, 0.5, scoreData([]byte("ab\x80\xff"), nil))
+	// The framing errors lower the score of the printable data too
+	require.Equal(t, 0.25, scoreData([]byte("ab\x00\x00"), nil))
+
+	expect := regexp.MustCompile(`OK`)
+	require.Equal(t, 1.0, scoreData([]byte("\r\nOK\r\n"), expect))
+	require.Equal(t, 0.5, scoreData([]byte("\r\nERROR\r\n"), expect))
+	require.Equal(t, 0.75, scoreData([]byte("OK\x80\xff"), expect))
+}
@@ -48,6 +48,14 @@ The following snippets shows how to declare a configuration for 57600_E71:
 		StopBits: serial.OneStopBit,
 	}
 
+The same configuration can be obtained from its compact string form
+with the ParseMode function, and Mode.String does the reverse:
+
+	mode, err := serial.ParseMode("57600,7E1")
+	if err != nil {
+		log.Fatal(err)
+	}
+
 The configuration can be changed at any time with the SetMode function:
 
 	err := port.SetMode(mode)
@@ -79,6 +87,39 @@ serial port:
 		fmt.Printf("%v", string(buff[:n]))
 	}
 
+By default Read blocks until some data is received, a timeout can be set
+with SetReadTimeout, a method of the ReadTimeouter interface implemented
+by the ports returned by Open: if it expires Read returns 0 bytes and no
+error.
+
+	port.(serial.ReadTimeouter).SetReadTimeout(time.Second)
+
+A Reader adds buffering to a port and allows to read up to a delimiter,
+a line, a regular expression or a fixed number of bytes, within a timeout:
+
+	reader := serial.NewReader(port)
+	line, err := reader.ReadLine(2 * time.Second)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+If the baud rate of a device is unknown, DetectBaudRate tries a list of
+modes and keeps the one that receives the most plausible data:
+
+	probe := &serial.Probe{Data: []byte("AT\r"), Expect: regexp.MustCompile("OK")}
+	mode, confidence, err := serial.DetectBaudRate(port, nil, probe)
+
+NewConn adapts a port to the net.Conn interface, with deadlines, to run
+the protocols written for network connections over a serial line:
+
+	conn := serial.NewConn(port, "/dev/ttyUSB0")
+	client := jsonrpc.NewClient(conn)
+
+On unix the ports implement the syscall.Conn interface, that gives access
+to the file descriptor for the operations not covered by this library:
+
+	conn, err := port.(syscall.Conn).SyscallConn()
+
 If a port is a virtual USB-CDC serial port (for example an USB-to-RS232
 cable or a microcontroller development board) is possible to retrieve
 the USB metadata, like VID/PID or USB Serial Number, with the
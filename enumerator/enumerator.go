@@ -44,3 +44,8 @@ func (e PortEnumerationError) Error() string {
 	}
 	return reason
 }
+
+// Unwrap returns the error that caused the enumeration to fail, if any
+func (e PortEnumerationError) Unwrap() error {
+	return e.causedBy
+}
@@ -41,7 +41,7 @@ func nativeGetDetailedPortsList() ([]*PortDetails, error) {
 func extractPortInfo(service C.io_registry_entry_t) (*PortDetails, error) {
 	name, err := service.GetStringProperty("IOCalloutDevice")
 	if err != nil {
-		return nil, fmt.Errorf("Error extracting port info from device: %s", err.Error())
+		return nil, fmt.Errorf("Error extracting port info from device: %w", err)
 	}
 	port := &PortDetails{}
 	port.Name = name
@@ -6,7 +6,9 @@
 
 package enumerator
 
+import "go.bug.st/serial"
+
 func nativeGetDetailedPortsList() ([]*PortDetails, error) {
 	// TODO
-	return nil, &PortEnumerationError{}
+	return nil, &PortEnumerationError{causedBy: serial.ErrFunctionNotImplemented}
 }
@@ -41,11 +41,11 @@ func nativeGetPortDetails(portPath string) (*PortDetails, error) {
 	}
 	realDevicePath, err := filepath.EvalSymlinks(devicePath)
 	if err != nil {
-		return nil, fmt.Errorf("Can't determine real path of %s: %s", devicePath, err.Error())
+		return nil, fmt.Errorf("Can't determine real path of %s: %w", devicePath, err)
 	}
 	subSystemPath, err := filepath.EvalSymlinks(filepath.Join(realDevicePath, "subsystem"))
 	if err != nil {
-		return nil, fmt.Errorf("Can't determine real path of %s: %s", filepath.Join(realDevicePath, "subsystem"), err.Error())
+		return nil, fmt.Errorf("Can't determine real path of %s: %w", filepath.Join(realDevicePath, "subsystem"), err)
 	}
 	subSystem := filepath.Base(subSystemPath)
 
@@ -0,0 +1,38 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serial_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.bug.st/serial"
+	"golang.org/x/sys/unix"
+)
+
+func TestOpenErrors(t *testing.T) {
+	_, err := serial.Open("/dev/does-not-exist", &serial.Mode{})
+	require.True(t, errors.Is(err, serial.ErrPortNotFound))
+	require.True(t, errors.Is(err, os.ErrNotExist))
+	require.True(t, errors.Is(err, unix.ENOENT))
+
+	// A file that is not a terminal
+	f, err := ioutil.TempFile("", "serial")
+	require.NoError(t, err)
+	f.Close()
+	defer os.Remove(f.Name())
+	_, err = serial.Open(f.Name(), &serial.Mode{})
+	require.True(t, errors.Is(err, serial.ErrInvalidSerialPort))
+	require.True(t, errors.Is(err, unix.ENOTTY))
+
+	// The mode errors are returned as they are
+	_, err = serial.Open("/dev/ptmx", &serial.Mode{BaudRate: 12345})
+	require.True(t, errors.Is(err, serial.ErrInvalidSpeed))
+}
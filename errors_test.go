@@ -0,0 +1,44 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serial
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrorIs(t *testing.T) {
+	err := fmt.Errorf("opening modem: %w", &PortError{code: PermissionDenied, causedBy: syscall.EACCES})
+	require.True(t, errors.Is(err, ErrPermissionDenied))
+	require.False(t, errors.Is(err, ErrPortBusy))
+	require.True(t, errors.Is(err, os.ErrPermission))
+	require.True(t, errors.Is(err, syscall.EACCES))
+
+	var portErr *PortError
+	require.True(t, errors.As(err, &portErr))
+	require.Equal(t, PermissionDenied, portErr.Code())
+	require.Equal(t, syscall.EACCES, portErr.Unwrap())
+
+	// The os errors match the codes even without a cause
+	require.True(t, errors.Is(&PortError{code: PortNotFound}, os.ErrNotExist))
+	require.True(t, errors.Is(&PortError{code: PortClosed}, os.ErrClosed))
+	require.False(t, errors.Is(&PortError{code: PortClosed}, os.ErrNotExist))
+
+	_, err = ParseMode("9600,9N1")
+	require.True(t, errors.Is(err, ErrInvalidDataBits))
+	require.False(t, errors.Is(ErrReadTimeout, &PortError{code: ReadTimeout, causedBy: err}))
+
+	require.Equal(t, err, wrapError(InvalidSerialPort, err))
+	wrapped := wrapError(InvalidSerialPort, syscall.ENOTTY)
+	require.True(t, errors.Is(wrapped, ErrInvalidSerialPort))
+	require.True(t, errors.Is(wrapped, syscall.ENOTTY))
+}
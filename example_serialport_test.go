@@ -10,7 +10,7 @@ import "fmt"
 import "log"
 import "go.bug.st/serial"
 
-func ExampleSerialPort_SetMode() {
+func ExamplePort_SetMode() {
 	port, err := serial.Open("/dev/ttyACM0", &serial.Mode{})
 	if err != nil {
 		log.Fatal(err)
@@ -0,0 +1,13 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serial
+
+// SetPollerDisabled makes Open put the ports in blocking mode, so that
+// they're not handled by the runtime poller.
+func SetPollerDisabled(disabled bool) {
+	disablePoller = disabled
+}
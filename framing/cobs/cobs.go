@@ -0,0 +1,217 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+/*
+Package cobs implements the Consistent Overhead Byte Stuffing (COBS) and
+its reduced variant (COBS/R) on top of a serial port.
+
+The encoded frames never contain a 0x00 byte, so 0x00 is used as frame
+delimiter. The encoding overhead is bounded: at most one byte every 254
+bytes of payload, see MaxEncodedLen.
+
+Encode, EncodeR, Decode and DecodeR work on caller-provided buffers and
+don't allocate memory. A Framer sends and receives delimited frames on a
+serial port, optionally protected by a CRC trailer:
+
+	framer := cobs.NewFramer(port)
+	framer.Checksum = cobs.CRC32
+	if err := framer.WriteFrame([]byte("hello")); err != nil {
+		log.Fatal(err)
+	}
+	buf := make([]byte, 256)
+	n, err := framer.ReadFrame(buf)
+*/
+package cobs
+
+import "errors"
+
+// Delimiter is the byte that terminates each encoded frame.
+const Delimiter byte = 0x00
+
+var (
+	// ErrInvalidFrame is returned when a frame is not correctly encoded
+	ErrInvalidFrame = errors.New("cobs: invalid frame")
+	// ErrFrameTooLarge is returned when a decoded frame doesn't fit into
+	// the buffer provided
+	ErrFrameTooLarge = errors.New("cobs: frame too large")
+	// ErrChecksum is returned when the CRC trailer of a frame doesn't match
+	ErrChecksum = errors.New("cobs: checksum mismatch")
+)
+
+// MaxEncodedLen returns the maximum length of the encoding of n bytes,
+// without the delimiter.
+func MaxEncodedLen(n int) int {
+	return n + n/254 + 1
+}
+
+// Encode writes the COBS encoding of src into dst and returns the number of
+// bytes written. dst must be at least MaxEncodedLen(len(src)) bytes long.
+// The frame delimiter is not written.
+func Encode(dst, src []byte) int {
+	return encode(dst, src, nil, false)
+}
+
+// EncodeR writes the COBS/R encoding of src into dst and returns the number
+// of bytes written. dst must be at least MaxEncodedLen(len(src)) bytes
+// long. The frame delimiter is not written.
+func EncodeR(dst, src []byte) int {
+	return encode(dst, src, nil, true)
+}
+
+// encode encodes the concatenation of src and trailer into dst.
+func encode(dst, src, trailer []byte, reduced bool) int {
+	codeIdx := 0
+	j := 1
+	code := byte(1)
+	total := len(src) + len(trailer)
+	for i := 0; i < total; i++ {
+		var b byte
+		if i < len(src) {
+			b = src[i]
+		} else {
+			b = trailer[i-len(src)]
+		}
+		if b == 0 {
+			dst[codeIdx] = code
+			codeIdx = j
+			j++
+			code = 1
+			continue
+		}
+		dst[j] = b
+		j++
+		code++
+		if code == 0xFF && i+1 < total {
+			dst[codeIdx] = code
+			codeIdx = j
+			j++
+			code = 1
+		}
+	}
+	// In COBS/R the last byte of the frame replaces the code of the last
+	// block, if it's greater than the code itself.
+	if reduced && code > 1 && dst[j-1] > code {
+		dst[codeIdx] = dst[j-1]
+		return j - 1
+	}
+	dst[codeIdx] = code
+	return j
+}
+
+// Decode decodes the COBS encoded frame src, without delimiter, into dst and
+// returns the number of bytes written. It returns ErrFrameTooLarge if dst is
+// too small.
+func Decode(dst, src []byte) (int, error) {
+	return decodeFrame(dst, src, false)
+}
+
+// DecodeR decodes the COBS/R encoded frame src, without delimiter, into dst
+// and returns the number of bytes written. It returns ErrFrameTooLarge if
+// dst is too small.
+func DecodeR(dst, src []byte) (int, error) {
+	return decodeFrame(dst, src, true)
+}
+
+func decodeFrame(dst, src []byte, reduced bool) (int, error) {
+	d := decoder{reduced: reduced}
+	d.reset(dst, 0)
+	for _, b := range src {
+		if b == Delimiter {
+			return 0, ErrInvalidFrame
+		}
+		d.feed(b)
+	}
+	return d.finish()
+}
+
+// decoder is a streaming COBS decoder: the encoded bytes are fed one at a
+// time and the decoded bytes are written directly to the output buffer.
+// The last trailerLen decoded bytes are held back in a separate buffer, to
+// let the caller check the trailer without reserving room for it in out.
+type decoder struct {
+	reduced    bool
+	out        []byte
+	n          int  // bytes written to out
+	code       byte // code of the current block, 0 at the start of a frame
+	remaining  int  // bytes left in the current block
+	err        error
+	trailer    [4]byte
+	trailerLen int
+	held       int // bytes held in trailer
+}
+
+func (d *decoder) reset(out []byte, trailerLen int) {
+	d.out = out
+	d.n = 0
+	d.code = 0
+	d.remaining = 0
+	d.err = nil
+	d.trailerLen = trailerLen
+	d.held = 0
+}
+
+// empty returns true if nothing has been fed since the last reset.
+func (d *decoder) empty() bool {
+	return d.code == 0 && d.err == nil
+}
+
+func (d *decoder) emit(b byte) {
+	if d.held < d.trailerLen {
+		d.trailer[d.held] = b
+		d.held++
+		return
+	}
+	if d.trailerLen > 0 {
+		b, d.trailer[0] = d.trailer[0], b
+		for i := 1; i < d.trailerLen; i++ {
+			d.trailer[i-1], d.trailer[i] = d.trailer[i], d.trailer[i-1]
+		}
+	}
+	if d.n == len(d.out) {
+		d.err = ErrFrameTooLarge
+		return
+	}
+	d.out[d.n] = b
+	d.n++
+}
+
+// feed decodes an encoded byte, that must not be a delimiter.
+func (d *decoder) feed(b byte) {
+	if d.err != nil {
+		return
+	}
+	if d.remaining > 0 {
+		d.emit(b)
+		d.remaining--
+		return
+	}
+	// b is the code of a new block: the previous block, if any, is
+	// followed by an implicit zero unless it was a full block.
+	if d.code != 0 && d.code != 0xFF {
+		d.emit(0)
+	}
+	d.code = b
+	d.remaining = int(b) - 1
+}
+
+// finish completes the decoding of a frame, the delimiter has been received.
+func (d *decoder) finish() (int, error) {
+	if d.err == nil && d.remaining > 0 {
+		if d.reduced {
+			// The code of the last block is the last byte of the frame
+			d.emit(d.code)
+		} else {
+			d.err = ErrInvalidFrame
+		}
+	}
+	if d.err == nil && d.held < d.trailerLen {
+		d.err = ErrInvalidFrame
+	}
+	if d.err != nil {
+		return 0, d.err
+	}
+	return d.n, nil
+}
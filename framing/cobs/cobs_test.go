@@ -0,0 +1,223 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package cobs
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	long := make([]byte, 254)
+	for i := range long {
+		long[i] = byte(i + 1)
+	}
+	tests := []struct {
+		in    []byte
+		cobs  []byte
+		cobsr []byte
+	}{
+		{[]byte{}, []byte{0x01}, []byte{0x01}},
+		{[]byte{0x00}, []byte{0x01, 0x01}, []byte{0x01, 0x01}},
+		{[]byte{0x00, 0x00}, []byte{0x01, 0x01, 0x01}, []byte{0x01, 0x01, 0x01}},
+		{[]byte{0x11, 0x22, 0x00, 0x33}, []byte{0x03, 0x11, 0x22, 0x02, 0x33}, []byte{0x03, 0x11, 0x22, 0x33}},
+		{[]byte{0x11, 0x00, 0x02}, []byte{0x02, 0x11, 0x02, 0x02}, []byte{0x02, 0x11, 0x02, 0x02}},
+		{[]byte{0x11, 0x22, 0x33, 0x44}, []byte{0x05, 0x11, 0x22, 0x33, 0x44}, []byte{0x44, 0x11, 0x22, 0x33}},
+		{long, append([]byte{0xFF}, long...), append([]byte{0xFF}, long...)},
+		{append(long, 0x00), append(append([]byte{0xFF}, long...), 0x01, 0x01), append(append([]byte{0xFF}, long...), 0x01, 0x01)},
+	}
+	for _, test := range tests {
+		buf := make([]byte, MaxEncodedLen(len(test.in)))
+		n := Encode(buf, test.in)
+		require.Equal(t, test.cobs, buf[:n], "COBS % X", test.in)
+		n = EncodeR(buf, test.in)
+		require.Equal(t, test.cobsr, buf[:n], "COBS/R % X", test.in)
+
+		out := make([]byte, len(test.in))
+		n, err := Decode(out, test.cobs)
+		require.NoError(t, err)
+		require.Equal(t, test.in, out[:n])
+		n, err = DecodeR(out, test.cobsr)
+		require.NoError(t, err)
+		require.Equal(t, test.in, out[:n])
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	out := make([]byte, 10)
+	_, err := Decode(out, []byte{0x05, 0x11, 0x22})
+	require.Equal(t, ErrInvalidFrame, err)
+	_, err = Decode(out, []byte{0x02, 0x00, 0x11})
+	require.Equal(t, ErrInvalidFrame, err)
+	_, err = Decode(out[:2], []byte{0x04, 0x11, 0x22, 0x33})
+	require.Equal(t, ErrFrameTooLarge, err)
+}
+
+func TestRandomRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		in := make([]byte, r.Intn(1000))
+		for j := range in {
+			// Plenty of zeros and of high values
+			in[j] = []byte{0x00, 0xFF, 0x01, byte(r.Intn(256))}[r.Intn(4)]
+		}
+		enc := make([]byte, MaxEncodedLen(len(in)))
+		out := make([]byte, len(in))
+
+		n := Encode(enc, in)
+		require.NotContains(t, enc[:n], byte(0))
+		m, err := Decode(out, enc[:n])
+		require.NoError(t, err)
+		require.Equal(t, in, out[:m])
+
+		n = EncodeR(enc, in)
+		require.NotContains(t, enc[:n], byte(0))
+		m, err = DecodeR(out, enc[:n])
+		require.NoError(t, err)
+		require.Equal(t, in, out[:m])
+	}
+}
+
+type loopback struct {
+	io.Reader
+	io.Writer
+}
+
+// oneByteReader returns at most one byte for each Read
+type oneByteReader struct {
+	r io.Reader
+}
+
+func (o oneByteReader) Read(p []byte) (int, error) {
+	return o.r.Read(p[:1])
+}
+
+func TestFramer(t *testing.T) {
+	for _, variant := range []Variant{COBS, COBSR} {
+		for _, checksum := range []Checksum{NoChecksum, CRC16, CRC32} {
+			wire := &bytes.Buffer{}
+			writer := NewFramer(loopback{Writer: wire})
+			writer.Variant = variant
+			writer.Checksum = checksum
+			frames := [][]byte{{0x01}, {0x00, 0x00}, make([]byte, 600), []byte("hello")}
+			for _, frame := range frames {
+				require.NoError(t, writer.WriteFrame(frame))
+			}
+			// Add a frame too large and an empty one
+			require.NoError(t, writer.WriteFrame(make([]byte, 1001)))
+			wire.Write([]byte{0x00, 0x00})
+			require.NoError(t, writer.WriteFrame([]byte{0x42}))
+
+			reader := NewFramer(loopback{Reader: oneByteReader{wire}})
+			reader.Variant = variant
+			reader.Checksum = checksum
+			buf := make([]byte, 1000)
+			for _, frame := range frames {
+				n, err := reader.ReadFrame(buf)
+				require.NoError(t, err)
+				require.Equal(t, frame, buf[:n])
+			}
+			_, err := reader.ReadFrame(buf)
+			require.Equal(t, ErrFrameTooLarge, err)
+			n, err := reader.ReadFrame(buf)
+			require.NoError(t, err)
+			require.Equal(t, []byte{0x42}, buf[:n])
+		}
+	}
+}
+
+func TestFramerChecksum(t *testing.T) {
+	require.Equal(t, uint16(0x29B1), crc16([]byte("123456789")))
+
+	wire := &bytes.Buffer{}
+	framer := NewFramer(loopback{Reader: wire, Writer: wire})
+	framer.Checksum = CRC32
+	require.NoError(t, framer.WriteFrame([]byte{0x01, 0x02, 0x03}))
+	wire.Bytes()[2] ^= 0x40
+	require.NoError(t, framer.WriteFrame([]byte{0x04}))
+
+	buf := make([]byte, 3)
+	_, err := framer.ReadFrame(buf)
+	require.Equal(t, ErrChecksum, err)
+	n, err := framer.ReadFrame(buf)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x04}, buf[:n])
+}
+
+// repeater is a port that accepts any write and repeats the same data on
+// every read.
+type repeater struct {
+	data []byte
+}
+
+func (r *repeater) Read(p []byte) (int, error) {
+	return copy(p, r.data), nil
+}
+
+func (r *repeater) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func TestFramerAllocations(t *testing.T) {
+	payload := make([]byte, 200)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+	framer := NewFramer(&repeater{})
+	framer.Checksum = CRC32
+	require.NoError(t, framer.WriteFrame(payload))
+	allocs := testing.AllocsPerRun(100, func() {
+		framer.WriteFrame(payload)
+	})
+	require.Equal(t, 0.0, allocs)
+
+	enc := make([]byte, MaxEncodedLen(len(payload)+4)+1)
+	var trailer [4]byte
+	n := encode(enc, payload, trailer[:CRC32.sum(&trailer, payload)], false)
+	enc[n] = 0
+	reader := NewFramer(&repeater{data: enc[:n+1]})
+	reader.Checksum = CRC32
+	buf := make([]byte, len(payload))
+	allocs = testing.AllocsPerRun(100, func() {
+		reader.ReadFrame(buf)
+	})
+	require.Equal(t, 0.0, allocs)
+	m, err := reader.ReadFrame(buf)
+	require.NoError(t, err)
+	require.Equal(t, payload, buf[:m])
+}
+
+func BenchmarkEncode(b *testing.B) {
+	payload := make([]byte, 1024)
+	rand.Read(payload)
+	dst := make([]byte, MaxEncodedLen(len(payload)))
+	b.SetBytes(int64(len(payload)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		Encode(dst, payload)
+	}
+}
+
+func BenchmarkReadFrame(b *testing.B) {
+	payload := make([]byte, 1024)
+	rand.Read(payload)
+	enc := make([]byte, MaxEncodedLen(len(payload))+1)
+	n := Encode(enc, payload)
+	framer := NewFramer(&repeater{data: enc[:n+1]})
+	dst := make([]byte, len(payload))
+	b.SetBytes(int64(len(payload)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := framer.ReadFrame(dst); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
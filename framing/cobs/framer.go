@@ -0,0 +1,185 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package cobs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"sync"
+)
+
+// Variant selects the encoding used by a Framer
+type Variant int
+
+const (
+	// COBS is the standard Consistent Overhead Byte Stuffing (default)
+	COBS Variant = iota
+	// COBSR is the reduced variant, that often saves one byte per frame
+	COBSR
+)
+
+// Checksum selects the CRC trailer appended to the frames by a Framer
+type Checksum int
+
+const (
+	// NoChecksum disables the CRC trailer (default)
+	NoChecksum Checksum = iota
+	// CRC16 appends a CRC-16/CCITT-FALSE trailer (2 bytes, little endian)
+	CRC16
+	// CRC32 appends an IEEE CRC-32 trailer (4 bytes, little endian)
+	CRC32
+)
+
+func (c Checksum) size() int {
+	switch c {
+	case CRC16:
+		return 2
+	case CRC32:
+		return 4
+	default:
+		return 0
+	}
+}
+
+// sum writes the checksum of data into trailer and returns its size.
+func (c Checksum) sum(trailer *[4]byte, data []byte) int {
+	switch c {
+	case CRC16:
+		binary.LittleEndian.PutUint16(trailer[:], crc16(data))
+		return 2
+	case CRC32:
+		binary.LittleEndian.PutUint32(trailer[:], crc32.ChecksumIEEE(data))
+		return 4
+	default:
+		return 0
+	}
+}
+
+var crc16Table [256]uint16
+
+func init() {
+	for i := range crc16Table {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = (crc << 1) ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+		crc16Table[i] = crc
+	}
+}
+
+// crc16 computes the CRC-16/CCITT-FALSE of data.
+func crc16(data []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, b := range data {
+		crc = (crc << 8) ^ crc16Table[byte(crc>>8)^b]
+	}
+	return crc
+}
+
+// Framer reads and writes COBS encoded frames, delimited by zeros, on a
+// serial port (or any other io.ReadWriter). ReadFrame and WriteFrame may
+// be called concurrently. After the first frame has been sent or received
+// their hot paths don't allocate memory.
+//
+// Variant and Checksum must be set before using the Framer.
+type Framer struct {
+	Variant  Variant
+	Checksum Checksum
+
+	port io.ReadWriter
+
+	readLock sync.Mutex
+	rbuf     []byte
+	rpos     int
+	rend     int
+	dec      decoder
+
+	writeLock sync.Mutex
+	wbuf      []byte
+}
+
+// NewFramer creates a new Framer that reads and writes frames on port.
+func NewFramer(port io.ReadWriter) *Framer {
+	return &Framer{
+		port: port,
+		rbuf: make([]byte, 4096),
+	}
+}
+
+// ReadFrame receives the next frame and decodes it into dst, returning
+// the number of bytes of the payload. Empty frames are skipped. Partial
+// reads from the port are handled transparently and the bytes received
+// after the end of the frame are kept for the next call.
+//
+// If the frame is malformed, doesn't fit into dst or has a wrong checksum
+// it's discarded and ErrInvalidFrame, ErrFrameTooLarge or ErrChecksum is
+// returned: the next call will return the following frame.
+func (f *Framer) ReadFrame(dst []byte) (int, error) {
+	f.readLock.Lock()
+	defer f.readLock.Unlock()
+
+	trailerLen := f.Checksum.size()
+	f.dec.reduced = f.Variant == COBSR
+	f.dec.reset(dst, trailerLen)
+	for {
+		if f.rpos == f.rend {
+			n, err := f.port.Read(f.rbuf)
+			if n <= 0 && err != nil {
+				return 0, err
+			}
+			f.rpos, f.rend = 0, n
+			continue
+		}
+
+		b := f.rbuf[f.rpos]
+		f.rpos++
+		if b != Delimiter {
+			f.dec.feed(b)
+			continue
+		}
+		if f.dec.empty() {
+			continue
+		}
+
+		n, err := f.dec.finish()
+		if err != nil {
+			return 0, err
+		}
+		if trailerLen > 0 {
+			var sum [4]byte
+			f.Checksum.sum(&sum, dst[:n])
+			if !bytes.Equal(sum[:trailerLen], f.dec.trailer[:trailerLen]) {
+				return 0, ErrChecksum
+			}
+		}
+		return n, nil
+	}
+}
+
+// WriteFrame encodes and sends a frame, followed by the delimiter.
+func (f *Framer) WriteFrame(p []byte) error {
+	f.writeLock.Lock()
+	defer f.writeLock.Unlock()
+
+	var trailer [4]byte
+	trailerLen := f.Checksum.sum(&trailer, p)
+	size := MaxEncodedLen(len(p)+trailerLen) + 1
+	if cap(f.wbuf) < size {
+		f.wbuf = make([]byte, size)
+	}
+	buf := f.wbuf[:size]
+	n := encode(buf, p, trailer[:trailerLen], f.Variant == COBSR)
+	buf[n] = Delimiter
+	_, err := f.port.Write(buf[:n+1])
+	return err
+}
@@ -0,0 +1,9 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+// Package framing groups the packages that split the byte stream of a
+// serial port into packets.
+package framing
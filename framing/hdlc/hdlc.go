@@ -0,0 +1,338 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+/*
+Package hdlc implements the HDLC-like asynchronous framing used by PPP
+(RFC 1662) on top of a serial port.
+
+Each frame is enclosed between Flag characters and protected by a frame
+check sequence (FCS-16 or FCS-32). The Flag and ControlEscape characters,
+and the control characters selected by the Async Control Character Map
+(ACCM), are sent as ControlEscape followed by the character XOR 0x20:
+
+	framer := hdlc.NewFramer(port)
+	framer.FCS = hdlc.FCS32
+	framer.AddressControl = true
+	if err := framer.WriteFrame(packet); err != nil {
+		log.Fatal(err)
+	}
+	frame, err := framer.ReadFrame()
+
+The data received before the first Flag is discarded.
+*/
+package hdlc
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"sync"
+)
+
+// Special characters of the framing
+const (
+	Flag          byte = 0x7E
+	ControlEscape byte = 0x7D
+
+	// escapeXOR is applied to the escaped characters
+	escapeXOR byte = 0x20
+)
+
+// Default values of the address and control fields
+const (
+	AllStationsAddress byte = 0xFF
+	UIControl          byte = 0x03
+)
+
+// DefaultACCM is the initial value of the Async Control Character Map:
+// all the control characters are escaped.
+const DefaultACCM uint32 = 0xFFFFFFFF
+
+// DefaultMRU is the maximum size of a received frame, without address,
+// control and FCS fields, used if Framer.MRU is not set.
+const DefaultMRU = 1500
+
+var (
+	// ErrFCS is returned when a frame with a wrong FCS is received
+	ErrFCS = errors.New("hdlc: frame check sequence mismatch")
+	// ErrAborted is returned when a frame aborted by the sender is received
+	ErrAborted = errors.New("hdlc: frame aborted")
+	// ErrFrameTooLarge is returned when a frame larger than the MRU is
+	// received
+	ErrFrameTooLarge = errors.New("hdlc: frame too large")
+	// ErrFrameTooShort is returned when a frame too short to contain the
+	// FCS is received
+	ErrFrameTooShort = errors.New("hdlc: frame too short")
+)
+
+// FCS selects the frame check sequence
+type FCS int
+
+const (
+	// FCS16 is the 16-bit frame check sequence (default)
+	FCS16 FCS = iota
+	// FCS32 is the 32-bit frame check sequence
+	FCS32
+)
+
+func (f FCS) size() int {
+	if f == FCS32 {
+		return 4
+	}
+	return 2
+}
+
+// append appends the FCS of data, least significant byte first.
+func (f FCS) append(data []byte) []byte {
+	if f == FCS32 {
+		var fcs [4]byte
+		binary.LittleEndian.PutUint32(fcs[:], crc32.ChecksumIEEE(data))
+		return append(data, fcs[:]...)
+	}
+	var fcs [2]byte
+	binary.LittleEndian.PutUint16(fcs[:], ^fcs16(0xFFFF, data))
+	return append(data, fcs[:]...)
+}
+
+// check verifies the FCS at the end of frame.
+func (f FCS) check(frame []byte) bool {
+	if f == FCS32 {
+		// The CRC of a frame including its FCS is a constant
+		return crc32.ChecksumIEEE(frame) == 0x2144DF1C
+	}
+	return fcs16(0xFFFF, frame) == 0xF0B8
+}
+
+var fcs16Table [256]uint16
+
+func init() {
+	for i := range fcs16Table {
+		fcs := uint16(i)
+		for j := 0; j < 8; j++ {
+			if fcs&1 != 0 {
+				fcs = (fcs >> 1) ^ 0x8408
+			} else {
+				fcs >>= 1
+			}
+		}
+		fcs16Table[i] = fcs
+	}
+}
+
+// fcs16 updates the 16-bit FCS with data, as defined in RFC 1662.
+func fcs16(fcs uint16, data []byte) uint16 {
+	for _, b := range data {
+		fcs = (fcs >> 8) ^ fcs16Table[byte(fcs)^b]
+	}
+	return fcs
+}
+
+// Stats are the counters kept by a Framer.
+type Stats struct {
+	FramesReceived uint64 // Frames successfully received
+	FramesSent     uint64 // Frames sent
+	FCSErrors      uint64 // Frames discarded because of a wrong FCS
+	Aborts         uint64 // Frames aborted by the sender
+	OversizeErrors uint64 // Frames discarded because larger than the MRU
+	RuntErrors     uint64 // Frames discarded because too short
+}
+
+// Framer reads and writes HDLC-like frames on a serial port (or any other
+// io.ReadWriter). ReadFrame and WriteFrame may be called concurrently.
+//
+// FCS, AddressControl and MRU must be set before using the Framer, the
+// ACCM can be changed at any time with SetACCM.
+type Framer struct {
+	// FCS is the frame check sequence used
+	FCS FCS
+	// AddressControl enables the address and control fields: they are
+	// added to the frames sent, and removed, if present, from the frames
+	// received.
+	AddressControl bool
+	// MRU is the maximum size of a received frame, without address,
+	// control and FCS fields. If zero, DefaultMRU is used.
+	MRU int
+
+	port io.ReadWriter
+	in   *bufio.Reader
+
+	accmLock sync.Mutex
+	txACCM   uint32
+	rxACCM   uint32
+
+	readLock  sync.Mutex
+	synced    bool
+	writeLock sync.Mutex
+
+	statsLock sync.Mutex
+	stats     Stats
+}
+
+// NewFramer creates a new Framer that reads and writes frames on port,
+// using FCS16 and the DefaultACCM.
+func NewFramer(port io.ReadWriter) *Framer {
+	return &Framer{
+		port:   port,
+		in:     bufio.NewReader(port),
+		txACCM: DefaultACCM,
+		rxACCM: DefaultACCM,
+	}
+}
+
+// SetACCM sets the Async Control Character Map used for transmission and
+// reception. Bit n of a map selects the character n: in tx the selected
+// characters are escaped, in rx the selected characters received without
+// escape are discarded, since they could have been inserted by the
+// transmission equipment.
+func (f *Framer) SetACCM(tx, rx uint32) {
+	f.accmLock.Lock()
+	defer f.accmLock.Unlock()
+	f.txACCM = tx
+	f.rxACCM = rx
+}
+
+func (f *Framer) accm() (uint32, uint32) {
+	f.accmLock.Lock()
+	defer f.accmLock.Unlock()
+	return f.txACCM, f.rxACCM
+}
+
+// Stats returns a snapshot of the counters of the Framer.
+func (f *Framer) Stats() Stats {
+	f.statsLock.Lock()
+	defer f.statsLock.Unlock()
+	return f.stats
+}
+
+func (f *Framer) count(counter *uint64) {
+	f.statsLock.Lock()
+	*counter++
+	f.statsLock.Unlock()
+}
+
+func (f *Framer) mru() int {
+	if f.MRU > 0 {
+		return f.MRU
+	}
+	return DefaultMRU
+}
+
+// ReadFrame reads the next frame and returns its information field,
+// without address, control and FCS fields. Empty frames are skipped.
+// If a frame is aborted, too short, too large or has a wrong FCS it's
+// discarded and an error is returned: the next call will return the
+// following frame.
+func (f *Framer) ReadFrame() ([]byte, error) {
+	f.readLock.Lock()
+	defer f.readLock.Unlock()
+
+	if !f.synced {
+		if err := f.skipToFlag(); err != nil {
+			return nil, err
+		}
+		f.synced = true
+	}
+
+	_, rxACCM := f.accm()
+	max := f.mru() + 2 + f.FCS.size()
+	frame := []byte{}
+	escaped := false
+	for {
+		b, err := f.in.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if b < 0x20 && rxACCM&(1<<b) != 0 {
+			continue
+		}
+		switch {
+		case b == Flag && escaped:
+			f.count(&f.stats.Aborts)
+			return nil, ErrAborted
+		case b == Flag:
+			if len(frame) == 0 {
+				continue
+			}
+			return f.checkFrame(frame)
+		case b == ControlEscape:
+			escaped = true
+			continue
+		case escaped:
+			b ^= escapeXOR
+			escaped = false
+		}
+		if len(frame) == max {
+			f.count(&f.stats.OversizeErrors)
+			f.synced = false
+			return nil, ErrFrameTooLarge
+		}
+		frame = append(frame, b)
+	}
+}
+
+// skipToFlag discards the data received up to the next Flag.
+func (f *Framer) skipToFlag() error {
+	for {
+		b, err := f.in.ReadByte()
+		if err != nil {
+			return err
+		}
+		if b == Flag {
+			return nil
+		}
+	}
+}
+
+// checkFrame verifies the FCS of a received frame and removes the FCS and
+// the address and control fields.
+func (f *Framer) checkFrame(frame []byte) ([]byte, error) {
+	fcsSize := f.FCS.size()
+	if len(frame) <= fcsSize {
+		f.count(&f.stats.RuntErrors)
+		return nil, ErrFrameTooShort
+	}
+	if !f.FCS.check(frame) {
+		f.count(&f.stats.FCSErrors)
+		return nil, ErrFCS
+	}
+	frame = frame[:len(frame)-fcsSize]
+	if f.AddressControl && len(frame) >= 2 && frame[0] == AllStationsAddress && frame[1] == UIControl {
+		frame = frame[2:]
+	}
+	f.count(&f.stats.FramesReceived)
+	return frame, nil
+}
+
+// WriteFrame sends a frame with the given information field.
+func (f *Framer) WriteFrame(info []byte) error {
+	frame := make([]byte, 0, len(info)+2+f.FCS.size())
+	if f.AddressControl {
+		frame = append(frame, AllStationsAddress, UIControl)
+	}
+	frame = f.FCS.append(append(frame, info...))
+
+	txACCM, _ := f.accm()
+	out := make([]byte, 0, len(frame)*2+2)
+	out = append(out, Flag)
+	for _, b := range frame {
+		if b == Flag || b == ControlEscape || (b < 0x20 && txACCM&(1<<b) != 0) {
+			out = append(out, ControlEscape, b^escapeXOR)
+		} else {
+			out = append(out, b)
+		}
+	}
+	out = append(out, Flag)
+
+	f.writeLock.Lock()
+	defer f.writeLock.Unlock()
+	if _, err := f.port.Write(out); err != nil {
+		return err
+	}
+	f.count(&f.stats.FramesSent)
+	return nil
+}
@@ -0,0 +1,129 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package hdlc
+
+import (
+	"bytes"
+	"hash/crc32"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type loopback struct {
+	io.Reader
+	io.Writer
+}
+
+func TestFCS(t *testing.T) {
+	check := []byte("123456789")
+	require.Equal(t, uint16(0x906E), ^fcs16(0xFFFF, check))
+	require.Equal(t, uint32(0xCBF43926), crc32.ChecksumIEEE(check))
+
+	for _, fcs := range []FCS{FCS16, FCS32} {
+		frame := fcs.append(append([]byte{}, check...))
+		require.Len(t, frame, len(check)+fcs.size())
+		require.True(t, fcs.check(frame))
+		frame[0] ^= 1
+		require.False(t, fcs.check(frame))
+	}
+}
+
+func TestWriteFrame(t *testing.T) {
+	wire := &bytes.Buffer{}
+	framer := NewFramer(loopback{Writer: wire})
+	framer.AddressControl = true
+	require.NoError(t, framer.WriteFrame([]byte{0x7E, 0x7D, 0x11, 0x41}))
+
+	frame := []byte{0xFF, 0x03, 0x7E, 0x7D, 0x11, 0x41}
+	frame = FCS16.append(frame)
+	expected := []byte{Flag, 0xFF, 0x7D, 0x23, 0x7D, 0x5E, 0x7D, 0x5D, 0x7D, 0x31, 0x41}
+	for _, b := range frame[6:] {
+		if b == Flag || b == ControlEscape || b < 0x20 {
+			expected = append(expected, ControlEscape, b^0x20)
+		} else {
+			expected = append(expected, b)
+		}
+	}
+	expected = append(expected, Flag)
+	require.Equal(t, expected, wire.Bytes())
+
+	// With an empty ACCM control characters are sent as they are
+	wire.Reset()
+	framer.SetACCM(0, 0)
+	require.NoError(t, framer.WriteFrame([]byte{0x11}))
+	require.Equal(t, []byte{Flag, 0xFF, 0x03, 0x11}, wire.Bytes()[:4])
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, fcs := range []FCS{FCS16, FCS32} {
+		for _, accm := range []uint32{0, DefaultACCM, 0x000A0000} {
+			wire := &bytes.Buffer{}
+			framer := NewFramer(loopback{Reader: wire, Writer: wire})
+			framer.FCS = fcs
+			framer.SetACCM(accm, accm)
+			frames := [][]byte{{0x00}, {Flag, ControlEscape, 0x11, 0x13}, bytes.Repeat([]byte{0x7E}, 1500)}
+			for _, frame := range frames {
+				require.NoError(t, framer.WriteFrame(frame))
+			}
+			for _, frame := range frames {
+				received, err := framer.ReadFrame()
+				require.NoError(t, err)
+				require.Equal(t, frame, received)
+			}
+		}
+	}
+}
+
+func TestReadFrameErrors(t *testing.T) {
+	wire := &bytes.Buffer{}
+	framer := NewFramer(loopback{Reader: wire, Writer: wire})
+	framer.AddressControl = true
+	framer.MRU = 4
+
+	wire.Write([]byte("noise"))
+	require.NoError(t, framer.WriteFrame([]byte{0x01}))
+	// Aborted frame
+	wire.Write([]byte{0x41, 0x42, ControlEscape, Flag})
+	// Frame with a wrong FCS
+	wire.Write([]byte{0x41, 0x42, 0x43, Flag})
+	// Runt frame
+	wire.Write([]byte{0x41, Flag})
+	// Frame too large
+	require.NoError(t, framer.WriteFrame([]byte{1, 2, 3, 4, 5}))
+	// Unescaped control characters in the rx ACCM are ignored
+	encoded := &bytes.Buffer{}
+	require.NoError(t, NewFramer(loopback{Writer: encoded}).WriteFrame([]byte{0x42}))
+	noisy := encoded.Bytes()
+	noisy = append(noisy[:len(noisy)-1], 0x13, Flag)
+	wire.Write(append([]byte{Flag, 0x11}, noisy[1:]...))
+
+	frame, err := framer.ReadFrame()
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x01}, frame)
+	_, err = framer.ReadFrame()
+	require.Equal(t, ErrAborted, err)
+	_, err = framer.ReadFrame()
+	require.Equal(t, ErrFCS, err)
+	_, err = framer.ReadFrame()
+	require.Equal(t, ErrFrameTooShort, err)
+	_, err = framer.ReadFrame()
+	require.Equal(t, ErrFrameTooLarge, err)
+	frame, err = framer.ReadFrame()
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x42}, frame)
+
+	require.Equal(t, Stats{
+		FramesReceived: 2,
+		FramesSent:     2,
+		FCSErrors:      1,
+		Aborts:         1,
+		OversizeErrors: 1,
+		RuntErrors:     1,
+	}, framer.Stats())
+}
@@ -0,0 +1,219 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+/*
+Package slip implements the SLIP framing (RFC 1055) on top of a serial port.
+
+Each packet is sent enclosed between two END characters, and the END and
+ESC characters in the packet are replaced by two bytes escape sequences:
+
+	port, err := serial.Open("/dev/ttyUSB0", &serial.Mode{BaudRate: 115200})
+	if err != nil {
+		log.Fatal(err)
+	}
+	conn := slip.NewConn(port)
+	if err := conn.WritePacket([]byte{0x00, 0x08, 0x24}); err != nil {
+		log.Fatal(err)
+	}
+	packet, err := conn.ReadPacket()
+
+The data received before the first END character is discarded, since it
+can't be told apart from line noise: the peer is expected to start each
+packet with an END, as recommended by the RFC. After a malformed or
+oversized packet the reader discards data until the next END as well.
+*/
+package slip
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"sync"
+)
+
+// SLIP special characters
+const (
+	END    byte = 0xC0
+	ESC    byte = 0xDB
+	ESCEND byte = 0xDC
+	ESCESC byte = 0xDD
+)
+
+// DefaultMaxPacketSize is the maximum size of the packets accepted by
+// ReadPacket if Conn.MaxPacketSize is not set.
+const DefaultMaxPacketSize = 4096
+
+var (
+	// ErrPacketTooLarge is returned when a packet larger than the maximum
+	// packet size is received or is being sent.
+	ErrPacketTooLarge = errors.New("slip: packet too large")
+	// ErrInvalidEscape is returned when an ESC character is followed by
+	// an invalid character.
+	ErrInvalidEscape = errors.New("slip: invalid escape sequence")
+)
+
+// Stats are the counters kept by a Conn.
+type Stats struct {
+	PacketsReceived uint64 // Packets successfully received
+	PacketsSent     uint64 // Packets sent
+	OversizeErrors  uint64 // Packets discarded because too large
+	EscapeErrors    uint64 // Packets discarded because of an invalid escape
+	DiscardedBytes  uint64 // Bytes discarded while resynchronizing
+}
+
+// Conn reads and writes SLIP packets on a serial port (or any other
+// io.ReadWriter). ReadPacket and WritePacket may be called concurrently.
+type Conn struct {
+	// MaxPacketSize is the maximum size of a packet, if zero
+	// DefaultMaxPacketSize is used.
+	MaxPacketSize int
+
+	port io.ReadWriter
+	in   *bufio.Reader
+
+	readLock  sync.Mutex
+	synced    bool
+	writeLock sync.Mutex
+
+	statsLock sync.Mutex
+	stats     Stats
+}
+
+// NewConn creates a new Conn that reads and writes packets on port.
+func NewConn(port io.ReadWriter) *Conn {
+	return &Conn{
+		port: port,
+		in:   bufio.NewReader(port),
+	}
+}
+
+// Stats returns a snapshot of the counters of the connection.
+func (c *Conn) Stats() Stats {
+	c.statsLock.Lock()
+	defer c.statsLock.Unlock()
+	return c.stats
+}
+
+func (c *Conn) count(counter *uint64, n uint64) {
+	c.statsLock.Lock()
+	*counter += n
+	c.statsLock.Unlock()
+}
+
+func (c *Conn) maxPacketSize() int {
+	if c.MaxPacketSize > 0 {
+		return c.MaxPacketSize
+	}
+	return DefaultMaxPacketSize
+}
+
+// ReadPacket reads the next packet. Empty packets are skipped.
+// If a malformed or oversized packet is received, it's discarded and
+// ErrInvalidEscape or ErrPacketTooLarge is returned: the next call will
+// return the following packet.
+func (c *Conn) ReadPacket() ([]byte, error) {
+	c.readLock.Lock()
+	defer c.readLock.Unlock()
+
+	if !c.synced {
+		if err := c.resync(); err != nil {
+			return nil, err
+		}
+	}
+
+	max := c.maxPacketSize()
+	packet := []byte{}
+	for {
+		b, err := c.in.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		switch b {
+		case END:
+			if len(packet) == 0 {
+				continue
+			}
+			c.count(&c.stats.PacketsReceived, 1)
+			return packet, nil
+		case ESC:
+			b, err = c.in.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			switch b {
+			case ESCEND:
+				b = END
+			case ESCESC:
+				b = ESC
+			case END:
+				// The packet has been truncated, what follows is a new packet
+				c.count(&c.stats.EscapeErrors, 1)
+				c.count(&c.stats.DiscardedBytes, uint64(len(packet)+1))
+				return nil, ErrInvalidEscape
+			default:
+				c.count(&c.stats.EscapeErrors, 1)
+				c.count(&c.stats.DiscardedBytes, uint64(len(packet)+2))
+				c.synced = false
+				return nil, ErrInvalidEscape
+			}
+		}
+		if len(packet) == max {
+			c.count(&c.stats.OversizeErrors, 1)
+			c.count(&c.stats.DiscardedBytes, uint64(len(packet)+1))
+			c.synced = false
+			return nil, ErrPacketTooLarge
+		}
+		packet = append(packet, b)
+	}
+}
+
+// resync discards the data received up to the next END character.
+func (c *Conn) resync() error {
+	for {
+		b, err := c.in.ReadByte()
+		if err != nil {
+			return err
+		}
+		if b == END {
+			c.synced = true
+			return nil
+		}
+		c.count(&c.stats.DiscardedBytes, 1)
+	}
+}
+
+// WritePacket sends a packet. The packet is preceded by an END character
+// to flush any line noise received by the peer.
+func (c *Conn) WritePacket(packet []byte) error {
+	if len(packet) > c.maxPacketSize() {
+		return ErrPacketTooLarge
+	}
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	_, err := c.port.Write(Encode(make([]byte, 0, len(packet)*2+2), packet))
+	if err == nil {
+		c.count(&c.stats.PacketsSent, 1)
+	}
+	return err
+}
+
+// Encode appends the SLIP encoding of packet, including the leading and
+// trailing END characters, to dst and returns the extended buffer.
+func Encode(dst, packet []byte) []byte {
+	dst = append(dst, END)
+	for _, b := range packet {
+		switch b {
+		case END:
+			dst = append(dst, ESC, ESCEND)
+		case ESC:
+			dst = append(dst, ESC, ESCESC)
+		default:
+			dst = append(dst, b)
+		}
+	}
+	return append(dst, END)
+}
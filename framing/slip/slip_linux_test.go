@@ -0,0 +1,40 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package slip
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.bug.st/serial"
+	"go.bug.st/serial/internal/ptytest"
+)
+
+func TestSerialPort(t *testing.T) {
+	slave, master, err := ptytest.Pair(&serial.Mode{BaudRate: 115200})
+	require.NoError(t, err)
+	defer slave.Close()
+	defer master.Close()
+
+	a := NewConn(slave)
+	b := NewConn(master)
+	packets := [][]byte{
+		{0x01, 0x02, 0x03},
+		{END, END, ESC, ESC},
+		make([]byte, 1000),
+	}
+	go func() {
+		for _, p := range packets {
+			a.WritePacket(p)
+		}
+	}()
+	for _, p := range packets {
+		received, err := b.ReadPacket()
+		require.NoError(t, err)
+		require.Equal(t, p, received)
+	}
+}
@@ -0,0 +1,73 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package slip
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type loopback struct {
+	io.Reader
+	io.Writer
+}
+
+func TestEncode(t *testing.T) {
+	require.Equal(t,
+		[]byte{END, 0x01, ESC, ESCEND, 0x02, ESC, ESCESC, 0x03, END},
+		Encode(nil, []byte{0x01, END, 0x02, ESC, 0x03}))
+	require.Equal(t, []byte{0xAA, END, END}, Encode([]byte{0xAA}, nil))
+}
+
+func TestReadPacket(t *testing.T) {
+	in := []byte("garbage")
+	in = append(in, END, END, 0x01, ESC, ESCEND, ESC, ESCESC, END)
+	in = append(in, 0x02, ESC, 0x42, 0x03, END)
+	in = append(in, 0x04, 0x05, 0x06, 0x07, 0x08, END)
+	in = append(in, 0x09, ESC, END)
+	in = append(in, 0x0A, END)
+	conn := NewConn(loopback{Reader: bytes.NewReader(in)})
+	conn.MaxPacketSize = 4
+
+	packet, err := conn.ReadPacket()
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x01, END, ESC}, packet)
+
+	_, err = conn.ReadPacket()
+	require.Equal(t, ErrInvalidEscape, err)
+	_, err = conn.ReadPacket()
+	require.Equal(t, ErrPacketTooLarge, err)
+	_, err = conn.ReadPacket()
+	require.Equal(t, ErrInvalidEscape, err)
+
+	packet, err = conn.ReadPacket()
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x0A}, packet)
+
+	_, err = conn.ReadPacket()
+	require.Equal(t, io.EOF, err)
+
+	require.Equal(t, Stats{
+		PacketsReceived: 2,
+		OversizeErrors:  1,
+		EscapeErrors:    2,
+		DiscardedBytes:  uint64(len("garbage")) + 3 + 6 + 2,
+	}, conn.Stats())
+}
+
+func TestWritePacket(t *testing.T) {
+	out := &bytes.Buffer{}
+	conn := NewConn(loopback{Writer: out})
+	conn.MaxPacketSize = 2
+	require.NoError(t, conn.WritePacket([]byte{END, ESC}))
+	require.Equal(t, ErrPacketTooLarge, conn.WritePacket([]byte{1, 2, 3}))
+	require.Equal(t, []byte{END, ESC, ESCEND, ESC, ESCESC, END}, out.Bytes())
+	require.Equal(t, uint64(1), conn.Stats().PacketsSent)
+}
@@ -0,0 +1,13 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+// Package ptytest provides pseudo-terminal pairs to test serial protocols
+// without real hardware attached.
+//
+// The slave side of the pseudo-terminal is a real tty that can be opened
+// with serial.Open, while the master side is returned as a Port that
+// implements serial.Port on top of the master file descriptor.
+package ptytest
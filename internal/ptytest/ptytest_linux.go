@@ -0,0 +1,151 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package ptytest
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"go.bug.st/serial"
+	"golang.org/x/sys/unix"
+)
+
+// Open creates a new pseudo-terminal and returns its master side and the
+// path of the slave device.
+func Open() (*os.File, string, error) {
+	// The master is opened in non-blocking mode so that it's handled by the
+	// runtime poller and a Close unblocks pending reads.
+	fd, err := unix.Open("/dev/ptmx", unix.O_RDWR|unix.O_NOCTTY|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, "", err
+	}
+	master := os.NewFile(uintptr(fd), "/dev/ptmx")
+	if err := unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0); err != nil {
+		master.Close()
+		return nil, "", err
+	}
+	n, err := unix.IoctlGetInt(fd, unix.TIOCGPTN)
+	if err != nil {
+		master.Close()
+		return nil, "", err
+	}
+	return master, fmt.Sprintf("/dev/pts/%d", n), nil
+}
+
+// Pair creates a new pseudo-terminal, opens its slave side with serial.Open
+// using the given mode and returns it together with the master side.
+func Pair(mode *serial.Mode) (serial.Port, *Port, error) {
+	master, slaveName, err := Open()
+	if err != nil {
+		return nil, nil, err
+	}
+	slave, err := serial.Open(slaveName, mode)
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	m := *mode
+	return slave, &Port{File: master, mode: &m, readTimeout: serial.NoTimeout}, nil
+}
+
+// Port is a serial.Port backed by the master side of a pseudo-terminal.
+// Mode and modem control changes are recorded but have no effect on the
+// line, since a pseudo-terminal has neither a baud rate nor modem lines.
+type Port struct {
+	*os.File
+
+	mu          sync.Mutex
+	mode        *serial.Mode
+	dtr         bool
+	rts         bool
+	readTimeout time.Duration
+}
+
+// Read reads from the master side. If a read timeout is set and expires
+// it returns 0 and no error, like a serial.Port.
+func (p *Port) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	timeout := p.readTimeout
+	p.mu.Unlock()
+
+	var deadline time.Time
+	if timeout != serial.NoTimeout {
+		deadline = time.Now().Add(timeout)
+	}
+	if err := p.File.SetReadDeadline(deadline); err != nil {
+		return 0, err
+	}
+	n, err := p.File.Read(b)
+	if err, ok := err.(interface{ Timeout() bool }); ok && err.Timeout() {
+		return n, nil
+	}
+	return n, err
+}
+
+// SetReadTimeout sets the timeout of Read.
+func (p *Port) SetReadTimeout(timeout time.Duration) error {
+	if timeout < 0 && timeout != serial.NoTimeout {
+		return fmt.Errorf("ptytest: invalid timeout %v", timeout)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.readTimeout = timeout
+	return nil
+}
+
+// Mode returns the last Mode set on the port.
+func (p *Port) Mode() *serial.Mode {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	m := *p.mode
+	return &m
+}
+
+// SetMode records the mode of the port.
+func (p *Port) SetMode(mode *serial.Mode) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	m := *mode
+	p.mode = &m
+	return nil
+}
+
+// ResetInputBuffer is a no-op.
+func (p *Port) ResetInputBuffer() error {
+	return nil
+}
+
+// ResetOutputBuffer is a no-op.
+func (p *Port) ResetOutputBuffer() error {
+	return nil
+}
+
+// SetDTR records the DTR status, it is reported back as DSR.
+func (p *Port) SetDTR(dtr bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.dtr = dtr
+	return nil
+}
+
+// SetRTS records the RTS status, it is reported back as CTS.
+func (p *Port) SetRTS(rts bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rts = rts
+	return nil
+}
+
+// GetModemStatusBits returns the DTR and RTS status looped back as DSR
+// and CTS.
+func (p *Port) GetModemStatusBits() (*serial.ModemStatusBits, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return &serial.ModemStatusBits{CTS: p.rts, DSR: p.dtr}, nil
+}
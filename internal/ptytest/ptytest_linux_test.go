@@ -0,0 +1,35 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package ptytest
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.bug.st/serial"
+)
+
+func TestPair(t *testing.T) {
+	slave, master, err := Pair(&serial.Mode{BaudRate: 115200})
+	require.NoError(t, err)
+	defer slave.Close()
+	defer master.Close()
+
+	_, err = slave.Write([]byte("ping"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(master, buf)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(buf))
+
+	_, err = master.Write([]byte("pong"))
+	require.NoError(t, err)
+	_, err = io.ReadFull(slave, buf)
+	require.NoError(t, err)
+	require.Equal(t, "pong", string(buf))
+}
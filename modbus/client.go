@@ -0,0 +1,460 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package modbus
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// DefaultTimeout is the default time to wait for a response
+const DefaultTimeout = time.Second
+
+// DefaultTurnaroundDelay is the default time to wait after a broadcast
+// request, to let the devices process it.
+const DefaultTurnaroundDelay = 100 * time.Millisecond
+
+// Client is a Modbus RTU client (master). It must be the only user of the
+// serial port: a goroutine continuously reads from the port to keep track
+// of the bus activity.
+//
+// A Client is safe for concurrent use, the requests are serialized on the
+// bus.
+type Client struct {
+	// Timeout is the maximum time to wait for the response to a request.
+	// If zero, DefaultTimeout is used. It can be overridden for a specific
+	// unit with SetUnitTimeout, and a shorter deadline can be set for a
+	// single request with the context passed to it.
+	Timeout time.Duration
+
+	// Retries is the number of times a request is sent again after a
+	// timeout or a corrupted response.
+	Retries int
+
+	// TurnaroundDelay is the time to wait after a broadcast request.
+	// If zero, DefaultTurnaroundDelay is used.
+	TurnaroundDelay time.Duration
+
+	port serial.Port
+	recv *receiver
+
+	mu           sync.Mutex
+	mode         serial.Mode
+	unitTimeouts map[byte]time.Duration
+	lastActivity time.Time
+	err          error
+}
+
+// NewClient creates a new Client that talks on the given port. The mode
+// must be the one currently set on the port: it's used to compute the
+// timings of the RTU framing.
+func NewClient(port serial.Port, mode *serial.Mode) *Client {
+	return &Client{
+		port:         port,
+		recv:         newReceiver(port),
+		mode:         *mode,
+		lastActivity: time.Now(),
+	}
+}
+
+// SetMode changes the configuration of the serial port.
+func (c *Client) SetMode(mode *serial.Mode) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.port.SetMode(mode); err != nil {
+		return err
+	}
+	c.mode = *mode
+	return nil
+}
+
+// SetUnitTimeout sets the maximum time to wait for the responses of a
+// specific unit, overriding Timeout. A zero timeout removes the override.
+func (c *Client) SetUnitTimeout(unit byte, timeout time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if timeout == 0 {
+		delete(c.unitTimeouts, unit)
+		return
+	}
+	if c.unitTimeouts == nil {
+		c.unitTimeouts = map[byte]time.Duration{}
+	}
+	c.unitTimeouts[unit] = timeout
+}
+
+// Close closes the underlying serial port.
+func (c *Client) Close() error {
+	c.recv.stop()
+	return c.port.Close()
+}
+
+// Send sends a raw request PDU (function code and data) to the given unit
+// and returns the response PDU. An exception response is returned as an
+// *Exception error. Requests sent to the BroadcastAddress return a nil
+// response.
+func (c *Client) Send(ctx context.Context, unit byte, pdu []byte) ([]byte, error) {
+	if len(pdu) == 0 || len(pdu) > maxFrameSize-3 {
+		return nil, fmt.Errorf("modbus: invalid PDU size %d", len(pdu))
+	}
+	frame := appendCRC(append([]byte{unit}, pdu...))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var err error
+	for attempt := 0; attempt <= c.Retries; attempt++ {
+		var res []byte
+		res, err = c.exchange(ctx, frame)
+		if err != ErrTimeout && err != ErrCRC && err != ErrInvalidResponse {
+			return res, err
+		}
+	}
+	return nil, err
+}
+
+func (c *Client) exchange(ctx context.Context, frame []byte) ([]byte, error) {
+	if err := c.waitIdle(ctx); err != nil {
+		return nil, err
+	}
+	if _, err := c.port.Write(frame); err != nil {
+		return nil, err
+	}
+	// The response can't start before the request has been transmitted
+	c.lastActivity = time.Now().Add(time.Duration(len(frame)) * CharTime(&c.mode))
+
+	unit, function := frame[0], frame[1]
+	if unit == BroadcastAddress {
+		delay := c.TurnaroundDelay
+		if delay == 0 {
+			delay = DefaultTurnaroundDelay
+		}
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			return nil, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	res, err := c.readResponse(ctx, unit, function)
+	if err != nil {
+		return nil, err
+	}
+	if !checkCRC(res) {
+		return nil, ErrCRC
+	}
+	if res[0] != unit {
+		return nil, ErrInvalidResponse
+	}
+	if res[1] == function|exceptionFlag {
+		return nil, &Exception{Function: function, Code: ExceptionCode(res[2])}
+	}
+	if res[1] != function {
+		return nil, ErrInvalidResponse
+	}
+	return res[1 : len(res)-2], nil
+}
+
+// receive processes a chunk taken from the receiver: it returns the data
+// received or the error that stopped the receiver.
+func (c *Client) receive(ch chunk, ok bool) ([]byte, error) {
+	if !ok {
+		if c.err == nil {
+			c.err = ErrClosed
+		}
+		return nil, c.err
+	}
+	if ch.err != nil {
+		c.err = ch.err
+		return nil, ch.err
+	}
+	c.lastActivity = ch.at
+	return ch.data, nil
+}
+
+// waitIdle discards any unexpected data received and waits until the bus
+// has been silent for at least the inter-frame delay.
+func (c *Client) waitIdle(ctx context.Context) error {
+	if c.err != nil {
+		return c.err
+	}
+	delay := FrameDelay(&c.mode)
+	for {
+		wait := time.Until(c.lastActivity.Add(delay))
+		if wait <= 0 {
+			select {
+			case ch, ok := <-c.recv.chunks:
+				if _, err := c.receive(ch, ok); err != nil {
+					return err
+				}
+				continue
+			default:
+				return nil
+			}
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case ch, ok := <-c.recv.chunks:
+			timer.Stop()
+			if _, err := c.receive(ch, ok); err != nil {
+				return err
+			}
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// readResponse waits for a complete response frame. The end of the frame
+// is detected from its content for the function codes known to this
+// package, and from the inter-frame gap for any other function code.
+func (c *Client) readResponse(ctx context.Context, unit, function byte) ([]byte, error) {
+	timeout, ok := c.unitTimeouts[unit]
+	if !ok {
+		timeout = c.Timeout
+	}
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	gap := FrameDelay(&c.mode)
+	var gapTimer *time.Timer
+	var gapElapsed <-chan time.Time
+	defer func() {
+		if gapTimer != nil {
+			gapTimer.Stop()
+		}
+	}()
+
+	frame := []byte{}
+	for {
+		select {
+		case ch, ok := <-c.recv.chunks:
+			data, err := c.receive(ch, ok)
+			if err != nil {
+				return nil, err
+			}
+			frame = append(frame, data...)
+			if len(frame) > maxFrameSize {
+				return nil, ErrInvalidResponse
+			}
+			n := responseLength(function, frame)
+			if n > 0 && len(frame) >= n {
+				return frame[:n], nil
+			}
+			if n < 0 {
+				if gapTimer != nil {
+					gapTimer.Stop()
+				}
+				gapTimer = time.NewTimer(gap)
+				gapElapsed = gapTimer.C
+			}
+		case <-gapElapsed:
+			if len(frame) < 4 {
+				return nil, ErrInvalidResponse
+			}
+			return frame, nil
+		case <-deadline.C:
+			return nil, ErrTimeout
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func checkQuantity(quantity, max int) error {
+	if quantity < 1 || quantity > max {
+		return fmt.Errorf("modbus: invalid quantity %d (must be between 1 and %d)", quantity, max)
+	}
+	return nil
+}
+
+var errBroadcastRead = errors.New("modbus: read requests can not be broadcast")
+
+func (c *Client) readBits(ctx context.Context, unit byte, function byte, address uint16, quantity int) ([]bool, error) {
+	if err := checkQuantity(quantity, MaxReadBits); err != nil {
+		return nil, err
+	}
+	if unit == BroadcastAddress {
+		return nil, errBroadcastRead
+	}
+	req := []byte{function, 0, 0, 0, 0}
+	binary.BigEndian.PutUint16(req[1:], address)
+	binary.BigEndian.PutUint16(req[3:], uint16(quantity))
+	res, err := c.Send(ctx, unit, req)
+	if err != nil {
+		return nil, err
+	}
+	count := (quantity + 7) / 8
+	if len(res) != 2+count || int(res[1]) != count {
+		return nil, ErrInvalidResponse
+	}
+	return unpackBits(res[2:], quantity), nil
+}
+
+func (c *Client) readRegisters(ctx context.Context, unit byte, function byte, address uint16, quantity int) ([]uint16, error) {
+	if err := checkQuantity(quantity, MaxReadRegisters); err != nil {
+		return nil, err
+	}
+	if unit == BroadcastAddress {
+		return nil, errBroadcastRead
+	}
+	req := []byte{function, 0, 0, 0, 0}
+	binary.BigEndian.PutUint16(req[1:], address)
+	binary.BigEndian.PutUint16(req[3:], uint16(quantity))
+	res, err := c.Send(ctx, unit, req)
+	if err != nil {
+		return nil, err
+	}
+	if len(res) != 2+quantity*2 || int(res[1]) != quantity*2 {
+		return nil, ErrInvalidResponse
+	}
+	return unpackRegisters(res[2:]), nil
+}
+
+// sendWrite sends a write request and checks that the response echoes the
+// first n bytes of the request.
+func (c *Client) sendWrite(ctx context.Context, unit byte, req []byte, n int) error {
+	res, err := c.Send(ctx, unit, req)
+	if err != nil || unit == BroadcastAddress {
+		return err
+	}
+	if !bytes.Equal(res, req[:n]) {
+		return ErrInvalidResponse
+	}
+	return nil
+}
+
+// ReadCoils reads quantity coils starting from address (function code 1).
+func (c *Client) ReadCoils(ctx context.Context, unit byte, address uint16, quantity int) ([]bool, error) {
+	return c.readBits(ctx, unit, FuncReadCoils, address, quantity)
+}
+
+// ReadDiscreteInputs reads quantity discrete inputs starting from address
+// (function code 2).
+func (c *Client) ReadDiscreteInputs(ctx context.Context, unit byte, address uint16, quantity int) ([]bool, error) {
+	return c.readBits(ctx, unit, FuncReadDiscreteInputs, address, quantity)
+}
+
+// ReadHoldingRegisters reads quantity holding registers starting from
+// address (function code 3).
+func (c *Client) ReadHoldingRegisters(ctx context.Context, unit byte, address uint16, quantity int) ([]uint16, error) {
+	return c.readRegisters(ctx, unit, FuncReadHoldingRegisters, address, quantity)
+}
+
+// ReadInputRegisters reads quantity input registers starting from address
+// (function code 4).
+func (c *Client) ReadInputRegisters(ctx context.Context, unit byte, address uint16, quantity int) ([]uint16, error) {
+	return c.readRegisters(ctx, unit, FuncReadInputRegisters, address, quantity)
+}
+
+// WriteSingleCoil sets the coil at address to value (function code 5).
+func (c *Client) WriteSingleCoil(ctx context.Context, unit byte, address uint16, value bool) error {
+	req := []byte{FuncWriteSingleCoil, 0, 0, 0, 0}
+	binary.BigEndian.PutUint16(req[1:], address)
+	if value {
+		req[3] = 0xFF
+	}
+	return c.sendWrite(ctx, unit, req, len(req))
+}
+
+// WriteSingleRegister sets the holding register at address to value
+// (function code 6).
+func (c *Client) WriteSingleRegister(ctx context.Context, unit byte, address uint16, value uint16) error {
+	req := []byte{FuncWriteSingleRegister, 0, 0, 0, 0}
+	binary.BigEndian.PutUint16(req[1:], address)
+	binary.BigEndian.PutUint16(req[3:], value)
+	return c.sendWrite(ctx, unit, req, len(req))
+}
+
+// WriteMultipleCoils sets the coils starting from address to values
+// (function code 15).
+func (c *Client) WriteMultipleCoils(ctx context.Context, unit byte, address uint16, values []bool) error {
+	if err := checkQuantity(len(values), MaxWriteBits); err != nil {
+		return err
+	}
+	data := packBits(values)
+	req := []byte{FuncWriteMultipleCoils, 0, 0, 0, 0, byte(len(data))}
+	binary.BigEndian.PutUint16(req[1:], address)
+	binary.BigEndian.PutUint16(req[3:], uint16(len(values)))
+	req = append(req, data...)
+	return c.sendWrite(ctx, unit, req, 5)
+}
+
+// WriteMultipleRegisters sets the holding registers starting from address
+// to values (function code 16).
+func (c *Client) WriteMultipleRegisters(ctx context.Context, unit byte, address uint16, values []uint16) error {
+	if err := checkQuantity(len(values), MaxWriteRegisters); err != nil {
+		return err
+	}
+	req := []byte{FuncWriteMultipleRegisters, 0, 0, 0, 0, byte(len(values) * 2)}
+	binary.BigEndian.PutUint16(req[1:], address)
+	binary.BigEndian.PutUint16(req[3:], uint16(len(values)))
+	req = append(req, packRegisters(values)...)
+	return c.sendWrite(ctx, unit, req, 5)
+}
+
+// ReadWriteMultipleRegisters writes values to the holding registers
+// starting from writeAddress and then reads readQuantity holding registers
+// starting from readAddress, in a single transaction (function code 23).
+func (c *Client) ReadWriteMultipleRegisters(ctx context.Context, unit byte, readAddress uint16, readQuantity int, writeAddress uint16, values []uint16) ([]uint16, error) {
+	if err := checkQuantity(readQuantity, MaxReadRegisters); err != nil {
+		return nil, err
+	}
+	if err := checkQuantity(len(values), MaxReadWriteRegisters); err != nil {
+		return nil, err
+	}
+	if unit == BroadcastAddress {
+		return nil, errBroadcastRead
+	}
+	req := []byte{FuncReadWriteMultipleRegisters, 0, 0, 0, 0, 0, 0, 0, 0, byte(len(values) * 2)}
+	binary.BigEndian.PutUint16(req[1:], readAddress)
+	binary.BigEndian.PutUint16(req[3:], uint16(readQuantity))
+	binary.BigEndian.PutUint16(req[5:], writeAddress)
+	binary.BigEndian.PutUint16(req[7:], uint16(len(values)))
+	req = append(req, packRegisters(values)...)
+	res, err := c.Send(ctx, unit, req)
+	if err != nil {
+		return nil, err
+	}
+	if len(res) != 2+readQuantity*2 || int(res[1]) != readQuantity*2 {
+		return nil, ErrInvalidResponse
+	}
+	return unpackRegisters(res[2:]), nil
+}
+
+// Diagnostics sends a diagnostics request with the given sub-function and
+// data (function code 8) and returns the data field of the response. The
+// sub-functions that return a counter, like DiagReturnBusMessageCount,
+// are supported; DiagReturnQueryData is supported only with a 2 bytes data
+// field.
+func (c *Client) Diagnostics(ctx context.Context, unit byte, subFunction uint16, data uint16) (uint16, error) {
+	req := []byte{FuncDiagnostics, 0, 0, 0, 0}
+	binary.BigEndian.PutUint16(req[1:], subFunction)
+	binary.BigEndian.PutUint16(req[3:], data)
+	res, err := c.Send(ctx, unit, req)
+	if err != nil || unit == BroadcastAddress {
+		return 0, err
+	}
+	if len(res) != 5 || !bytes.Equal(res[:3], req[:3]) {
+		return 0, ErrInvalidResponse
+	}
+	return binary.BigEndian.Uint16(res[3:]), nil
+}
@@ -0,0 +1,167 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package modbus
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.bug.st/serial"
+	"go.bug.st/serial/internal/ptytest"
+)
+
+// simulateSlave answers the requests received on port with the frames
+// returned by handle. A nil frame means no answer.
+func simulateSlave(port *ptytest.Port, handle func(req []byte) []byte) {
+	buf := make([]byte, maxFrameSize)
+	req := []byte{}
+	for {
+		n, err := port.Read(buf)
+		if err != nil {
+			return
+		}
+		req = append(req, buf[:n]...)
+		l := len(req)
+		if l < 8 || (req[1] == FuncWriteMultipleCoils || req[1] == FuncWriteMultipleRegisters) && l < 9+int(req[6]) {
+			continue
+		}
+		if res := handle(req); res != nil {
+			port.Write(res)
+		}
+		req = []byte{}
+	}
+}
+
+func newTestClient(t *testing.T, handle func(req []byte) []byte) *Client {
+	mode := &serial.Mode{BaudRate: 19200, Parity: serial.EvenParity}
+	slave, master, err := ptytest.Pair(mode)
+	require.NoError(t, err)
+	go simulateSlave(master, handle)
+	client := NewClient(slave, mode)
+	client.Timeout = 200 * time.Millisecond
+	return client
+}
+
+func TestClientReadHoldingRegisters(t *testing.T) {
+	client := newTestClient(t, func(req []byte) []byte {
+		require.Equal(t, []byte{0x11, 0x03, 0x00, 0x6B, 0x00, 0x03, 0x76, 0x87}, req)
+		return appendCRC([]byte{0x11, 0x03, 0x06, 0x02, 0x2B, 0x00, 0x00, 0x00, 0x64})
+	})
+	defer client.Close()
+
+	regs, err := client.ReadHoldingRegisters(context.Background(), 0x11, 0x006B, 3)
+	require.NoError(t, err)
+	require.Equal(t, []uint16{0x022B, 0x0000, 0x0064}, regs)
+}
+
+func TestClientReadCoils(t *testing.T) {
+	client := newTestClient(t, func(req []byte) []byte {
+		return appendCRC([]byte{0x11, 0x01, 0x02, 0xCD, 0x01})
+	})
+	defer client.Close()
+
+	coils, err := client.ReadCoils(context.Background(), 0x11, 0x0013, 10)
+	require.NoError(t, err)
+	require.Equal(t, []bool{true, false, true, true, false, false, true, true, true, false}, coils)
+}
+
+func TestClientWrites(t *testing.T) {
+	client := newTestClient(t, func(req []byte) []byte {
+		switch req[1] {
+		case FuncWriteMultipleCoils, FuncWriteMultipleRegisters:
+			return appendCRC(append([]byte{}, req[:6]...))
+		default:
+			return req
+		}
+	})
+	defer client.Close()
+
+	ctx := context.Background()
+	require.NoError(t, client.WriteSingleCoil(ctx, 1, 0x00AC, true))
+	require.NoError(t, client.WriteSingleRegister(ctx, 1, 0x0001, 0x0003))
+	require.NoError(t, client.WriteMultipleCoils(ctx, 1, 0x0013, []bool{true, false, true}))
+	require.NoError(t, client.WriteMultipleRegisters(ctx, 1, 0x0001, []uint16{0x000A, 0x0102}))
+}
+
+func TestClientException(t *testing.T) {
+	client := newTestClient(t, func(req []byte) []byte {
+		return appendCRC([]byte{req[0], req[1] | 0x80, byte(IllegalDataAddress)})
+	})
+	defer client.Close()
+
+	_, err := client.ReadInputRegisters(context.Background(), 5, 0x1000, 1)
+	require.Equal(t, &Exception{Function: FuncReadInputRegisters, Code: IllegalDataAddress}, err)
+}
+
+func TestClientRetries(t *testing.T) {
+	var attempts int32
+	client := newTestClient(t, func(req []byte) []byte {
+		n := atomic.AddInt32(&attempts, 1)
+		res := appendCRC([]byte{0x01, 0x04, 0x02, 0x12, 0x34})
+		if n == 1 {
+			res[3] ^= 0xFF
+		}
+		if n == 2 {
+			return nil
+		}
+		return res
+	})
+	defer client.Close()
+
+	_, err := client.ReadInputRegisters(context.Background(), 1, 0, 1)
+	require.Equal(t, ErrCRC, err)
+
+	client.Retries = 2
+	regs, err := client.ReadInputRegisters(context.Background(), 1, 0, 1)
+	require.NoError(t, err)
+	require.Equal(t, []uint16{0x1234}, regs)
+	require.Equal(t, int32(3), atomic.LoadInt32(&attempts))
+}
+
+func TestClientTimeout(t *testing.T) {
+	client := newTestClient(t, func(req []byte) []byte {
+		return nil
+	})
+	defer client.Close()
+
+	start := time.Now()
+	_, err := client.ReadHoldingRegisters(context.Background(), 1, 0, 1)
+	require.Equal(t, ErrTimeout, err)
+	require.True(t, time.Since(start) >= 200*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	_, err = client.ReadHoldingRegisters(ctx, 1, 0, 1)
+	require.Equal(t, context.DeadlineExceeded, err)
+	require.True(t, time.Since(start) < 200*time.Millisecond)
+}
+
+func TestClientBroadcast(t *testing.T) {
+	received := make(chan []byte, 1)
+	client := newTestClient(t, func(req []byte) []byte {
+		received <- req
+		return nil
+	})
+	defer client.Close()
+	client.TurnaroundDelay = 10 * time.Millisecond
+
+	require.NoError(t, client.WriteSingleRegister(context.Background(), BroadcastAddress, 1, 2))
+	require.Equal(t, appendCRC([]byte{0x00, 0x06, 0x00, 0x01, 0x00, 0x02}), <-received)
+
+	_, err := client.ReadCoils(context.Background(), BroadcastAddress, 0, 1)
+	require.Error(t, err)
+}
+
+func TestClientDoubleClose(t *testing.T) {
+	client := newTestClient(t, func(req []byte) []byte { return nil })
+	require.NoError(t, client.Close())
+	require.NotPanics(t, func() { client.Close() })
+}
@@ -0,0 +1,49 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package modbus
+
+var crcTable [256]uint16
+
+func init() {
+	for i := range crcTable {
+		crc := uint16(i)
+		for j := 0; j < 8; j++ {
+			if crc&1 != 0 {
+				crc = (crc >> 1) ^ 0xA001
+			} else {
+				crc >>= 1
+			}
+		}
+		crcTable[i] = crc
+	}
+}
+
+// CRC16 computes the Modbus CRC-16 of data. The result must be appended to
+// the frame low byte first.
+func CRC16(data []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, b := range data {
+		crc = (crc >> 8) ^ crcTable[byte(crc)^b]
+	}
+	return crc
+}
+
+// appendCRC appends the CRC-16 of frame to frame itself.
+func appendCRC(frame []byte) []byte {
+	crc := CRC16(frame)
+	return append(frame, byte(crc), byte(crc>>8))
+}
+
+// checkCRC verifies the CRC-16 trailer of a complete RTU frame.
+func checkCRC(frame []byte) bool {
+	if len(frame) < 4 {
+		return false
+	}
+	n := len(frame) - 2
+	crc := CRC16(frame[:n])
+	return frame[n] == byte(crc) && frame[n+1] == byte(crc>>8)
+}
@@ -0,0 +1,76 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package modbus
+
+// Sub-function codes of the diagnostics function (function code 8)
+const (
+	DiagReturnQueryData                  uint16 = 0x00
+	DiagRestartCommunicationsOption      uint16 = 0x01
+	DiagReturnDiagnosticRegister         uint16 = 0x02
+	DiagForceListenOnlyMode              uint16 = 0x04
+	DiagClearCountersAndDiagnosticReg    uint16 = 0x0A
+	DiagReturnBusMessageCount            uint16 = 0x0B
+	DiagReturnBusCommunicationErrorCount uint16 = 0x0C
+	DiagReturnBusExceptionErrorCount     uint16 = 0x0D
+	DiagReturnServerMessageCount         uint16 = 0x0E
+	DiagReturnServerNoResponseCount      uint16 = 0x0F
+	DiagReturnServerNAKCount             uint16 = 0x10
+	DiagReturnServerBusyCount            uint16 = 0x11
+	DiagReturnBusCharacterOverrunCount   uint16 = 0x12
+	DiagClearOverrunCounterAndFlag       uint16 = 0x14
+)
+
+// Counters are the diagnostic counters kept by a Server, as defined by the
+// Modbus specification. They can be read by a client with the diagnostics
+// function (function code 8).
+type Counters struct {
+	// BusMessages is the number of messages detected on the bus, including
+	// those with a CRC error or too long
+	BusMessages uint16
+	// BusCommunicationErrors is the number of CRC errors
+	BusCommunicationErrors uint16
+	// BusExceptionErrors is the number of exception responses sent
+	BusExceptionErrors uint16
+	// ServerMessages is the number of messages addressed to the server,
+	// including broadcast messages
+	ServerMessages uint16
+	// ServerNoResponses is the number of messages addressed to the server
+	// that were not answered
+	ServerNoResponses uint16
+	// ServerNAKs is the number of NegativeAcknowledge exceptions sent
+	ServerNAKs uint16
+	// ServerBusy is the number of ServerDeviceBusy exceptions sent
+	ServerBusy uint16
+	// BusCharacterOverruns is the number of messages discarded because
+	// longer than the maximum frame size
+	BusCharacterOverruns uint16
+}
+
+// counter returns the value of the counter read by the given diagnostics
+// sub-function.
+func (c *Counters) counter(subFunction uint16) (uint16, bool) {
+	switch subFunction {
+	case DiagReturnBusMessageCount:
+		return c.BusMessages, true
+	case DiagReturnBusCommunicationErrorCount:
+		return c.BusCommunicationErrors, true
+	case DiagReturnBusExceptionErrorCount:
+		return c.BusExceptionErrors, true
+	case DiagReturnServerMessageCount:
+		return c.ServerMessages, true
+	case DiagReturnServerNoResponseCount:
+		return c.ServerNoResponses, true
+	case DiagReturnServerNAKCount:
+		return c.ServerNAKs, true
+	case DiagReturnServerBusyCount:
+		return c.ServerBusy, true
+	case DiagReturnBusCharacterOverrunCount:
+		return c.BusCharacterOverruns, true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,40 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+/*
+Package modbus implements the Modbus RTU protocol on top of a serial.Port.
+
+A Client (the Modbus "master") sends requests to the devices attached to
+the bus and waits for their answers:
+
+	port, err := serial.Open("/dev/ttyUSB0", mode)
+	if err != nil {
+		log.Fatal(err)
+	}
+	client := modbus.NewClient(port, mode)
+	defer client.Close()
+
+	regs, err := client.ReadHoldingRegisters(context.Background(), 17, 0x006B, 3)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(regs)
+
+The inter-frame silence required by the RTU framing is computed from the
+Mode of the port, see FrameDelay for details.
+
+A Server (the Modbus "slave") answers the requests received on the bus
+using a Handler. Memory is a ready to use Handler that keeps the data of
+a simulated device in memory:
+
+	server := modbus.NewServer(port, mode, modbus.NewMemory(1000), 17)
+	log.Fatal(server.Serve())
+
+An error reported by a device is returned as an *Exception, while
+transmission problems are reported with ErrTimeout, ErrCRC or
+ErrInvalidResponse.
+*/
+package modbus
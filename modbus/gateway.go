@@ -0,0 +1,175 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package modbus
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+)
+
+// mbapHeaderSize is the size of the Modbus TCP (MBAP) header: transaction
+// identifier, protocol identifier, length and unit identifier.
+const mbapHeaderSize = 7
+
+// Gateway accepts Modbus TCP connections and forwards the requests received
+// to the RTU devices reached by a Client.
+//
+// The requests of all the TCP clients are serialized on the serial bus and
+// each response is sent back with the transaction identifier of its
+// request. The per-unit timeouts and the retries configured on the Client
+// are used for the RTU transactions: when a device doesn't answer, a
+// GatewayTargetDeviceFailedToRespond exception is returned to the TCP
+// client. Requests addressed to the BroadcastAddress are forwarded but not
+// answered.
+type Gateway struct {
+	client *Client
+
+	mu        sync.Mutex
+	listeners map[net.Listener]bool
+	conns     map[net.Conn]bool
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// NewGateway creates a new Gateway that forwards requests to client.
+func NewGateway(client *Client) *Gateway {
+	return &Gateway{
+		client:    client,
+		listeners: map[net.Listener]bool{},
+		conns:     map[net.Conn]bool{},
+	}
+}
+
+// ListenAndServe listens on the TCP network address addr and serves the
+// incoming connections.
+func (g *Gateway) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return g.Serve(l)
+}
+
+// Serve accepts connections on the listener l and serves each of them in
+// a new goroutine. Serve always closes l and returns ErrClosed after
+// Close has been called.
+func (g *Gateway) Serve(l net.Listener) error {
+	g.mu.Lock()
+	if g.closed {
+		g.mu.Unlock()
+		l.Close()
+		return ErrClosed
+	}
+	g.listeners[l] = true
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.listeners, l)
+		g.mu.Unlock()
+		l.Close()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			g.mu.Lock()
+			closed := g.closed
+			g.mu.Unlock()
+			if closed {
+				return ErrClosed
+			}
+			return err
+		}
+
+		g.mu.Lock()
+		if g.closed {
+			g.mu.Unlock()
+			conn.Close()
+			return ErrClosed
+		}
+		g.conns[conn] = true
+		g.wg.Add(1)
+		g.mu.Unlock()
+
+		go func() {
+			defer g.wg.Done()
+			g.serveConn(conn)
+			g.mu.Lock()
+			delete(g.conns, conn)
+			g.mu.Unlock()
+			conn.Close()
+		}()
+	}
+}
+
+// Close stops all the listeners and closes all the active connections.
+// The Client is not closed.
+func (g *Gateway) Close() error {
+	g.mu.Lock()
+	g.closed = true
+	for l := range g.listeners {
+		l.Close()
+	}
+	for conn := range g.conns {
+		conn.Close()
+	}
+	g.mu.Unlock()
+	g.wg.Wait()
+	return nil
+}
+
+// serveConn processes the requests received on a connection until it's
+// closed or an invalid frame is received.
+func (g *Gateway) serveConn(conn net.Conn) {
+	header := make([]byte, mbapHeaderSize)
+	for {
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		protocol := binary.BigEndian.Uint16(header[2:])
+		length := int(binary.BigEndian.Uint16(header[4:]))
+		if protocol != 0 || length < 2 || length > maxFrameSize-2 {
+			return
+		}
+		pdu := make([]byte, length-1)
+		if _, err := io.ReadFull(conn, pdu); err != nil {
+			return
+		}
+
+		unit := header[6]
+		res := g.forward(unit, pdu)
+		if res == nil {
+			continue
+		}
+		binary.BigEndian.PutUint16(header[4:], uint16(len(res)+1))
+		if _, err := conn.Write(append(header, res...)); err != nil {
+			return
+		}
+	}
+}
+
+// forward sends a request PDU on the serial bus and returns the response
+// PDU to send back to the TCP client, or nil if there is no response.
+func (g *Gateway) forward(unit byte, pdu []byte) []byte {
+	res, err := g.client.Send(context.Background(), unit, pdu)
+	switch err := err.(type) {
+	case nil:
+		return res
+	case *Exception:
+		return []byte{pdu[0] | exceptionFlag, byte(err.Code)}
+	}
+	switch err {
+	case ErrTimeout, ErrCRC, ErrInvalidResponse:
+		return []byte{pdu[0] | exceptionFlag, byte(GatewayTargetDeviceFailedToRespond)}
+	default:
+		return []byte{pdu[0] | exceptionFlag, byte(GatewayPathUnavailable)}
+	}
+}
@@ -0,0 +1,91 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package modbus
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func mbapRequest(t *testing.T, conn net.Conn, transaction uint16, unit byte, pdu []byte) []byte {
+	req := make([]byte, mbapHeaderSize, mbapHeaderSize+len(pdu))
+	binary.BigEndian.PutUint16(req[0:], transaction)
+	binary.BigEndian.PutUint16(req[4:], uint16(len(pdu)+1))
+	req[6] = unit
+	_, err := conn.Write(append(req, pdu...))
+	require.NoError(t, err)
+
+	header := make([]byte, mbapHeaderSize)
+	_, err = io.ReadFull(conn, header)
+	require.NoError(t, err)
+	require.Equal(t, transaction, binary.BigEndian.Uint16(header[0:]))
+	require.Equal(t, uint16(0), binary.BigEndian.Uint16(header[2:]))
+	require.Equal(t, unit, header[6])
+	res := make([]byte, binary.BigEndian.Uint16(header[4:])-1)
+	_, err = io.ReadFull(conn, res)
+	require.NoError(t, err)
+	return res
+}
+
+func TestGateway(t *testing.T) {
+	memory := NewMemory(10)
+	memory.HoldingRegisters[2] = 0x1234
+	server, client := newTestServer(t, memory, 1)
+	defer server.Close()
+	defer client.Close()
+	client.SetUnitTimeout(9, 50*time.Millisecond)
+
+	gateway := NewGateway(client)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	done := make(chan error)
+	go func() {
+		done <- gateway.Serve(l)
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			conn, err := net.Dial("tcp", l.Addr().String())
+			require.NoError(t, err)
+			defer conn.Close()
+			for j := 0; j < 5; j++ {
+				transaction := uint16(i*100 + j)
+				res := mbapRequest(t, conn, transaction, 1, []byte{FuncReadHoldingRegisters, 0, 2, 0, 1})
+				require.Equal(t, []byte{FuncReadHoldingRegisters, 2, 0x12, 0x34}, res)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// Exceptions are forwarded
+	res := mbapRequest(t, conn, 1, 1, []byte{FuncReadHoldingRegisters, 0, 20, 0, 1})
+	require.Equal(t, []byte{FuncReadHoldingRegisters | 0x80, byte(IllegalDataAddress)}, res)
+
+	// Missing devices are reported with a gateway exception
+	start := time.Now()
+	res = mbapRequest(t, conn, 2, 9, []byte{FuncReadHoldingRegisters, 0, 0, 0, 1})
+	require.Equal(t, []byte{FuncReadHoldingRegisters | 0x80, byte(GatewayTargetDeviceFailedToRespond)}, res)
+	require.True(t, time.Since(start) < 200*time.Millisecond)
+
+	require.NoError(t, gateway.Close())
+	require.Equal(t, ErrClosed, <-done)
+	_, err = conn.Read(make([]byte, 1))
+	require.Error(t, err)
+}
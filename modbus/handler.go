@@ -0,0 +1,112 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package modbus
+
+import "sync"
+
+// Handler is the interface implemented by the devices served by a Server.
+// Each method receives the unit address the request was sent to, or
+// BroadcastAddress for broadcast write requests.
+//
+// A method can return an ExceptionCode to report a specific exception
+// to the client, any other error is reported as ServerDeviceFailure.
+// The quantity of items requested is already validated by the Server.
+type Handler interface {
+	ReadCoils(unit byte, address uint16, quantity int) ([]bool, error)
+	ReadDiscreteInputs(unit byte, address uint16, quantity int) ([]bool, error)
+	ReadHoldingRegisters(unit byte, address uint16, quantity int) ([]uint16, error)
+	ReadInputRegisters(unit byte, address uint16, quantity int) ([]uint16, error)
+	WriteCoils(unit byte, address uint16, values []bool) error
+	WriteHoldingRegisters(unit byte, address uint16, values []uint16) error
+}
+
+// Memory is a Handler that keeps the device data in memory. The same data
+// is served for every unit address. Requests that fall outside the slices
+// are answered with an IllegalDataAddress exception.
+//
+// The fields can be accessed directly only while holding the lock.
+type Memory struct {
+	sync.Mutex
+	Coils            []bool
+	DiscreteInputs   []bool
+	HoldingRegisters []uint16
+	InputRegisters   []uint16
+}
+
+// NewMemory creates a Memory with size items for each data table.
+func NewMemory(size int) *Memory {
+	return &Memory{
+		Coils:            make([]bool, size),
+		DiscreteInputs:   make([]bool, size),
+		HoldingRegisters: make([]uint16, size),
+		InputRegisters:   make([]uint16, size),
+	}
+}
+
+func inRange(address uint16, quantity int, size int) bool {
+	return int(address)+quantity <= size
+}
+
+func (m *Memory) readBits(table []bool, address uint16, quantity int) ([]bool, error) {
+	m.Lock()
+	defer m.Unlock()
+	if !inRange(address, quantity, len(table)) {
+		return nil, IllegalDataAddress
+	}
+	return append([]bool{}, table[address:int(address)+quantity]...), nil
+}
+
+func (m *Memory) readRegisters(table []uint16, address uint16, quantity int) ([]uint16, error) {
+	m.Lock()
+	defer m.Unlock()
+	if !inRange(address, quantity, len(table)) {
+		return nil, IllegalDataAddress
+	}
+	return append([]uint16{}, table[address:int(address)+quantity]...), nil
+}
+
+// ReadCoils implements the Handler interface.
+func (m *Memory) ReadCoils(unit byte, address uint16, quantity int) ([]bool, error) {
+	return m.readBits(m.Coils, address, quantity)
+}
+
+// ReadDiscreteInputs implements the Handler interface.
+func (m *Memory) ReadDiscreteInputs(unit byte, address uint16, quantity int) ([]bool, error) {
+	return m.readBits(m.DiscreteInputs, address, quantity)
+}
+
+// ReadHoldingRegisters implements the Handler interface.
+func (m *Memory) ReadHoldingRegisters(unit byte, address uint16, quantity int) ([]uint16, error) {
+	return m.readRegisters(m.HoldingRegisters, address, quantity)
+}
+
+// ReadInputRegisters implements the Handler interface.
+func (m *Memory) ReadInputRegisters(unit byte, address uint16, quantity int) ([]uint16, error) {
+	return m.readRegisters(m.InputRegisters, address, quantity)
+}
+
+// WriteCoils implements the Handler interface.
+func (m *Memory) WriteCoils(unit byte, address uint16, values []bool) error {
+	m.Lock()
+	defer m.Unlock()
+	if !inRange(address, len(values), len(m.Coils)) {
+		return IllegalDataAddress
+	}
+	copy(m.Coils[address:], values)
+	return nil
+}
+
+// WriteHoldingRegisters implements the Handler interface.
+func (m *Memory) WriteHoldingRegisters(unit byte, address uint16, values []uint16) error {
+	m.Lock()
+	defer m.Unlock()
+	if !inRange(address, len(values), len(m.HoldingRegisters)) {
+		return IllegalDataAddress
+	}
+	copy(m.HoldingRegisters[address:], values)
+	return nil
+}
@@ -0,0 +1,195 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package modbus
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Function codes supported by this package
+const (
+	FuncReadCoils                  byte = 0x01
+	FuncReadDiscreteInputs         byte = 0x02
+	FuncReadHoldingRegisters       byte = 0x03
+	FuncReadInputRegisters         byte = 0x04
+	FuncWriteSingleCoil            byte = 0x05
+	FuncWriteSingleRegister        byte = 0x06
+	FuncDiagnostics                byte = 0x08
+	FuncWriteMultipleCoils         byte = 0x0F
+	FuncWriteMultipleRegisters     byte = 0x10
+	FuncReadWriteMultipleRegisters byte = 0x17
+
+	// exceptionFlag is set in the function code of an exception response
+	exceptionFlag byte = 0x80
+)
+
+// BroadcastAddress is the unit address that all the devices on the bus
+// accept. Broadcast requests are never answered.
+const BroadcastAddress byte = 0
+
+// Limits of the quantity of items that can be transferred with a single
+// request.
+const (
+	MaxReadBits           = 2000
+	MaxReadRegisters      = 125
+	MaxWriteBits          = 1968
+	MaxWriteRegisters     = 123
+	MaxReadWriteRegisters = 121
+)
+
+// maxFrameSize is the maximum size of an RTU frame (address, PDU and CRC)
+const maxFrameSize = 256
+
+var (
+	// ErrTimeout is returned when a device doesn't answer in time
+	ErrTimeout = errors.New("modbus: timeout waiting for response")
+	// ErrCRC is returned when a frame is received with a wrong CRC
+	ErrCRC = errors.New("modbus: CRC mismatch")
+	// ErrInvalidResponse is returned when a response doesn't match the request
+	ErrInvalidResponse = errors.New("modbus: invalid response")
+	// ErrClosed is returned when the client or server has been closed
+	ErrClosed = errors.New("modbus: closed")
+)
+
+// ExceptionCode is the code returned by a device to report a failed request
+type ExceptionCode byte
+
+const (
+	// IllegalFunction the function code is not supported by the device
+	IllegalFunction ExceptionCode = 0x01
+	// IllegalDataAddress the requested address range is not valid
+	IllegalDataAddress ExceptionCode = 0x02
+	// IllegalDataValue a value in the request is not valid
+	IllegalDataValue ExceptionCode = 0x03
+	// ServerDeviceFailure an unrecoverable error occurred while processing the request
+	ServerDeviceFailure ExceptionCode = 0x04
+	// Acknowledge the request has been accepted but will take long to complete
+	Acknowledge ExceptionCode = 0x05
+	// ServerDeviceBusy the device is busy processing a long-duration command
+	ServerDeviceBusy ExceptionCode = 0x06
+	// NegativeAcknowledge the device can not perform the program function
+	NegativeAcknowledge ExceptionCode = 0x07
+	// MemoryParityError the device detected a parity error in its memory
+	MemoryParityError ExceptionCode = 0x08
+	// GatewayPathUnavailable the gateway can not route the request
+	GatewayPathUnavailable ExceptionCode = 0x0A
+	// GatewayTargetDeviceFailedToRespond the gateway target device didn't answer
+	GatewayTargetDeviceFailedToRespond ExceptionCode = 0x0B
+)
+
+// String returns a description of the exception code
+func (c ExceptionCode) String() string {
+	switch c {
+	case IllegalFunction:
+		return "illegal function"
+	case IllegalDataAddress:
+		return "illegal data address"
+	case IllegalDataValue:
+		return "illegal data value"
+	case ServerDeviceFailure:
+		return "server device failure"
+	case Acknowledge:
+		return "acknowledge"
+	case ServerDeviceBusy:
+		return "server device busy"
+	case NegativeAcknowledge:
+		return "negative acknowledge"
+	case MemoryParityError:
+		return "memory parity error"
+	case GatewayPathUnavailable:
+		return "gateway path unavailable"
+	case GatewayTargetDeviceFailedToRespond:
+		return "gateway target device failed to respond"
+	default:
+		return fmt.Sprintf("exception 0x%02X", byte(c))
+	}
+}
+
+// Error returns the complete error message. ExceptionCode implements the
+// error interface so that a Handler can return it to report a failure.
+func (c ExceptionCode) Error() string {
+	return "modbus: " + c.String()
+}
+
+// Exception is the error returned when a device answers with an exception
+// response.
+type Exception struct {
+	Function byte
+	Code     ExceptionCode
+}
+
+// Error returns the complete error message
+func (e *Exception) Error() string {
+	return fmt.Sprintf("modbus: function 0x%02X: %s", e.Function, e.Code)
+}
+
+// responseLength returns the expected length of the RTU response frame to
+// a request with the given function code, by looking at the first bytes
+// received. It returns 0 if more bytes are needed to know the length and
+// -1 if the length can't be determined from the content of the frame.
+func responseLength(function byte, frame []byte) int {
+	if len(frame) < 2 {
+		return 0
+	}
+	if frame[1]&exceptionFlag != 0 {
+		return 5
+	}
+	switch function {
+	case FuncReadCoils, FuncReadDiscreteInputs,
+		FuncReadHoldingRegisters, FuncReadInputRegisters,
+		FuncReadWriteMultipleRegisters:
+		if len(frame) < 3 {
+			return 0
+		}
+		return 3 + int(frame[2]) + 2
+	case FuncWriteSingleCoil, FuncWriteSingleRegister, FuncDiagnostics,
+		FuncWriteMultipleCoils, FuncWriteMultipleRegisters:
+		return 8
+	default:
+		return -1
+	}
+}
+
+// packBits packs a slice of bool into bytes, least significant bit first.
+func packBits(values []bool) []byte {
+	res := make([]byte, (len(values)+7)/8)
+	for i, v := range values {
+		if v {
+			res[i/8] |= 1 << uint(i%8)
+		}
+	}
+	return res
+}
+
+// unpackBits extracts count bits from data, least significant bit first.
+func unpackBits(data []byte, count int) []bool {
+	res := make([]bool, count)
+	for i := range res {
+		res[i] = data[i/8]&(1<<uint(i%8)) != 0
+	}
+	return res
+}
+
+// packRegisters encodes registers in big-endian format.
+func packRegisters(values []uint16) []byte {
+	res := make([]byte, len(values)*2)
+	for i, v := range values {
+		binary.BigEndian.PutUint16(res[i*2:], v)
+	}
+	return res
+}
+
+// unpackRegisters decodes big-endian registers.
+func unpackRegisters(data []byte) []uint16 {
+	res := make([]uint16, len(data)/2)
+	for i := range res {
+		res[i] = binary.BigEndian.Uint16(data[i*2:])
+	}
+	return res
+}
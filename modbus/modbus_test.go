@@ -0,0 +1,35 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package modbus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.bug.st/serial"
+)
+
+func TestCRC16(t *testing.T) {
+	// Example from the Modbus over serial line specification
+	require.Equal(t, uint16(0x1241), CRC16([]byte{0x02, 0x07}))
+	frame := appendCRC([]byte{0x11, 0x03, 0x00, 0x6B, 0x00, 0x03})
+	require.Equal(t, []byte{0x11, 0x03, 0x00, 0x6B, 0x00, 0x03, 0x76, 0x87}, frame)
+	require.True(t, checkCRC(frame))
+	frame[2] = 0x01
+	require.False(t, checkCRC(frame))
+}
+
+func TestFrameDelay(t *testing.T) {
+	// 11 bits per character at 9600 baud
+	mode := &serial.Mode{BaudRate: 9600, Parity: serial.EvenParity}
+	require.Equal(t, 11*time.Second/9600, CharTime(mode))
+	require.Equal(t, CharTime(mode)*7/2, FrameDelay(mode))
+	mode = &serial.Mode{BaudRate: 9600, StopBits: serial.OnePointFiveStopBits}
+	require.Equal(t, 21*time.Second/2/9600, CharTime(mode))
+	require.Equal(t, 1750*time.Microsecond, FrameDelay(&serial.Mode{BaudRate: 115200}))
+}
@@ -0,0 +1,112 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package modbus
+
+import (
+	"sync"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// CharTime returns the time needed to transmit a single character with
+// the given serial port configuration (start bit, data bits, parity and
+// stop bits).
+func CharTime(mode *serial.Mode) time.Duration {
+	baud := mode.BaudRate
+	if baud == 0 {
+		baud = 9600
+	}
+	// Count bits in half-bit units to handle 1.5 stop bits
+	halfBits := 2 // start bit
+	if mode.DataBits == 0 {
+		halfBits += 2 * 8
+	} else {
+		halfBits += 2 * mode.DataBits
+	}
+	if mode.Parity != serial.NoParity {
+		halfBits += 2
+	}
+	switch mode.StopBits {
+	case serial.OnePointFiveStopBits:
+		halfBits += 3
+	case serial.TwoStopBits:
+		halfBits += 4
+	default:
+		halfBits += 2
+	}
+	return time.Duration(halfBits) * time.Second / time.Duration(2*baud)
+}
+
+// FrameDelay returns the minimum silence between two RTU frames (3.5
+// character times) for the given serial port configuration. As recommended
+// by the Modbus over serial line specification, a fixed value of 1.75ms is
+// used for baud rates greater than 19200.
+func FrameDelay(mode *serial.Mode) time.Duration {
+	if mode.BaudRate > 19200 {
+		return 1750 * time.Microsecond
+	}
+	return CharTime(mode) * 7 / 2
+}
+
+// chunk is a block of data received from the serial port
+type chunk struct {
+	data []byte
+	at   time.Time
+	err  error
+}
+
+// receiver reads continuously from a serial port and delivers the data
+// received, timestamped, on a channel. This allows to wait for data with
+// a timeout and to measure the silence on the bus.
+type receiver struct {
+	port   serial.Port
+	chunks chan chunk
+	done   chan struct{}
+	once   sync.Once
+}
+
+func newReceiver(port serial.Port) *receiver {
+	r := &receiver{
+		port:   port,
+		chunks: make(chan chunk, 64),
+		done:   make(chan struct{}),
+	}
+	go r.run()
+	return r
+}
+
+func (r *receiver) run() {
+	defer close(r.chunks)
+	for {
+		buf := make([]byte, maxFrameSize)
+		n, err := r.port.Read(buf)
+		if n > 0 && !r.deliver(chunk{data: buf[:n], at: time.Now()}) {
+			return
+		}
+		if err != nil {
+			r.deliver(chunk{at: time.Now(), err: err})
+			return
+		}
+	}
+}
+
+func (r *receiver) deliver(c chunk) bool {
+	select {
+	case r.chunks <- c:
+		return true
+	case <-r.done:
+		return false
+	}
+}
+
+// stop makes the receiver drop any further data, the receiving goroutine
+// terminates as soon as the pending Read on the port returns. It can be
+// called more than once.
+func (r *receiver) stop() {
+	r.once.Do(func() { close(r.done) })
+}
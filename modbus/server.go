@@ -0,0 +1,399 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package modbus
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// Server is a Modbus RTU server (slave): it receives the requests sent on
+// the bus and answers them using a Handler.
+//
+// The frames are delimited by the silence on the line: a frame ends when no
+// character is received for the inter-frame delay computed from the Mode of
+// the port (see FrameDelay), or MinFrameGap if longer.
+type Server struct {
+	// MinFrameGap is the minimum silence that ends a frame. The scheduling
+	// latency of the OS and of USB adapters can split the frames on the
+	// short gaps used above 19200 baud: a gap of a few milliseconds avoids
+	// it. If zero, only the inter-frame delay of the mode is used.
+	MinFrameGap time.Duration
+
+	port    serial.Port
+	mode    serial.Mode
+	handler Handler
+	units   map[byte]bool
+
+	mu            sync.Mutex
+	counters      Counters
+	clearCounters bool
+	listenOnly    bool
+	recv          *receiver
+	closed        bool
+}
+
+// NewServer creates a new Server that serves handler on the given port.
+// The mode must be the one currently set on the port. The server answers
+// only to the requests sent to one of the given unit addresses, or to any
+// unit address if none is specified. Broadcast write requests are always
+// executed.
+func NewServer(port serial.Port, mode *serial.Mode, handler Handler, units ...byte) *Server {
+	s := &Server{
+		port:    port,
+		mode:    *mode,
+		handler: handler,
+	}
+	if len(units) > 0 {
+		s.units = map[byte]bool{}
+		for _, unit := range units {
+			s.units[unit] = true
+		}
+	}
+	return s
+}
+
+// Counters returns a snapshot of the diagnostic counters of the server.
+func (s *Server) Counters() Counters {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.counters
+}
+
+// Serve receives and answers requests until the server is closed or an
+// error occurs on the serial port. After Close, ErrClosed is returned.
+func (s *Server) Serve() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrClosed
+	}
+	recv := newReceiver(s.port)
+	s.recv = recv
+	s.mu.Unlock()
+
+	gap := FrameDelay(&s.mode)
+	if gap < s.MinFrameGap {
+		gap = s.MinFrameGap
+	}
+	timer := time.NewTimer(gap)
+	timer.Stop()
+	defer timer.Stop()
+
+	var frame []byte
+	overrun := false
+	for {
+		select {
+		case ch, ok := <-recv.chunks:
+			if !ok || ch.err != nil {
+				s.mu.Lock()
+				closed := s.closed
+				s.mu.Unlock()
+				if !ok || closed {
+					return ErrClosed
+				}
+				return ch.err
+			}
+			frame = append(frame, ch.data...)
+			if len(frame) > maxFrameSize {
+				// Discard everything until the end of the frame
+				overrun = true
+				frame = frame[:0]
+			}
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(gap)
+		case <-timer.C:
+			if overrun {
+				s.mu.Lock()
+				s.counters.BusMessages++
+				s.counters.BusCharacterOverruns++
+				s.mu.Unlock()
+			} else if res := s.handleFrame(frame); res != nil {
+				if _, err := s.port.Write(res); err != nil {
+					return err
+				}
+			}
+			frame = nil
+			overrun = false
+		}
+	}
+}
+
+// Close stops the server and closes the underlying serial port.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	if s.recv != nil {
+		s.recv.stop()
+	}
+	s.mu.Unlock()
+	return s.port.Close()
+}
+
+// handleFrame processes a request frame and returns the response frame to
+// send, or nil if the request must not be answered.
+func (s *Server) handleFrame(frame []byte) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer func() {
+		// Counters are cleared after the request that asks for it has
+		// been accounted
+		if s.clearCounters {
+			s.counters = Counters{}
+			s.clearCounters = false
+		}
+	}()
+
+	s.counters.BusMessages++
+	if len(frame) < 4 || !checkCRC(frame) {
+		s.counters.BusCommunicationErrors++
+		return nil
+	}
+
+	unit := frame[0]
+	broadcast := unit == BroadcastAddress
+	if !broadcast && s.units != nil && !s.units[unit] {
+		return nil
+	}
+	s.counters.ServerMessages++
+
+	// A server in listen only mode doesn't answer, not even to the
+	// request that restarts the communications
+	listenOnly := s.listenOnly
+	req := frame[1 : len(frame)-2]
+	if broadcast && !broadcastable(req) {
+		s.counters.ServerNoResponses++
+		return nil
+	}
+	var res []byte
+	if req[0] == FuncDiagnostics {
+		res = s.diagnostics(req)
+	} else if !listenOnly {
+		res = s.execute(unit, req)
+	}
+	if res == nil || broadcast || listenOnly || s.listenOnly {
+		s.counters.ServerNoResponses++
+		return nil
+	}
+	if res[0]&exceptionFlag != 0 {
+		s.counters.BusExceptionErrors++
+		switch ExceptionCode(res[1]) {
+		case NegativeAcknowledge:
+			s.counters.ServerNAKs++
+		case ServerDeviceBusy:
+			s.counters.ServerBusy++
+		}
+	}
+	return appendCRC(append([]byte{unit}, res...))
+}
+
+// broadcastable returns true if the request can be broadcast: only the
+// writes, and the diagnostics that change the state of the server, are
+// executed since their result is never sent back.
+func broadcastable(req []byte) bool {
+	switch req[0] {
+	case FuncWriteSingleCoil, FuncWriteSingleRegister, FuncWriteMultipleCoils, FuncWriteMultipleRegisters:
+		return true
+	case FuncDiagnostics:
+		if len(req) < 3 {
+			return false
+		}
+		switch binary.BigEndian.Uint16(req[1:]) {
+		case DiagRestartCommunicationsOption, DiagForceListenOnlyMode,
+			DiagClearCountersAndDiagnosticReg, DiagClearOverrunCounterAndFlag:
+			return true
+		}
+	}
+	return false
+}
+
+func exceptionResponse(function byte, err error) []byte {
+	code, ok := err.(ExceptionCode)
+	if !ok {
+		code = ServerDeviceFailure
+	}
+	return []byte{function | exceptionFlag, byte(code)}
+}
+
+// execute runs a request PDU on the handler and returns the response PDU.
+func (s *Server) execute(unit byte, req []byte) []byte {
+	function := req[0]
+	data := req[1:]
+	switch function {
+	case FuncReadCoils, FuncReadDiscreteInputs:
+		if len(data) != 4 {
+			return exceptionResponse(function, IllegalDataValue)
+		}
+		address := binary.BigEndian.Uint16(data[0:])
+		quantity := int(binary.BigEndian.Uint16(data[2:]))
+		if err := checkQuantity(quantity, MaxReadBits); err != nil {
+			return exceptionResponse(function, IllegalDataValue)
+		}
+		read := s.handler.ReadCoils
+		if function == FuncReadDiscreteInputs {
+			read = s.handler.ReadDiscreteInputs
+		}
+		values, err := read(unit, address, quantity)
+		if err == nil && len(values) != quantity {
+			err = ServerDeviceFailure
+		}
+		if err != nil {
+			return exceptionResponse(function, err)
+		}
+		bits := packBits(values)
+		return append([]byte{function, byte(len(bits))}, bits...)
+
+	case FuncReadHoldingRegisters, FuncReadInputRegisters:
+		if len(data) != 4 {
+			return exceptionResponse(function, IllegalDataValue)
+		}
+		address := binary.BigEndian.Uint16(data[0:])
+		quantity := int(binary.BigEndian.Uint16(data[2:]))
+		if err := checkQuantity(quantity, MaxReadRegisters); err != nil {
+			return exceptionResponse(function, IllegalDataValue)
+		}
+		read := s.handler.ReadHoldingRegisters
+		if function == FuncReadInputRegisters {
+			read = s.handler.ReadInputRegisters
+		}
+		values, err := read(unit, address, quantity)
+		if err == nil && len(values) != quantity {
+			err = ServerDeviceFailure
+		}
+		if err != nil {
+			return exceptionResponse(function, err)
+		}
+		return append([]byte{function, byte(quantity * 2)}, packRegisters(values)...)
+
+	case FuncWriteSingleCoil:
+		if len(data) != 4 || (data[2] != 0x00 && data[2] != 0xFF) || data[3] != 0x00 {
+			return exceptionResponse(function, IllegalDataValue)
+		}
+		address := binary.BigEndian.Uint16(data[0:])
+		if err := s.handler.WriteCoils(unit, address, []bool{data[2] == 0xFF}); err != nil {
+			return exceptionResponse(function, err)
+		}
+		return append([]byte{}, req...)
+
+	case FuncWriteSingleRegister:
+		if len(data) != 4 {
+			return exceptionResponse(function, IllegalDataValue)
+		}
+		address := binary.BigEndian.Uint16(data[0:])
+		value := binary.BigEndian.Uint16(data[2:])
+		if err := s.handler.WriteHoldingRegisters(unit, address, []uint16{value}); err != nil {
+			return exceptionResponse(function, err)
+		}
+		return append([]byte{}, req...)
+
+	case FuncWriteMultipleCoils:
+		if len(data) < 5 {
+			return exceptionResponse(function, IllegalDataValue)
+		}
+		address := binary.BigEndian.Uint16(data[0:])
+		quantity := int(binary.BigEndian.Uint16(data[2:]))
+		count := int(data[4])
+		if checkQuantity(quantity, MaxWriteBits) != nil || count != (quantity+7)/8 || len(data) != 5+count {
+			return exceptionResponse(function, IllegalDataValue)
+		}
+		if err := s.handler.WriteCoils(unit, address, unpackBits(data[5:], quantity)); err != nil {
+			return exceptionResponse(function, err)
+		}
+		return append([]byte{}, req[:5]...)
+
+	case FuncWriteMultipleRegisters:
+		if len(data) < 5 {
+			return exceptionResponse(function, IllegalDataValue)
+		}
+		address := binary.BigEndian.Uint16(data[0:])
+		quantity := int(binary.BigEndian.Uint16(data[2:]))
+		count := int(data[4])
+		if checkQuantity(quantity, MaxWriteRegisters) != nil || count != quantity*2 || len(data) != 5+count {
+			return exceptionResponse(function, IllegalDataValue)
+		}
+		if err := s.handler.WriteHoldingRegisters(unit, address, unpackRegisters(data[5:])); err != nil {
+			return exceptionResponse(function, err)
+		}
+		return append([]byte{}, req[:5]...)
+
+	case FuncReadWriteMultipleRegisters:
+		if len(data) < 9 {
+			return exceptionResponse(function, IllegalDataValue)
+		}
+		readAddress := binary.BigEndian.Uint16(data[0:])
+		readQuantity := int(binary.BigEndian.Uint16(data[2:]))
+		writeAddress := binary.BigEndian.Uint16(data[4:])
+		writeQuantity := int(binary.BigEndian.Uint16(data[6:]))
+		count := int(data[8])
+		if checkQuantity(readQuantity, MaxReadRegisters) != nil ||
+			checkQuantity(writeQuantity, MaxReadWriteRegisters) != nil ||
+			count != writeQuantity*2 || len(data) != 9+count {
+			return exceptionResponse(function, IllegalDataValue)
+		}
+		// The write operation is performed before the read
+		if err := s.handler.WriteHoldingRegisters(unit, writeAddress, unpackRegisters(data[9:])); err != nil {
+			return exceptionResponse(function, err)
+		}
+		values, err := s.handler.ReadHoldingRegisters(unit, readAddress, readQuantity)
+		if err == nil && len(values) != readQuantity {
+			err = ServerDeviceFailure
+		}
+		if err != nil {
+			return exceptionResponse(function, err)
+		}
+		return append([]byte{function, byte(readQuantity * 2)}, packRegisters(values)...)
+
+	default:
+		return exceptionResponse(function, IllegalFunction)
+	}
+}
+
+// diagnostics runs a diagnostics request (function code 8) and returns
+// the response PDU. It must be called with the lock held.
+func (s *Server) diagnostics(req []byte) []byte {
+	if len(req) < 3 {
+		return exceptionResponse(FuncDiagnostics, IllegalDataValue)
+	}
+	subFunction := binary.BigEndian.Uint16(req[1:])
+	if s.listenOnly && subFunction != DiagRestartCommunicationsOption {
+		return nil
+	}
+	res := append([]byte{}, req[:3]...)
+	switch subFunction {
+	case DiagReturnQueryData:
+		return append([]byte{}, req...)
+	case DiagRestartCommunicationsOption:
+		// The communication event log is not kept, so there is nothing
+		// else to clear
+		s.listenOnly = false
+		s.clearCounters = true
+		return append([]byte{}, req...)
+	case DiagReturnDiagnosticRegister:
+		return append(res, 0, 0)
+	case DiagForceListenOnlyMode:
+		s.listenOnly = true
+		return nil
+	case DiagClearCountersAndDiagnosticReg:
+		s.clearCounters = true
+		return append([]byte{}, req...)
+	case DiagClearOverrunCounterAndFlag:
+		s.counters.BusCharacterOverruns = 0
+		return append([]byte{}, req...)
+	}
+	if value, ok := s.counters.counter(subFunction); ok {
+		return append(res, byte(value>>8), byte(value))
+	}
+	return exceptionResponse(FuncDiagnostics, IllegalFunction)
+}
@@ -0,0 +1,178 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package modbus
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.bug.st/serial"
+	"go.bug.st/serial/internal/ptytest"
+)
+
+func newTestServer(t *testing.T, handler Handler, units ...byte) (*Server, *Client) {
+	mode := &serial.Mode{BaudRate: 9600}
+	slave, master, err := ptytest.Pair(mode)
+	require.NoError(t, err)
+	server := NewServer(master, mode, handler, units...)
+	go server.Serve()
+	client := NewClient(slave, mode)
+	client.Timeout = 200 * time.Millisecond
+	client.TurnaroundDelay = 20 * time.Millisecond
+	return server, client
+}
+
+func TestServer(t *testing.T) {
+	memory := NewMemory(100)
+	memory.DiscreteInputs[3] = true
+	memory.InputRegisters[10] = 0xCAFE
+	server, client := newTestServer(t, memory, 1, 2)
+	defer server.Close()
+	defer client.Close()
+	ctx := context.Background()
+
+	require.NoError(t, client.WriteMultipleCoils(ctx, 1, 5, []bool{true, false, true}))
+	coils, err := client.ReadCoils(ctx, 2, 4, 4)
+	require.NoError(t, err)
+	require.Equal(t, []bool{false, true, false, true}, coils)
+	require.NoError(t, client.WriteSingleCoil(ctx, 1, 5, false))
+	coils, err = client.ReadCoils(ctx, 1, 5, 1)
+	require.NoError(t, err)
+	require.Equal(t, []bool{false}, coils)
+
+	inputs, err := client.ReadDiscreteInputs(ctx, 1, 0, 5)
+	require.NoError(t, err)
+	require.Equal(t, []bool{false, false, false, true, false}, inputs)
+
+	regs, err := client.ReadInputRegisters(ctx, 1, 9, 2)
+	require.NoError(t, err)
+	require.Equal(t, []uint16{0, 0xCAFE}, regs)
+
+	require.NoError(t, client.WriteSingleRegister(ctx, 1, 20, 0x1234))
+	require.NoError(t, client.WriteMultipleRegisters(ctx, 1, 21, []uint16{1, 2, 3}))
+	regs, err = client.ReadWriteMultipleRegisters(ctx, 1, 20, 4, 24, []uint16{4})
+	require.NoError(t, err)
+	require.Equal(t, []uint16{0x1234, 1, 2, 3}, regs)
+	regs, err = client.ReadHoldingRegisters(ctx, 1, 24, 1)
+	require.NoError(t, err)
+	require.Equal(t, []uint16{4}, regs)
+
+	_, err = client.ReadHoldingRegisters(ctx, 1, 99, 2)
+	require.Equal(t, &Exception{Function: FuncReadHoldingRegisters, Code: IllegalDataAddress}, err)
+	_, err = client.Send(ctx, 1, []byte{0x2B, 0x0E, 0x01, 0x00})
+	require.Equal(t, &Exception{Function: 0x2B, Code: IllegalFunction}, err)
+
+	// Unit 3 is not served
+	_, err = client.ReadHoldingRegisters(ctx, 3, 0, 1)
+	require.Equal(t, ErrTimeout, err)
+
+	// Broadcast writes are executed but not answered
+	require.NoError(t, client.WriteSingleRegister(ctx, BroadcastAddress, 0, 0xBEEF))
+	regs, err = client.ReadHoldingRegisters(ctx, 2, 0, 1)
+	require.NoError(t, err)
+	require.Equal(t, []uint16{0xBEEF}, regs)
+
+	// Broadcast reads are not executed
+	_, err = client.Send(ctx, BroadcastAddress, []byte{FuncReadWriteMultipleRegisters,
+		0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01, 0x02, 0x12, 0x34})
+	require.NoError(t, err)
+	regs, err = client.ReadHoldingRegisters(ctx, 2, 0, 1)
+	require.NoError(t, err)
+	require.Equal(t, []uint16{0xBEEF}, regs)
+}
+
+func TestServerDiagnostics(t *testing.T) {
+	server, client := newTestServer(t, NewMemory(10), 1)
+	defer server.Close()
+	defer client.Close()
+	ctx := context.Background()
+
+	echo, err := client.Diagnostics(ctx, 1, DiagReturnQueryData, 0xA537)
+	require.NoError(t, err)
+	require.Equal(t, uint16(0xA537), echo)
+
+	_, err = client.ReadCoils(ctx, 1, 20, 1)
+	require.Error(t, err)
+	_, err = client.ReadCoils(ctx, 7, 0, 1)
+	require.Equal(t, ErrTimeout, err)
+
+	count, err := client.Diagnostics(ctx, 1, DiagReturnBusMessageCount, 0)
+	require.NoError(t, err)
+	require.Equal(t, uint16(4), count)
+	count, err = client.Diagnostics(ctx, 1, DiagReturnServerMessageCount, 0)
+	require.NoError(t, err)
+	require.Equal(t, uint16(4), count)
+	count, err = client.Diagnostics(ctx, 1, DiagReturnBusExceptionErrorCount, 0)
+	require.NoError(t, err)
+	require.Equal(t, uint16(1), count)
+
+	// Corrupted frames are counted as communication errors
+	_, err = client.port.Write([]byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00})
+	require.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, uint16(1), server.Counters().BusCommunicationErrors)
+	require.Equal(t, uint16(7), server.Counters().BusMessages)
+
+	// In listen only mode the server doesn't answer
+	_, err = client.Diagnostics(ctx, 1, DiagForceListenOnlyMode, 0)
+	require.Equal(t, ErrTimeout, err)
+	_, err = client.ReadCoils(ctx, 1, 0, 1)
+	require.Equal(t, ErrTimeout, err)
+	_, err = client.Diagnostics(ctx, 1, DiagRestartCommunicationsOption, 0)
+	require.Equal(t, ErrTimeout, err)
+	_, err = client.ReadCoils(ctx, 1, 0, 1)
+	require.NoError(t, err)
+	require.Equal(t, Counters{BusMessages: 1, ServerMessages: 1}, server.Counters())
+}
+
+func TestServerClose(t *testing.T) {
+	mode := &serial.Mode{}
+	slave, master, err := ptytest.Pair(mode)
+	require.NoError(t, err)
+	defer slave.Close()
+	server := NewServer(master, mode, NewMemory(1))
+	done := make(chan error)
+	go func() {
+		done <- server.Serve()
+	}()
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, server.Close())
+	select {
+	case err := <-done:
+		require.Equal(t, ErrClosed, err)
+	case <-time.After(time.Second):
+		t.Fatal("Serve didn't return after Close")
+	}
+}
+
+func TestServerMinFrameGap(t *testing.T) {
+	mode := &serial.Mode{BaudRate: 115200}
+	slave, master, err := ptytest.Pair(mode)
+	require.NoError(t, err)
+	defer slave.Close()
+	memory := NewMemory(10)
+	memory.HoldingRegisters[1] = 0x1234
+	server := NewServer(master, mode, memory)
+	server.MinFrameGap = 50 * time.Millisecond
+	go server.Serve()
+	defer server.Close()
+
+	// The request is written in two parts, delayed more than FrameDelay
+	req := appendCRC([]byte{0x01, 0x03, 0x00, 0x01, 0x00, 0x01})
+	_, err = slave.Write(req[:3])
+	require.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+	_, err = slave.Write(req[3:])
+	require.NoError(t, err)
+
+	res, err := serial.NewReader(slave).ReadExactly(7, time.Second)
+	require.NoError(t, err)
+	require.Equal(t, appendCRC([]byte{0x01, 0x03, 0x02, 0x12, 0x34}), res)
+	require.Equal(t, uint16(0), server.Counters().BusCommunicationErrors)
+}
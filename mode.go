@@ -0,0 +1,263 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serial
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseMode parses a compact serial port configuration string like
+// "115200,8N1" or "57600-7E1" and returns the corresponding Mode.
+//
+// The string is made of up to three fields: the baud rate, the character
+// framing and the flow control. Fields may be separated by any of the
+// characters ",-_:/" or by spaces. The framing may be written either as
+// data bits, parity and stop bits ("8N1", "7E2", "8N1.5") or as parity,
+// data bits and stop bits ("N81"). Parity letters are N (none), O (odd),
+// E (even), M (mark) and S (space). The optional flow control field may be
+// "none", "rtscts" (or "hw") and "xonxoff" (or "sw").
+// Missing fields keep their default value, so "9600" is equivalent to
+// "9600,8N1".
+func ParseMode(s string) (*Mode, error) {
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return strings.ContainsRune(",-_:/ \t", r)
+	})
+	if len(fields) == 0 || len(fields) > 3 {
+		return nil, fmt.Errorf("invalid serial mode %q", s)
+	}
+
+	mode := &Mode{}
+	baud, err := strconv.Atoi(fields[0])
+	if err != nil || baud <= 0 {
+		return nil, &PortError{code: InvalidSpeed, causedBy: fmt.Errorf("invalid baud rate %q", fields[0])}
+	}
+	mode.BaudRate = baud
+	mode.DataBits = 8
+
+	if len(fields) > 1 {
+		if err := parseFraming(fields[1], mode); err != nil {
+			return nil, err
+		}
+	}
+	if len(fields) > 2 {
+		if err := mode.FlowControl.UnmarshalText([]byte(fields[2])); err != nil {
+			return nil, err
+		}
+	}
+	return mode, nil
+}
+
+// parseFraming parses strings like "8N1", "7E1.5" or "N81" into mode.
+func parseFraming(s string, mode *Mode) error {
+	invalid := fmt.Errorf("invalid character framing %q", s)
+	if len(s) < 3 {
+		return invalid
+	}
+	var data, parity, stop string
+	if s[0] >= '0' && s[0] <= '9' {
+		data, parity, stop = s[0:1], s[1:2], s[2:]
+	} else {
+		parity, data, stop = s[0:1], s[1:2], s[2:]
+	}
+
+	bits, err := strconv.Atoi(data)
+	if err != nil || bits < 5 || bits > 8 {
+		return &PortError{code: InvalidDataBits, causedBy: invalid}
+	}
+	mode.DataBits = bits
+	if err := mode.Parity.UnmarshalText([]byte(parity)); err != nil {
+		return err
+	}
+	return mode.StopBits.UnmarshalText([]byte(stop))
+}
+
+// String returns the compact representation of the Mode as accepted by
+// ParseMode, for example "115200,8N1" or "9600,7E2,rtscts".
+// Zero values are replaced with the defaults used when opening a port,
+// so the zero Mode is formatted as "9600,8N1". An invalid parity or
+// number of stop bits is written as "?", that ParseMode rejects, while
+// MarshalText fails.
+func (mode Mode) String() string {
+	baud := mode.BaudRate
+	if baud == 0 {
+		baud = 9600
+	}
+	bits := mode.DataBits
+	if bits == 0 {
+		bits = 8
+	}
+	parity, ok := parityLetters[mode.Parity]
+	if !ok {
+		parity = "?"
+	}
+	stop, ok := stopBitsNames[mode.StopBits]
+	if !ok {
+		stop = "?"
+	}
+	res := fmt.Sprintf("%d,%d%s%s", baud, bits, parity, stop)
+	if mode.FlowControl != NoFlowControl {
+		res += "," + mode.FlowControl.String()
+	}
+	return res
+}
+
+// MarshalText implements the encoding.TextMarshaler interface.
+func (mode Mode) MarshalText() ([]byte, error) {
+	if _, ok := parityLetters[mode.Parity]; !ok {
+		return nil, &PortError{code: InvalidParity}
+	}
+	if _, ok := stopBitsNames[mode.StopBits]; !ok {
+		return nil, &PortError{code: InvalidStopBits}
+	}
+	if _, ok := flowControlNames[mode.FlowControl]; !ok {
+		return nil, &PortError{code: InvalidFlowControl}
+	}
+	return []byte(mode.String()), nil
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface.
+// The accepted format is the same of ParseMode.
+func (mode *Mode) UnmarshalText(text []byte) error {
+	m, err := ParseMode(string(text))
+	if err != nil {
+		return err
+	}
+	*mode = *m
+	return nil
+}
+
+var parityLetters = map[Parity]string{
+	NoParity:    "N",
+	OddParity:   "O",
+	EvenParity:  "E",
+	MarkParity:  "M",
+	SpaceParity: "S",
+}
+
+var parityNames = map[Parity]string{
+	NoParity:    "none",
+	OddParity:   "odd",
+	EvenParity:  "even",
+	MarkParity:  "mark",
+	SpaceParity: "space",
+}
+
+// String returns the name of the parity setting ("none", "odd", "even",
+// "mark" or "space").
+func (p Parity) String() string {
+	if name, ok := parityNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("Parity(%d)", int(p))
+}
+
+// MarshalText implements the encoding.TextMarshaler interface.
+func (p Parity) MarshalText() ([]byte, error) {
+	name, ok := parityNames[p]
+	if !ok {
+		return nil, &PortError{code: InvalidParity}
+	}
+	return []byte(name), nil
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface.
+// Both the full names and the single letters N, O, E, M and S are accepted.
+func (p *Parity) UnmarshalText(text []byte) error {
+	s := string(text)
+	for parity, name := range parityNames {
+		if strings.EqualFold(s, name) || strings.EqualFold(s, parityLetters[parity]) {
+			*p = parity
+			return nil
+		}
+	}
+	return &PortError{code: InvalidParity, causedBy: fmt.Errorf("invalid parity %q", s)}
+}
+
+var stopBitsNames = map[StopBits]string{
+	OneStopBit:           "1",
+	OnePointFiveStopBits: "1.5",
+	TwoStopBits:          "2",
+}
+
+// String returns the number of stop bits ("1", "1.5" or "2").
+func (s StopBits) String() string {
+	if name, ok := stopBitsNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("StopBits(%d)", int(s))
+}
+
+// MarshalText implements the encoding.TextMarshaler interface.
+func (s StopBits) MarshalText() ([]byte, error) {
+	name, ok := stopBitsNames[s]
+	if !ok {
+		return nil, &PortError{code: InvalidStopBits}
+	}
+	return []byte(name), nil
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface.
+func (s *StopBits) UnmarshalText(text []byte) error {
+	for bits, name := range stopBitsNames {
+		if string(text) == name {
+			*s = bits
+			return nil
+		}
+	}
+	return &PortError{code: InvalidStopBits, causedBy: fmt.Errorf("invalid stop bits %q", string(text))}
+}
+
+var flowControlNames = map[FlowControl]string{
+	NoFlowControl:       "none",
+	HardwareFlowControl: "rtscts",
+	SoftwareFlowControl: "xonxoff",
+}
+
+var flowControlAliases = map[string]FlowControl{
+	"hw":       HardwareFlowControl,
+	"hardware": HardwareFlowControl,
+	"sw":       SoftwareFlowControl,
+	"software": SoftwareFlowControl,
+}
+
+// String returns the name of the flow control setting ("none", "rtscts"
+// or "xonxoff").
+func (f FlowControl) String() string {
+	if name, ok := flowControlNames[f]; ok {
+		return name
+	}
+	return fmt.Sprintf("FlowControl(%d)", int(f))
+}
+
+// MarshalText implements the encoding.TextMarshaler interface.
+func (f FlowControl) MarshalText() ([]byte, error) {
+	name, ok := flowControlNames[f]
+	if !ok {
+		return nil, &PortError{code: InvalidFlowControl}
+	}
+	return []byte(name), nil
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface.
+// Besides the names returned by String, the aliases "hw", "hardware",
+// "sw" and "software" are accepted.
+func (f *FlowControl) UnmarshalText(text []byte) error {
+	s := strings.ToLower(string(text))
+	for flow, name := range flowControlNames {
+		if s == name {
+			*f = flow
+			return nil
+		}
+	}
+	if flow, ok := flowControlAliases[s]; ok {
+		*f = flow
+		return nil
+	}
+	return &PortError{code: InvalidFlowControl, causedBy: fmt.Errorf("invalid flow control %q", string(text))}
+}
@@ -0,0 +1,112 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serial
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMode(t *testing.T) {
+	tests := []struct {
+		in   string
+		mode Mode
+		out  string
+	}{
+		{"115200,8N1", Mode{BaudRate: 115200, DataBits: 8}, "115200,8N1"},
+		{"57600-7E1", Mode{BaudRate: 57600, DataBits: 7, Parity: EvenParity}, "57600,7E1"},
+		{"9600_N81", Mode{BaudRate: 9600, DataBits: 8}, "9600,8N1"},
+		{"9600", Mode{BaudRate: 9600, DataBits: 8}, "9600,8N1"},
+		{"1200 5o2", Mode{BaudRate: 1200, DataBits: 5, Parity: OddParity, StopBits: TwoStopBits}, "1200,5O2"},
+		{"300,6M1.5", Mode{BaudRate: 300, DataBits: 6, Parity: MarkParity, StopBits: OnePointFiveStopBits}, "300,6M1.5"},
+		{"19200,8S1,rtscts", Mode{BaudRate: 19200, DataBits: 8, Parity: SpaceParity, FlowControl: HardwareFlowControl}, "19200,8S1,rtscts"},
+		{"19200:8N1:XONXOFF", Mode{BaudRate: 19200, DataBits: 8, FlowControl: SoftwareFlowControl}, "19200,8N1,xonxoff"},
+		{"19200,8N1,hw", Mode{BaudRate: 19200, DataBits: 8, FlowControl: HardwareFlowControl}, "19200,8N1,rtscts"},
+		{"19200,8N1,none", Mode{BaudRate: 19200, DataBits: 8}, "19200,8N1"},
+	}
+	for _, test := range tests {
+		mode, err := ParseMode(test.in)
+		require.NoError(t, err, test.in)
+		require.Equal(t, test.mode, *mode, test.in)
+		require.Equal(t, test.out, mode.String(), test.in)
+
+		again, err := ParseMode(mode.String())
+		require.NoError(t, err, test.in)
+		require.Equal(t, mode, again, test.in)
+	}
+}
+
+func TestParseModeErrors(t *testing.T) {
+	tests := []struct {
+		in   string
+		code PortErrorCode
+	}{
+		{"fast,8N1", InvalidSpeed},
+		{"0,8N1", InvalidSpeed},
+		{"9600,9N1", InvalidDataBits},
+		{"9600,8X1", InvalidParity},
+		{"9600,8N3", InvalidStopBits},
+		{"9600,8N1,dsrdtr", InvalidFlowControl},
+	}
+	for _, test := range tests {
+		_, err := ParseMode(test.in)
+		require.Error(t, err, test.in)
+		portErr, ok := err.(*PortError)
+		require.True(t, ok, test.in)
+		require.Equal(t, test.code, portErr.Code(), test.in)
+	}
+
+	for _, in := range []string{"", "9600,8N", "9600,8N1,rtscts,extra"} {
+		_, err := ParseMode(in)
+		require.Error(t, err, in)
+	}
+}
+
+func TestModeString(t *testing.T) {
+	require.Equal(t, "9600,8N1", Mode{}.String())
+	require.Equal(t, "none", NoParity.String())
+	require.Equal(t, "1.5", OnePointFiveStopBits.String())
+	require.Equal(t, "Parity(42)", Parity(42).String())
+
+	invalid := Mode{BaudRate: 9600, Parity: Parity(42), StopBits: StopBits(7)}
+	require.Equal(t, "9600,8??", invalid.String())
+	_, err := ParseMode(invalid.String())
+	require.Error(t, err)
+	_, err = invalid.MarshalText()
+	require.Error(t, err)
+}
+
+func TestModeTextMarshaling(t *testing.T) {
+	type config struct {
+		Mode     Mode
+		Parity   Parity
+		StopBits StopBits
+		Flow     FlowControl
+	}
+	in := config{
+		Mode:     Mode{BaudRate: 57600, DataBits: 7, Parity: EvenParity},
+		Parity:   OddParity,
+		StopBits: TwoStopBits,
+		Flow:     SoftwareFlowControl,
+	}
+	data, err := json.Marshal(in)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"Mode":"57600,7E1","Parity":"odd","StopBits":"2","Flow":"xonxoff"}`, string(data))
+
+	var out config
+	require.NoError(t, json.Unmarshal(data, &out))
+	require.Equal(t, in, out)
+
+	require.NoError(t, json.Unmarshal([]byte(`{"Parity":"E"}`), &out))
+	require.Equal(t, EvenParity, out.Parity)
+	require.Error(t, json.Unmarshal([]byte(`{"StopBits":"3"}`), &out))
+
+	_, err = json.Marshal(config{Parity: Parity(42)})
+	require.Error(t, err)
+}
@@ -0,0 +1,225 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serial_test
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.bug.st/serial"
+	"go.bug.st/serial/internal/ptytest"
+	"golang.org/x/sys/unix"
+)
+
+func TestSyscallConn(t *testing.T) {
+	port, master, err := ptytest.Pair(&serial.Mode{BaudRate: 9600})
+	require.NoError(t, err)
+	defer master.Close()
+	defer port.Close()
+
+	sc, ok := port.(syscall.Conn)
+	require.True(t, ok)
+	conn, err := sc.SyscallConn()
+	require.NoError(t, err)
+	var isatty bool
+	require.NoError(t, conn.Control(func(fd uintptr) {
+		_, err := unix.IoctlGetTermios(int(fd), unix.TCGETS)
+		isatty = err == nil
+	}))
+	require.True(t, isatty)
+}
+
+func TestReadZeroTimeout(t *testing.T) {
+	port, master, err := ptytest.Pair(&serial.Mode{BaudRate: 9600})
+	require.NoError(t, err)
+	defer master.Close()
+	defer port.Close()
+
+	buf := make([]byte, 16)
+	require.NoError(t, port.(serial.ReadTimeouter).SetReadTimeout(10*time.Millisecond))
+	n, err := port.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, 0, n)
+
+	// A zero timeout returns the data already received, if any
+	require.NoError(t, port.(serial.ReadTimeouter).SetReadTimeout(0))
+	n, err = port.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, 0, n)
+	_, err = master.Write([]byte("hello"))
+	require.NoError(t, err)
+	time.Sleep(20 * time.Millisecond)
+	n, err = port.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(buf[:n]))
+}
+
+func TestCloseInterruptsWrite(t *testing.T) {
+	port, master, err := ptytest.Pair(&serial.Mode{BaudRate: 9600})
+	require.NoError(t, err)
+	defer master.Close()
+
+	// Nobody reads the master, so the write blocks once the buffers of
+	// the pseudo-terminal are full
+	written := make(chan error, 1)
+	go func() {
+		_, err := port.Write(make([]byte, 1024*1024))
+		written <- err
+	}()
+	select {
+	case err := <-written:
+		t.Fatal("write not blocked:", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	require.NoError(t, port.Close())
+	select {
+	case err := <-written:
+		require.True(t, errors.Is(err, serial.ErrPortClosed), err)
+	case <-time.After(time.Second):
+		t.Fatal("write not interrupted by Close")
+	}
+
+	_, err = port.Write([]byte("hello"))
+	require.True(t, errors.Is(err, serial.ErrPortClosed), err)
+	require.True(t, errors.Is(port.SetDTR(true), serial.ErrPortClosed))
+	require.True(t, errors.Is(port.(serial.Breaker).Break(time.Millisecond), serial.ErrPortClosed))
+}
+
+// threads returns the number of threads of the process.
+func threads(b *testing.B) int {
+	f, err := os.Open("/proc/self/status")
+	require.NoError(b, err)
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if strings.HasPrefix(s.Text(), "Threads:") {
+			n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(s.Text(), "Threads:")))
+			require.NoError(b, err)
+			return n
+		}
+	}
+	b.Fatal("thread count not found")
+	return 0
+}
+
+// BenchmarkBlockedReads exchanges data with one of many ports with a
+// pending Read, and reports the threads used by the process.
+func BenchmarkBlockedReads(b *testing.B) {
+	const count = 256
+	received := make(chan struct{})
+	var masters []*ptytest.Port
+	for i := 0; i < count; i++ {
+		port, master, err := ptytest.Pair(&serial.Mode{BaudRate: 9600})
+		require.NoError(b, err)
+		defer master.Close()
+		defer port.Close()
+		masters = append(masters, master)
+		go func() {
+			buf := make([]byte, 16)
+			for {
+				if _, err := port.Read(buf); err != nil {
+					return
+				}
+				received <- struct{}{}
+			}
+		}()
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		masters[i%count].Write([]byte{0})
+		<-received
+	}
+	b.ReportMetric(float64(threads(b)), "threads")
+}
+
+func BenchmarkThroughput(b *testing.B) {
+	port, master, err := ptytest.Pair(&serial.Mode{BaudRate: 9600})
+	require.NoError(b, err)
+	defer master.Close()
+	defer port.Close()
+
+	data := make([]byte, 1024)
+	go func() {
+		for {
+			if _, err := master.Write(data); err != nil {
+				return
+			}
+		}
+	}()
+
+	buf := make([]byte, len(data))
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := io.ReadFull(port, buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestOpenWithoutPoller(t *testing.T) {
+	serial.SetPollerDisabled(true)
+	port, master, err := ptytest.Pair(&serial.Mode{BaudRate: 9600})
+	serial.SetPollerDisabled(false)
+	require.NoError(t, err)
+	defer master.Close()
+	defer port.Close()
+
+	// The descriptor is in blocking mode, out of the poller
+	conn, err := port.(syscall.Conn).SyscallConn()
+	require.NoError(t, err)
+	var flags int
+	require.NoError(t, conn.Control(func(fd uintptr) {
+		flags, err = unix.FcntlInt(fd, unix.F_GETFL, 0)
+	}))
+	require.NoError(t, err)
+	require.Zero(t, flags&unix.O_NONBLOCK)
+
+	// The read timeout is implemented with a select
+	buf := make([]byte, 16)
+	require.NoError(t, port.(serial.ReadTimeouter).SetReadTimeout(50*time.Millisecond))
+	start := time.Now()
+	n, err := port.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, 0, n)
+	require.True(t, time.Since(start) >= 40*time.Millisecond)
+
+	_, err = master.Write([]byte("hello"))
+	require.NoError(t, err)
+	n, err = port.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(buf[:n]))
+	n, err = port.Write([]byte("world"))
+	require.NoError(t, err)
+	require.Equal(t, 5, n)
+
+	// Close interrupts a pending read
+	require.NoError(t, port.(serial.ReadTimeouter).SetReadTimeout(serial.NoTimeout))
+	read := make(chan error, 1)
+	go func() {
+		_, err := port.Read(buf)
+		read <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	require.NoError(t, port.Close())
+	select {
+	case err := <-read:
+		require.True(t, errors.Is(err, serial.ErrPortClosed), err)
+	case <-time.After(time.Second):
+		t.Fatal("read not interrupted by Close")
+	}
+}
@@ -13,31 +13,173 @@
 //    USB ID     2341:8053
 //    USB serial FB7B6060504B5952302E314AFF08191A
 //
+// The ports can be filtered with -vid, -pid, -serial and -usb-only, and
+// listed as a JSON array of the port details with -json.
+//
+// With -watch the ports are listed as they are added and removed, until
+// the tool is interrupted. With -json each event is a JSON object on its
+// own line:
+//
+// {"event":"add","port":{"Name":"/dev/ttyACM0","IsUSB":true,...}}
+//
+// The exit code is 0 if at least one port matches, 1 if no port matches
+// and 2 on errors.
 package main
 
 import (
+	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
 
 	"go.bug.st/serial/enumerator"
 )
 
-func main() {
+// Exit codes
+const (
+	exitMatch   = 0
+	exitNoMatch = 1
+	exitError   = 2
+)
+
+// filter selects the ports to list.
+type filter struct {
+	vid     string
+	pid     string
+	serial  string
+	usbOnly bool
+}
+
+func (f *filter) match(port *enumerator.PortDetails) bool {
+	if (f.usbOnly || f.vid != "" || f.pid != "" || f.serial != "") && !port.IsUSB {
+		return false
+	}
+	// The case of the IDs depends on the OS
+	if f.vid != "" && !strings.EqualFold(f.vid, port.VID) {
+		return false
+	}
+	if f.pid != "" && !strings.EqualFold(f.pid, port.PID) {
+		return false
+	}
+	return f.serial == "" || f.serial == port.SerialNumber
+}
+
+// list returns the ports that match the filter, sorted by name.
+func (f *filter) list() ([]*enumerator.PortDetails, error) {
 	ports, err := enumerator.GetDetailedPortsList()
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	if len(ports) == 0 {
+	res := []*enumerator.PortDetails{}
+	for _, port := range ports {
+		if f.match(port) {
+			res = append(res, port)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res, nil
+}
+
+// event is a port added or removed.
+type event struct {
+	Event string                  `json:"event"`
+	Port  *enumerator.PortDetails `json:"port"`
+}
+
+// diff returns the events that change the list of ports from old to new.
+// A port whose details changed is removed and added again.
+func diff(old, new []*enumerator.PortDetails) []*event {
+	current := map[string]*enumerator.PortDetails{}
+	for _, port := range new {
+		current[port.Name] = port
+	}
+	previous := map[string]*enumerator.PortDetails{}
+	res := []*event{}
+	for _, port := range old {
+		previous[port.Name] = port
+		if p, ok := current[port.Name]; !ok || *p != *port {
+			res = append(res, &event{Event: "remove", Port: port})
+		}
+	}
+	for _, port := range new {
+		if p, ok := previous[port.Name]; !ok || *p != *port {
+			res = append(res, &event{Event: "add", Port: port})
+		}
+	}
+	return res
+}
+
+func printPort(w io.Writer, title string, port *enumerator.PortDetails) {
+	fmt.Fprintf(w, "%s: %s\n", title, port.Name)
+	if port.Product != "" {
+		fmt.Fprintf(w, "   Product Name: %s\n", port.Product)
+	}
+	if port.IsUSB {
+		fmt.Fprintf(w, "   USB ID      : %s:%s\n", port.VID, port.PID)
+		fmt.Fprintf(w, "   USB serial  : %s\n", port.SerialNumber)
+	}
+}
+
+func printEvent(w io.Writer, e *event, jsonOutput bool) {
+	if jsonOutput {
+		json.NewEncoder(w).Encode(e)
 		return
 	}
-	for _, port := range ports {
-		fmt.Printf("Port: %s\n", port.Name)
-		if port.Product != "" {
-			fmt.Printf("   Product Name: %s\n", port.Product)
+	if e.Event == "add" {
+		printPort(w, "Port added", e.Port)
+	} else {
+		fmt.Fprintf(w, "Port removed: %s\n", e.Port.Name)
+	}
+}
+
+func main() {
+	jsonOutput := flag.Bool("json", false, "print the port details as JSON")
+	f := &filter{}
+	flag.StringVar(&f.vid, "vid", "", "list only the USB ports with the given vendor ID")
+	flag.StringVar(&f.pid, "pid", "", "list only the USB ports with the given product ID")
+	flag.StringVar(&f.serial, "serial", "", "list only the USB ports with the given serial number")
+	flag.BoolVar(&f.usbOnly, "usb-only", false, "list only the USB ports")
+	watch := flag.Bool("watch", false, "print the ports as they are added and removed")
+	interval := flag.Duration("interval", time.Second, "polling interval of -watch")
+	flag.Parse()
+	log.SetFlags(0)
+
+	ports, err := f.list()
+	if err != nil {
+		log.Print(err)
+		os.Exit(exitError)
+	}
+
+	if *watch {
+		var old []*enumerator.PortDetails
+		for {
+			for _, e := range diff(old, ports) {
+				printEvent(os.Stdout, e, *jsonOutput)
+			}
+			old = ports
+			time.Sleep(*interval)
+			if ports, err = f.list(); err != nil {
+				log.Print(err)
+				os.Exit(exitError)
+			}
 		}
-		if port.IsUSB {
-			fmt.Printf("   USB ID      : %s:%s\n", port.VID, port.PID)
-			fmt.Printf("   USB serial  : %s\n", port.SerialNumber)
+	}
+
+	if *jsonOutput {
+		out, _ := json.MarshalIndent(ports, "", "  ")
+		fmt.Println(string(out))
+	} else {
+		for _, port := range ports {
+			printPort(os.Stdout, "Port", port)
 		}
 	}
+	if len(ports) == 0 {
+		os.Exit(exitNoMatch)
+	}
+	os.Exit(exitMatch)
 }
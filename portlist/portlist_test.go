@@ -0,0 +1,69 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.bug.st/serial/enumerator"
+)
+
+var (
+	uno  = &enumerator.PortDetails{Name: "/dev/ttyACM0", IsUSB: true, VID: "2341", PID: "0043", SerialNumber: "75830303934351618212"}
+	ftdi = &enumerator.PortDetails{Name: "/dev/ttyUSB0", IsUSB: true, VID: "0403", PID: "6001", SerialNumber: "A9CRTSEL"}
+	uart = &enumerator.PortDetails{Name: "/dev/ttyS0"}
+)
+
+func TestFilter(t *testing.T) {
+	matching := func(f *filter) []string {
+		res := []string{}
+		for _, port := range []*enumerator.PortDetails{uno, ftdi, uart} {
+			if f.match(port) {
+				res = append(res, port.Name)
+			}
+		}
+		return res
+	}
+	require.Equal(t, []string{"/dev/ttyACM0", "/dev/ttyUSB0", "/dev/ttyS0"}, matching(&filter{}))
+	require.Equal(t, []string{"/dev/ttyACM0", "/dev/ttyUSB0"}, matching(&filter{usbOnly: true}))
+	require.Equal(t, []string{"/dev/ttyUSB0"}, matching(&filter{vid: "0403"}))
+	require.Equal(t, []string{"/dev/ttyACM0"}, matching(&filter{vid: "2341", pid: "0043"}))
+	require.Equal(t, []string{}, matching(&filter{vid: "2341", pid: "6001"}))
+	require.Equal(t, []string{"/dev/ttyUSB0"}, matching(&filter{serial: "A9CRTSEL"}))
+
+	// The IDs are compared ignoring the case
+	sierra := &enumerator.PortDetails{Name: "COM3", IsUSB: true, VID: "1199", PID: "68C0"}
+	require.True(t, (&filter{pid: "68c0"}).match(sierra))
+}
+
+func TestWatchEvents(t *testing.T) {
+	var out strings.Builder
+	uno2 := *uno
+	uno2.SerialNumber = "other"
+	for _, e := range diff([]*enumerator.PortDetails{uno, uart}, []*enumerator.PortDetails{&uno2, ftdi}) {
+		printEvent(&out, e, true)
+	}
+	require.Equal(t, `{"event":"remove","port":{"Name":"/dev/ttyACM0","IsUSB":true,"VID":"2341","PID":"0043","SerialNumber":"75830303934351618212","Product":""}}
+{"event":"remove","port":{"Name":"/dev/ttyS0","IsUSB":false,"VID":"","PID":"","SerialNumber":"","Product":""}}
+{"event":"add","port":{"Name":"/dev/ttyACM0","IsUSB":true,"VID":"2341","PID":"0043","SerialNumber":"other","Product":""}}
+{"event":"add","port":{"Name":"/dev/ttyUSB0","IsUSB":true,"VID":"0403","PID":"6001","SerialNumber":"A9CRTSEL","Product":""}}
+`, out.String())
+
+	out.Reset()
+	for _, e := range diff(nil, []*enumerator.PortDetails{ftdi}) {
+		printEvent(&out, e, false)
+	}
+	printEvent(&out, &event{Event: "remove", Port: ftdi}, false)
+	require.Equal(t, `Port added: /dev/ttyUSB0
+   USB ID      : 0403:6001
+   USB serial  : A9CRTSEL
+Port removed: /dev/ttyUSB0
+`, out.String())
+	require.Empty(t, diff([]*enumerator.PortDetails{uno}, []*enumerator.PortDetails{uno}))
+}
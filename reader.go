@@ -0,0 +1,173 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serial
+
+import (
+	"bytes"
+	"regexp"
+	"time"
+)
+
+// Reader adds buffering to a Port and implements reads that complete when
+// a delimiter, a line, a regular expression or a number of bytes has been
+// received, or a timeout expires. The bytes received past the end of a
+// read are kept for the following ones.
+//
+// The timeouts are implemented with the read timeout of the port, so the
+// Reader owns it: the timeout of Read is set with the SetReadTimeout method
+// of the Reader, and restored on the port at the end of each of the other
+// reads. A port that doesn't implement ReadTimeouter only supports
+// NoTimeout. Closing the port interrupts a pending read, that returns a
+// PortClosed error.
+//
+// A Reader is not safe for concurrent use.
+type Reader struct {
+	port    Port
+	timeout time.Duration // timeout of Read
+	buf     []byte        // received bytes not returned yet
+	chunk   []byte
+}
+
+// NewReader creates a new Reader that reads from port. The read timeout of
+// the port is set to NoTimeout.
+func NewReader(port Port) *Reader {
+	setReadTimeout(port, NoTimeout)
+	return &Reader{
+		port:    port,
+		timeout: NoTimeout,
+		chunk:   make([]byte, 1024),
+	}
+}
+
+// SetReadTimeout sets the timeout of Read on the port, and keeps it to
+// restore it after the other reads.
+func (r *Reader) SetReadTimeout(timeout time.Duration) error {
+	if err := setReadTimeout(r.port, timeout); err != nil {
+		return err
+	}
+	r.timeout = timeout
+	return nil
+}
+
+// Buffered returns the number of bytes received and not read yet.
+func (r *Reader) Buffered() int {
+	return len(r.buf)
+}
+
+// Read reads the buffered bytes or, if there are none, reads from the port
+// with the timeout set with SetReadTimeout.
+func (r *Reader) Read(p []byte) (int, error) {
+	if len(r.buf) == 0 {
+		return r.port.Read(p)
+	}
+	n := copy(p, r.buf)
+	r.consume(n)
+	return n, nil
+}
+
+// ReadUntil reads until the first occurrence of delim and returns the data
+// read, delimiter included.
+//
+// If the timeout expires first a ReadTimeout error is returned and the data
+// already received is kept for the next read. Use NoTimeout to wait forever.
+func (r *Reader) ReadUntil(delim []byte, timeout time.Duration) ([]byte, error) {
+	return r.readUntil(timeout, func(buf []byte) int {
+		i := bytes.Index(buf, delim)
+		if i < 0 {
+			return -1
+		}
+		return i + len(delim)
+	})
+}
+
+// ReadLine reads a line terminated by "\n" and returns it without the
+// terminator and the preceding "\r", if any. The timeout is handled as in
+// ReadUntil.
+func (r *Reader) ReadLine(timeout time.Duration) (string, error) {
+	line, err := r.ReadUntil([]byte{'\n'}, timeout)
+	if err != nil {
+		return "", err
+	}
+	line = line[:len(line)-1]
+	if n := len(line); n > 0 && line[n-1] == '\r' {
+		line = line[:n-1]
+	}
+	return string(line), nil
+}
+
+// ReadExactly reads exactly n bytes. The timeout is handled as in
+// ReadUntil.
+func (r *Reader) ReadExactly(n int, timeout time.Duration) ([]byte, error) {
+	if n < 0 {
+		n = 0
+	}
+	return r.readUntil(timeout, func(buf []byte) int {
+		if len(buf) < n {
+			return -1
+		}
+		return n
+	})
+}
+
+// ReadUntilRegexp reads until the received data matches re and returns the
+// data read up to the end of the match. The data is checked each time new
+// bytes are received, so the shortest prefix of the stream that matches is
+// returned. The timeout is handled as in ReadUntil.
+func (r *Reader) ReadUntilRegexp(re *regexp.Regexp, timeout time.Duration) ([]byte, error) {
+	return r.readUntil(timeout, func(buf []byte) int {
+		loc := re.FindIndex(buf)
+		if loc == nil {
+			return -1
+		}
+		return loc[1]
+	})
+}
+
+// readUntil reads from the port until match returns the length of the data
+// to return, or the timeout expires. match returns -1 if more data is needed.
+func (r *Reader) readUntil(timeout time.Duration, match func(buf []byte) int) ([]byte, error) {
+	if timeout < 0 && timeout != NoTimeout {
+		return nil, &PortError{code: InvalidTimeoutValue}
+	}
+	deadline := time.Now().Add(timeout)
+	polled := false
+	defer setReadTimeout(r.port, r.timeout)
+	for {
+		if n := match(r.buf); n >= 0 {
+			res := make([]byte, n)
+			copy(res, r.buf)
+			r.consume(n)
+			return res, nil
+		}
+
+		t := NoTimeout
+		if timeout != NoTimeout {
+			// The port is checked at least once, even with a zero timeout
+			t = time.Until(deadline)
+			if t <= 0 {
+				if polled {
+					return nil, &PortError{code: ReadTimeout}
+				}
+				t = 0
+			}
+		}
+		if err := setReadTimeout(r.port, t); err != nil {
+			return nil, err
+		}
+		n, err := r.port.Read(r.chunk)
+		polled = true
+		r.buf = append(r.buf, r.chunk[:n]...)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// consume removes the first n bytes from the buffer.
+func (r *Reader) consume(n int) {
+	r.buf = r.buf[:copy(r.buf, r.buf[n:])]
+}
@@ -0,0 +1,131 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serial_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.bug.st/serial"
+	"go.bug.st/serial/internal/ptytest"
+)
+
+func TestReadTimeout(t *testing.T) {
+	port, master, err := ptytest.Pair(&serial.Mode{BaudRate: 9600})
+	require.NoError(t, err)
+	defer master.Close()
+	defer port.Close()
+
+	require.Error(t, port.(serial.ReadTimeouter).SetReadTimeout(-2*time.Second))
+	require.NoError(t, port.(serial.ReadTimeouter).SetReadTimeout(100*time.Millisecond))
+	buf := make([]byte, 16)
+	start := time.Now()
+	n, err := port.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, 0, n)
+	require.True(t, time.Since(start) >= 100*time.Millisecond)
+
+	_, err = master.Write([]byte("hello"))
+	require.NoError(t, err)
+	n, err = port.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(buf[:n]))
+}
+
+func TestReaderOnPort(t *testing.T) {
+	port, master, err := ptytest.Pair(&serial.Mode{BaudRate: 9600})
+	require.NoError(t, err)
+	defer master.Close()
+
+	r := serial.NewReader(port)
+	go func() {
+		master.Write([]byte("OK\r\n"))
+		time.Sleep(20 * time.Millisecond)
+		master.Write([]byte("12345"))
+	}()
+	line, err := r.ReadLine(time.Second)
+	require.NoError(t, err)
+	require.Equal(t, "OK", line)
+	data, err := r.ReadExactly(5, time.Second)
+	require.NoError(t, err)
+	require.Equal(t, "12345", string(data))
+
+	// Closing the port interrupts a pending read
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		port.Close()
+	}()
+	_, err = r.ReadLine(serial.NoTimeout)
+	require.Error(t, err)
+	require.Equal(t, serial.PortClosed, err.(*serial.PortError).Code())
+}
+
+func TestReadDisconnected(t *testing.T) {
+	for _, timeout := range []time.Duration{serial.NoTimeout, time.Second} {
+		port, master, err := ptytest.Pair(&serial.Mode{BaudRate: 9600})
+		require.NoError(t, err)
+		require.NoError(t, port.(serial.ReadTimeouter).SetReadTimeout(timeout))
+
+		read := make(chan error)
+		go func() {
+			_, err := port.Read(make([]byte, 16))
+			read <- err
+		}()
+		time.Sleep(20 * time.Millisecond)
+		start := time.Now()
+		require.NoError(t, master.Close())
+		err = <-read
+		require.Error(t, err)
+		require.Equal(t, serial.PortDisconnected, err.(*serial.PortError).Code())
+		require.True(t, time.Since(start) < 500*time.Millisecond)
+
+		_, err = port.Write([]byte("hello"))
+		require.Error(t, err)
+		require.Equal(t, serial.PortDisconnected, err.(*serial.PortError).Code())
+		require.NoError(t, port.Close())
+	}
+}
+
+func TestReadHighFD(t *testing.T) {
+	// Fill the descriptors below FD_SETSIZE, so that the port gets one
+	// that select can't wait for
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for i := 0; i < 1100; i++ {
+		f, err := os.Open(os.DevNull)
+		if err != nil {
+			t.Skip("can't open enough files:", err)
+		}
+		files = append(files, f)
+		if f.Fd() > 1024 {
+			break
+		}
+	}
+
+	port, master, err := ptytest.Pair(&serial.Mode{BaudRate: 9600})
+	require.NoError(t, err)
+	defer master.Close()
+	defer port.Close()
+
+	require.NoError(t, port.(serial.ReadTimeouter).SetReadTimeout(50*time.Millisecond))
+	buf := make([]byte, 16)
+	n, err := port.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, 0, n)
+
+	_, err = master.Write([]byte("hello"))
+	require.NoError(t, err)
+	n, err = port.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(buf[:n]))
+}
@@ -0,0 +1,208 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package serial
+
+import (
+	"errors"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakePort is a Port that receives the chunks sent on its data channel.
+type fakePort struct {
+	data    chan []byte
+	closed  chan struct{}
+	once    sync.Once
+	mu      sync.Mutex
+	timeout time.Duration
+}
+
+func newFakePort() *fakePort {
+	return &fakePort{
+		data:    make(chan []byte, 16),
+		closed:  make(chan struct{}),
+		timeout: NoTimeout,
+	}
+}
+
+func (p *fakePort) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	timeout := p.timeout
+	p.mu.Unlock()
+	var expired <-chan time.Time
+	if timeout != NoTimeout {
+		expired = time.After(timeout)
+	}
+	select {
+	case chunk := <-p.data:
+		return copy(b, chunk), nil
+	case <-expired:
+		return 0, nil
+	case <-p.closed:
+		return 0, &PortError{code: PortClosed}
+	}
+}
+
+func (p *fakePort) SetReadTimeout(t time.Duration) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.timeout = t
+	return nil
+}
+
+func (p *fakePort) Close() error {
+	p.once.Do(func() { close(p.closed) })
+	return nil
+}
+
+func (p *fakePort) SetMode(mode *Mode) error                      { return nil }
+func (p *fakePort) Write(b []byte) (int, error)                   { return len(b), nil }
+func (p *fakePort) ResetInputBuffer() error                       { return nil }
+func (p *fakePort) ResetOutputBuffer() error                      { return nil }
+func (p *fakePort) SetDTR(dtr bool) error                         { return nil }
+func (p *fakePort) SetRTS(rts bool) error                         { return nil }
+func (p *fakePort) GetModemStatusBits() (*ModemStatusBits, error) { return &ModemStatusBits{}, nil }
+
+func TestReaderReadUntil(t *testing.T) {
+	port := newFakePort()
+	r := NewReader(port)
+
+	port.data <- []byte("AT+C")
+	port.data <- []byte("GMI\r\nOK\r\nAT")
+	res, err := r.ReadUntil([]byte("OK\r\n"), time.Second)
+	require.NoError(t, err)
+	require.Equal(t, "AT+CGMI\r\nOK\r\n", string(res))
+	require.Equal(t, 2, r.Buffered())
+
+	// The buffered bytes are returned first
+	port.data <- []byte("I\r\n")
+	line, err := r.ReadLine(time.Second)
+	require.NoError(t, err)
+	require.Equal(t, "ATI", line)
+	require.Equal(t, 0, r.Buffered())
+	require.Equal(t, NoTimeout, port.timeout)
+}
+
+func TestReaderReadLine(t *testing.T) {
+	port := newFakePort()
+	r := NewReader(port)
+
+	port.data <- []byte("first\nsecond\r\n\nlast")
+	for _, exp := range []string{"first", "second", ""} {
+		line, err := r.ReadLine(time.Second)
+		require.NoError(t, err)
+		require.Equal(t, exp, line)
+	}
+	_, err := r.ReadLine(50 * time.Millisecond)
+	require.Error(t, err)
+	require.Equal(t, ReadTimeout, err.(*PortError).Code())
+}
+
+func TestReaderReadExactly(t *testing.T) {
+	port := newFakePort()
+	r := NewReader(port)
+
+	port.data <- []byte{1, 2, 3}
+	port.data <- []byte{4, 5, 6, 7}
+	res, err := r.ReadExactly(5, time.Second)
+	require.NoError(t, err)
+	require.Equal(t, []byte{1, 2, 3, 4, 5}, res)
+
+	res, err = r.ReadExactly(2, 0)
+	require.NoError(t, err)
+	require.Equal(t, []byte{6, 7}, res)
+
+	res, err = r.ReadExactly(0, 0)
+	require.NoError(t, err)
+	require.Empty(t, res)
+}
+
+func TestReaderReadUntilRegexp(t *testing.T) {
+	port := newFakePort()
+	r := NewReader(port)
+
+	re := regexp.MustCompile(`\r\n(OK|ERROR)\r\n`)
+	port.data <- []byte("+CSQ: 21,99\r\n\r\nERR")
+	port.data <- []byte("OR\r\nnext")
+	res, err := r.ReadUntilRegexp(re, time.Second)
+	require.NoError(t, err)
+	require.Equal(t, "+CSQ: 21,99\r\n\r\nERROR\r\n", string(res))
+
+	buf := make([]byte, 10)
+	n, err := r.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "next", string(buf[:n]))
+}
+
+func TestReaderTimeoutKeepsData(t *testing.T) {
+	port := newFakePort()
+	r := NewReader(port)
+
+	port.data <- []byte("partial")
+	start := time.Now()
+	_, err := r.ReadUntil([]byte("\n"), 100*time.Millisecond)
+	require.Error(t, err)
+	require.Equal(t, ReadTimeout, err.(*PortError).Code())
+	require.True(t, time.Since(start) >= 100*time.Millisecond)
+	require.Equal(t, 7, r.Buffered())
+
+	port.data <- []byte(" line\n")
+	line, err := r.ReadLine(time.Second)
+	require.NoError(t, err)
+	require.Equal(t, "partial line", line)
+
+	_, err = r.ReadLine(-5)
+	require.Error(t, err)
+	require.Equal(t, InvalidTimeoutValue, err.(*PortError).Code())
+}
+
+func TestReaderClose(t *testing.T) {
+	port := newFakePort()
+	r := NewReader(port)
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		port.Close()
+	}()
+	_, err := r.ReadLine(NoTimeout)
+	require.Error(t, err)
+	require.Equal(t, PortClosed, err.(*PortError).Code())
+}
+
+func TestReaderRestoresTimeout(t *testing.T) {
+	port := newFakePort()
+	r := NewReader(port)
+	require.NoError(t, r.SetReadTimeout(20*time.Millisecond))
+
+	port.data <- []byte("line\n")
+	_, err := r.ReadLine(time.Second)
+	require.NoError(t, err)
+	require.Equal(t, 20*time.Millisecond, port.timeout)
+
+	n, err := r.Read(make([]byte, 10))
+	require.NoError(t, err)
+	require.Equal(t, 0, n)
+}
+
+func TestReaderWithoutTimeouts(t *testing.T) {
+	port := newFakePort()
+	// The embedded interface hides SetReadTimeout
+	r := NewReader(struct{ Port }{port})
+
+	port.data <- []byte("line\n")
+	line, err := r.ReadLine(NoTimeout)
+	require.NoError(t, err)
+	require.Equal(t, "line", line)
+
+	_, err = r.ReadLine(time.Second)
+	require.True(t, errors.Is(err, ErrFunctionNotImplemented), err)
+	require.True(t, errors.Is(r.SetReadTimeout(time.Second), ErrFunctionNotImplemented))
+}
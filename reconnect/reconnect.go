@@ -0,0 +1,589 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+// Package reconnect provides a serial.Port that survives the disconnection
+// of the device, like an USB adapter unplugged and plugged again.
+//
+// The device is found by its Identity: the USB VID, PID and serial number
+// reported by the enumerator package, or a path like the links in
+// /dev/serial/by-path. When a Read or a Write fails with a
+// serial.PortDisconnected error, because the device has been removed, the
+// port is closed and reopened in the background, with an increasing delay
+// between the attempts, restoring the mode, the read timeout and the DTR
+// and RTS state. The other errors are returned as they are.
+//
+//   id, err := reconnect.IdentityOf("/dev/ttyUSB0")
+//   if err != nil {
+//       log.Fatal(err)
+//   }
+//   port := reconnect.New(id, &serial.Mode{BaudRate: 115200})
+//   if err := port.Open(); err != nil {
+//       log.Fatal(err)
+//   }
+package reconnect
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"go.bug.st/serial"
+	"go.bug.st/serial/enumerator"
+)
+
+// The errors that have a serial.Port counterpart are the same values, so
+// that they can be tested with errors.Is like those of any serial.Port.
+var (
+	// ErrDisconnected is returned by the operations on a disconnected port
+	// with the Fail policy
+	ErrDisconnected error = serial.ErrPortDisconnected
+	// ErrClosed is returned by the operations on a closed port
+	ErrClosed error = serial.ErrPortClosed
+	// ErrNotFound is returned by Open and IdentityOf if no port matches
+	ErrNotFound = errors.New("reconnect: port not found")
+	// ErrInvalidTimeout is returned by SetReadTimeout for negative
+	// timeouts other than serial.NoTimeout
+	ErrInvalidTimeout error = serial.ErrInvalidTimeoutValue
+)
+
+const (
+	// DefaultMinBackoff is the default delay before the first attempt to
+	// reopen a port
+	DefaultMinBackoff = 100 * time.Millisecond
+	// DefaultMaxBackoff is the default maximum delay between the attempts
+	// to reopen a port
+	DefaultMaxBackoff = 5 * time.Second
+)
+
+// minBackoff is the shortest delay between two attempts after a failure,
+// that keeps a zero MinBackoff from spinning on the enumeration.
+const minBackoff = 10 * time.Millisecond
+
+// Identity identifies the device of a port. If Path is set the port is
+// opened with that name, otherwise it's the first USB port that matches
+// the non-empty fields.
+type Identity struct {
+	VID          string
+	PID          string
+	SerialNumber string
+	Path         string
+}
+
+func (id *Identity) match(port *enumerator.PortDetails) bool {
+	if !port.IsUSB {
+		return false
+	}
+	// The case of the IDs depends on the OS
+	if id.VID != "" && !strings.EqualFold(id.VID, port.VID) {
+		return false
+	}
+	if id.PID != "" && !strings.EqualFold(id.PID, port.PID) {
+		return false
+	}
+	return id.SerialNumber == "" || id.SerialNumber == port.SerialNumber
+}
+
+// IdentityOf returns the identity of the port with the given name: its USB
+// IDs and serial number for an USB port, otherwise the name itself as
+// Path.
+func IdentityOf(name string) (*Identity, error) {
+	ports, err := enumerator.GetDetailedPortsList()
+	if err != nil {
+		return nil, err
+	}
+	for _, port := range ports {
+		if port.Name != name {
+			continue
+		}
+		if port.IsUSB {
+			return &Identity{VID: port.VID, PID: port.PID, SerialNumber: port.SerialNumber}, nil
+		}
+		return &Identity{Path: name}, nil
+	}
+	return nil, ErrNotFound
+}
+
+// Policy is the behaviour of the operations on a disconnected port.
+type Policy int
+
+const (
+	// Block makes the operations wait until the port is reopened. A Read
+	// waits at most for the read timeout.
+	Block Policy = iota
+	// Fail makes the operations fail with ErrDisconnected
+	Fail
+)
+
+// State is the state of a Port.
+type State int
+
+const (
+	// Connected means the port is open
+	Connected State = iota
+	// Disconnected means the port is being reopened
+	Disconnected
+	// Closed means the port has been closed with Close
+	Closed
+)
+
+func (s State) String() string {
+	switch s {
+	case Connected:
+		return "connected"
+	case Disconnected:
+		return "disconnected"
+	}
+	return "closed"
+}
+
+// StateChange is a change of the state of a Port.
+type StateChange struct {
+	State State
+	// Name is the name of the port connected
+	Name string
+	// Err is the error that caused the disconnection
+	Err error
+}
+
+// Port is a serial.Port that reopens the port of a device when it's
+// disconnected. The settings of the exported fields must be done before
+// calling Open.
+type Port struct {
+	// Policy is the behaviour of the operations while the port is
+	// disconnected
+	Policy Policy
+	// MinBackoff and MaxBackoff are the bounds of the delay between the
+	// attempts to reopen the port, doubled after each failure
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Changes, if not nil, receives the changes of state. The sends don't
+	// block, so the channel should be buffered.
+	Changes chan<- StateChange
+	// Enumerate lists the ports to find the device,
+	// enumerator.GetDetailedPortsList if nil
+	Enumerate func() ([]*enumerator.PortDetails, error)
+	// OpenPort opens a port, serial.Open if nil
+	OpenPort func(name string, mode *serial.Mode) (serial.Port, error)
+
+	identity Identity
+
+	mu          sync.Mutex
+	changed     chan struct{}
+	done        chan struct{}
+	port        serial.Port
+	name        string
+	closed      bool
+	mode        serial.Mode
+	readTimeout time.Duration
+	dtr         *bool
+	rts         *bool
+}
+
+// New creates a new Port for the device with the given identity, that is
+// opened with mode.
+func New(id *Identity, mode *serial.Mode) *Port {
+	return &Port{
+		MinBackoff:  DefaultMinBackoff,
+		MaxBackoff:  DefaultMaxBackoff,
+		identity:    *id,
+		changed:     make(chan struct{}),
+		done:        make(chan struct{}),
+		mode:        *mode,
+		readTimeout: serial.NoTimeout,
+	}
+}
+
+// Open opens the port for the first time. It fails if the device is not
+// found or can't be opened.
+func (p *Port) Open() error {
+	port, name, err := p.connect()
+	if err != nil {
+		return err
+	}
+	return p.install(port, name)
+}
+
+// Name returns the name of the port connected, or the last one if the
+// port is disconnected.
+func (p *Port) Name() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.name
+}
+
+// connect finds and opens the port of the device.
+func (p *Port) connect() (serial.Port, string, error) {
+	name := p.identity.Path
+	if name == "" {
+		enumerate := p.Enumerate
+		if enumerate == nil {
+			enumerate = enumerator.GetDetailedPortsList
+		}
+		ports, err := enumerate()
+		if err != nil {
+			return nil, "", err
+		}
+		for _, port := range ports {
+			if p.identity.match(port) {
+				name = port.Name
+				break
+			}
+		}
+		if name == "" {
+			return nil, "", ErrNotFound
+		}
+	}
+
+	open := p.OpenPort
+	if open == nil {
+		open = serial.Open
+	}
+	p.mu.Lock()
+	mode := p.mode
+	p.mu.Unlock()
+	port, err := open(name, &mode)
+	if err != nil {
+		return nil, "", err
+	}
+	return port, name, nil
+}
+
+// install restores the settings on a port opened and makes it the current
+// one.
+func (p *Port) install(port serial.Port, name string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		port.Close()
+		return ErrClosed
+	}
+	err := port.SetMode(&p.mode)
+	if err == nil {
+		err = setReadTimeout(port, p.readTimeout)
+	}
+	if err == nil && p.dtr != nil {
+		err = port.SetDTR(*p.dtr)
+	}
+	if err == nil && p.rts != nil {
+		err = port.SetRTS(*p.rts)
+	}
+	if err != nil {
+		port.Close()
+		return err
+	}
+	p.port = port
+	p.name = name
+	p.setState(Connected, nil)
+	return nil
+}
+
+// setState notifies a change of state.
+func (p *Port) setState(state State, err error) {
+	close(p.changed)
+	p.changed = make(chan struct{})
+	if p.Changes == nil {
+		return
+	}
+	select {
+	case p.Changes <- StateChange{State: state, Name: p.name, Err: err}:
+	default:
+	}
+}
+
+// fail handles the failure of an operation on port, starting the
+// reconnection if it's still the current port.
+func (p *Port) fail(port serial.Port, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.port != port || p.closed {
+		return
+	}
+	port.Close()
+	p.port = nil
+	p.setState(Disconnected, err)
+	go p.reconnect()
+}
+
+// reconnect reopens the port until it succeeds or the port is closed.
+func (p *Port) reconnect() {
+	backoff := p.MinBackoff
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-time.After(backoff):
+		}
+		port, name, err := p.connect()
+		if err == nil {
+			err = p.install(port, name)
+		}
+		if err == nil || err == ErrClosed {
+			return
+		}
+		backoff *= 2
+		if backoff > p.MaxBackoff {
+			backoff = p.MaxBackoff
+		}
+		if backoff < minBackoff {
+			backoff = minBackoff
+		}
+	}
+}
+
+// current returns the port connected. If the port is disconnected it
+// waits according to the policy, until the deadline if not zero: if the
+// deadline expires it returns a nil port and no error.
+func (p *Port) current(deadline time.Time) (serial.Port, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for {
+		if p.closed {
+			return nil, ErrClosed
+		}
+		if p.port != nil {
+			return p.port, nil
+		}
+		if p.Policy == Fail {
+			return nil, ErrDisconnected
+		}
+
+		changed := p.changed
+		p.mu.Unlock()
+		expired := false
+		if deadline.IsZero() {
+			<-changed
+		} else {
+			timer := time.NewTimer(time.Until(deadline))
+			select {
+			case <-changed:
+			case <-timer.C:
+				expired = true
+			}
+			timer.Stop()
+		}
+		p.mu.Lock()
+		if expired && p.port == nil && !p.closed {
+			return nil, nil
+		}
+	}
+}
+
+// check returns the error of a setting on a disconnected or closed port.
+func (p *Port) check() error {
+	if p.closed {
+		return ErrClosed
+	}
+	if p.port == nil && p.Policy == Fail {
+		return ErrDisconnected
+	}
+	return nil
+}
+
+func (p *Port) isClosed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.closed
+}
+
+// Read reads from the port. Only a serial.ErrPortDisconnected error starts
+// a reconnection, the other errors are returned.
+func (p *Port) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	timeout := p.readTimeout
+	p.mu.Unlock()
+	var deadline time.Time
+	if timeout != serial.NoTimeout {
+		deadline = time.Now().Add(timeout)
+	}
+
+	for {
+		port, err := p.current(deadline)
+		if err != nil {
+			return 0, err
+		}
+		if port == nil {
+			// Read timeout
+			return 0, nil
+		}
+		n, err := port.Read(b)
+		if err == nil {
+			return n, nil
+		}
+		if p.isClosed() {
+			return 0, ErrClosed
+		}
+		if !errors.Is(err, serial.ErrPortDisconnected) {
+			return n, err
+		}
+		p.fail(port, err)
+	}
+}
+
+// Write writes to the port. Only a serial.ErrPortDisconnected error starts
+// a reconnection: with the Block policy the data not written before the
+// disconnection is written after the port is reopened.
+func (p *Port) Write(b []byte) (int, error) {
+	written := 0
+	for {
+		port, err := p.current(time.Time{})
+		if err != nil {
+			return written, err
+		}
+		n, err := port.Write(b[written:])
+		written += n
+		if err == nil {
+			return written, nil
+		}
+		if p.isClosed() {
+			return written, ErrClosed
+		}
+		if !errors.Is(err, serial.ErrPortDisconnected) {
+			return written, err
+		}
+		p.fail(port, err)
+	}
+}
+
+// do runs an operation on the port connected.
+func (p *Port) do(op func(port serial.Port) error) error {
+	port, err := p.current(time.Time{})
+	if err != nil {
+		return err
+	}
+	return op(port)
+}
+
+// SetMode sets the mode of the port, that is restored when it's reopened.
+func (p *Port) SetMode(mode *serial.Mode) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.check(); err != nil {
+		return err
+	}
+	if p.port != nil {
+		if err := p.port.SetMode(mode); err != nil {
+			return err
+		}
+	}
+	p.mode = *mode
+	return nil
+}
+
+// SetDTR sets the DTR state, that is restored when the port is reopened.
+func (p *Port) SetDTR(dtr bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.check(); err != nil {
+		return err
+	}
+	if p.port != nil {
+		if err := p.port.SetDTR(dtr); err != nil {
+			return err
+		}
+	}
+	p.dtr = &dtr
+	return nil
+}
+
+// SetRTS sets the RTS state, that is restored when the port is reopened.
+func (p *Port) SetRTS(rts bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.check(); err != nil {
+		return err
+	}
+	if p.port != nil {
+		if err := p.port.SetRTS(rts); err != nil {
+			return err
+		}
+	}
+	p.rts = &rts
+	return nil
+}
+
+// SetReadTimeout sets the read timeout, that is restored when the port is
+// reopened.
+func (p *Port) SetReadTimeout(timeout time.Duration) error {
+	if timeout < 0 && timeout != serial.NoTimeout {
+		return ErrInvalidTimeout
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.check(); err != nil {
+		return err
+	}
+	if p.port != nil {
+		if err := setReadTimeout(p.port, timeout); err != nil {
+			return err
+		}
+	}
+	p.readTimeout = timeout
+	return nil
+}
+
+// setReadTimeout sets the read timeout of port, if it implements
+// serial.ReadTimeouter. Otherwise only NoTimeout is supported.
+func setReadTimeout(port serial.Port, timeout time.Duration) error {
+	if timeouter, ok := port.(serial.ReadTimeouter); ok {
+		return timeouter.SetReadTimeout(timeout)
+	}
+	if timeout != serial.NoTimeout {
+		return serial.ErrFunctionNotImplemented
+	}
+	return nil
+}
+
+// Break sends a break on the port connected, if it implements
+// serial.Breaker.
+func (p *Port) Break(d time.Duration) error {
+	return p.do(func(port serial.Port) error {
+		breaker, ok := port.(serial.Breaker)
+		if !ok {
+			return serial.ErrFunctionNotImplemented
+		}
+		return breaker.Break(d)
+	})
+}
+
+// ResetInputBuffer resets the input buffer of the port connected.
+func (p *Port) ResetInputBuffer() error {
+	return p.do(func(port serial.Port) error { return port.ResetInputBuffer() })
+}
+
+// ResetOutputBuffer resets the output buffer of the port connected.
+func (p *Port) ResetOutputBuffer() error {
+	return p.do(func(port serial.Port) error { return port.ResetOutputBuffer() })
+}
+
+// GetModemStatusBits returns the modem status of the port connected.
+func (p *Port) GetModemStatusBits() (*serial.ModemStatusBits, error) {
+	var bits *serial.ModemStatusBits
+	err := p.do(func(port serial.Port) error {
+		var err error
+		bits, err = port.GetModemStatusBits()
+		return err
+	})
+	return bits, err
+}
+
+// Close closes the port and stops the reconnection.
+func (p *Port) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	close(p.done)
+	var err error
+	if p.port != nil {
+		err = p.port.Close()
+		p.port = nil
+	}
+	p.setState(Closed, nil)
+	return err
+}
@@ -0,0 +1,280 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package reconnect
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.bug.st/serial"
+	"go.bug.st/serial/enumerator"
+	"go.bug.st/serial/internal/ptytest"
+)
+
+// device is a fake USB adapter backed by a pseudo-terminal, that can be
+// unplugged and plugged again.
+type device struct {
+	t      *testing.T
+	mu     sync.Mutex
+	master *os.File
+	path   string
+	dtr    []bool
+}
+
+func (d *device) plug() {
+	master, path, err := ptytest.Open()
+	require.NoError(d.t, err)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.master, d.path = master, path
+}
+
+func (d *device) unplug() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.master.Close()
+	d.path = ""
+}
+
+func (d *device) enumerate() ([]*enumerator.PortDetails, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ports := []*enumerator.PortDetails{{Name: "/dev/ttyS0"}}
+	if d.path != "" {
+		ports = append(ports, &enumerator.PortDetails{Name: d.path, IsUSB: true, VID: "0403", PID: "6001", SerialNumber: "A9CRTSEL"})
+	}
+	return ports, nil
+}
+
+// dtrPort records the DTR changes, that a pseudo-terminal doesn't support.
+type dtrPort struct {
+	serial.Port
+	device *device
+}
+
+func (p *dtrPort) SetDTR(dtr bool) error {
+	p.device.mu.Lock()
+	defer p.device.mu.Unlock()
+	p.device.dtr = append(p.device.dtr, dtr)
+	return nil
+}
+
+func (p *dtrPort) SetReadTimeout(t time.Duration) error {
+	return p.Port.(serial.ReadTimeouter).SetReadTimeout(t)
+}
+
+func (d *device) open(name string, mode *serial.Mode) (serial.Port, error) {
+	port, err := serial.Open(name, mode)
+	if err != nil {
+		return nil, err
+	}
+	return &dtrPort{Port: port, device: d}, nil
+}
+
+func (d *device) send(data string) {
+	d.mu.Lock()
+	master := d.master
+	d.mu.Unlock()
+	_, err := master.Write([]byte(data))
+	require.NoError(d.t, err)
+}
+
+func (d *device) receive(n int) string {
+	d.mu.Lock()
+	master := d.master
+	d.mu.Unlock()
+	buf := make([]byte, n)
+	_, err := io.ReadFull(master, buf)
+	require.NoError(d.t, err)
+	return string(buf)
+}
+
+func newTestPort(t *testing.T, d *device, changes chan StateChange) *Port {
+	port := New(&Identity{VID: "0403", PID: "6001", SerialNumber: "A9CRTSEL"}, &serial.Mode{BaudRate: 9600})
+	port.MinBackoff = 10 * time.Millisecond
+	port.MaxBackoff = 50 * time.Millisecond
+	port.Enumerate = d.enumerate
+	port.OpenPort = d.open
+	port.Changes = changes
+	return port
+}
+
+func TestReconnect(t *testing.T) {
+	d := &device{t: t}
+	changes := make(chan StateChange, 10)
+	port := newTestPort(t, d, changes)
+	require.Equal(t, ErrNotFound, port.Open())
+
+	d.plug()
+	require.NoError(t, port.Open())
+	defer port.Close()
+	change := <-changes
+	require.Equal(t, Connected, change.State)
+	require.Equal(t, d.path, change.Name)
+	require.NoError(t, port.SetDTR(true))
+
+	d.send("hello")
+	buf := make([]byte, 16)
+	n, err := port.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(buf[:n]))
+
+	// A read blocked during the disconnection continues on the new port
+	read := make(chan string)
+	go func() {
+		n, err := port.Read(buf)
+		require.NoError(t, err)
+		read <- string(buf[:n])
+	}()
+	time.Sleep(20 * time.Millisecond)
+	d.unplug()
+	change = <-changes
+	require.Equal(t, Disconnected, change.State)
+	require.Error(t, change.Err)
+
+	// The settings are stored while the port is disconnected
+	mode := &serial.Mode{BaudRate: 19200}
+	require.NoError(t, port.SetMode(mode))
+	require.NoError(t, port.SetDTR(false))
+
+	time.Sleep(100 * time.Millisecond)
+	d.plug()
+	change = <-changes
+	require.Equal(t, Connected, change.State)
+	require.Equal(t, d.path, port.Name())
+	d.send("again")
+	require.Equal(t, "again", <-read)
+
+	_, err = port.Write([]byte("ping"))
+	require.NoError(t, err)
+	require.Equal(t, "ping", d.receive(4))
+	require.Equal(t, []bool{true, false}, d.dtr)
+
+	require.NoError(t, port.Close())
+	require.Equal(t, Closed, (<-changes).State)
+	_, err = port.Read(buf)
+	require.Equal(t, ErrClosed, err)
+	require.True(t, errors.Is(err, serial.ErrPortClosed))
+}
+
+func TestReconnectFailPolicy(t *testing.T) {
+	d := &device{t: t}
+	d.plug()
+	changes := make(chan StateChange, 10)
+	port := newTestPort(t, d, changes)
+	port.Policy = Fail
+	require.NoError(t, port.Open())
+	defer port.Close()
+	<-changes
+
+	d.unplug()
+	_, err := port.Read(make([]byte, 16))
+	require.Equal(t, ErrDisconnected, err)
+	require.Equal(t, Disconnected, (<-changes).State)
+	_, err = port.Write([]byte("ping"))
+	require.Equal(t, ErrDisconnected, err)
+	require.Equal(t, ErrDisconnected, port.SetDTR(true))
+
+	d.plug()
+	require.Equal(t, Connected, (<-changes).State)
+	_, err = port.Write([]byte("ping"))
+	require.NoError(t, err)
+	require.Equal(t, "ping", d.receive(4))
+}
+
+func TestReconnectReadTimeout(t *testing.T) {
+	d := &device{t: t}
+	d.plug()
+	changes := make(chan StateChange, 10)
+	port := newTestPort(t, d, changes)
+	require.NoError(t, port.Open())
+	<-changes
+	require.Equal(t, ErrInvalidTimeout, port.SetReadTimeout(-2))
+	require.NoError(t, port.SetReadTimeout(50*time.Millisecond))
+
+	// The read timeout is respected while waiting for the reconnection
+	d.unplug()
+	start := time.Now()
+	n, err := port.Read(make([]byte, 16))
+	require.NoError(t, err)
+	require.Equal(t, 0, n)
+	require.True(t, time.Since(start) < time.Second)
+	require.Equal(t, Disconnected, (<-changes).State)
+
+	// Close interrupts the operations waiting for the reconnection
+	write := make(chan error)
+	go func() {
+		_, err := port.Write([]byte("ping"))
+		write <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	require.NoError(t, port.Close())
+	require.Equal(t, ErrClosed, <-write)
+}
+
+// failingPort fails the reads with err.
+type failingPort struct {
+	serial.Port
+	err error
+}
+
+func (p *failingPort) Read(b []byte) (int, error) {
+	return 0, p.err
+}
+
+func TestReadErrorWithoutDisconnection(t *testing.T) {
+	d := &device{t: t}
+	d.plug()
+	changes := make(chan StateChange, 10)
+	port := newTestPort(t, d, changes)
+	parity := errors.New("parity error")
+	port.OpenPort = func(name string, mode *serial.Mode) (serial.Port, error) {
+		p, err := d.open(name, mode)
+		if err != nil {
+			return nil, err
+		}
+		return &failingPort{Port: p, err: parity}, nil
+	}
+	require.NoError(t, port.Open())
+	defer port.Close()
+	require.Equal(t, Connected, (<-changes).State)
+
+	_, err := port.Read(make([]byte, 16))
+	require.Equal(t, parity, err)
+	select {
+	case change := <-changes:
+		t.Fatal("unexpected change of state:", change.State)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestReconnectZeroBackoff(t *testing.T) {
+	d := &device{t: t}
+	d.plug()
+	port := newTestPort(t, d, nil)
+	port.MinBackoff = 0
+	port.MaxBackoff = 0
+	var attempts int32
+	port.Enumerate = func() ([]*enumerator.PortDetails, error) {
+		atomic.AddInt32(&attempts, 1)
+		return d.enumerate()
+	}
+	require.NoError(t, port.Open())
+	defer port.Close()
+	require.NoError(t, port.SetReadTimeout(100*time.Millisecond))
+
+	d.unplug()
+	_, err := port.Read(make([]byte, 16))
+	require.NoError(t, err)
+	require.True(t, atomic.LoadInt32(&attempts) < 50, "attempts: %d", atomic.LoadInt32(&attempts))
+}
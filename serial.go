@@ -6,6 +6,11 @@
 
 package serial
 
+import (
+	"os"
+	"time"
+)
+
 //go:generate go run $GOROOT/src/syscall/mksyscall_windows.go -output zsyscall_windows.go syscall_windows.go
 
 // Port is the interface for a serial Port
@@ -17,7 +22,9 @@ type Port interface {
 	// buffer. The function returns the number of bytes read.
 	//
 	// The Read function blocks until (at least) one byte is received from
-	// the serial port or an error occurs.
+	// the serial port, the read timeout expires or an error occurs. If the
+	// timeout expires before any byte is received Read returns 0 and no error.
+	// The read timeout is set through the ReadTimeouter interface.
 	Read(p []byte) (n int, err error)
 
 	// Send the content of the data byte array to the serial port.
@@ -44,6 +51,37 @@ type Port interface {
 	Close() error
 }
 
+// ReadTimeouter is implemented by the ports whose Read can time out. The
+// ports returned by Open implement it.
+type ReadTimeouter interface {
+	// SetReadTimeout sets the timeout for the Read operation or use
+	// serial.NoTimeout to disable the read timeout (the default)
+	SetReadTimeout(t time.Duration) error
+}
+
+// setReadTimeout sets the read timeout of port. The ports that don't
+// implement ReadTimeouter only support NoTimeout.
+func setReadTimeout(port Port, t time.Duration) error {
+	if p, ok := port.(ReadTimeouter); ok {
+		return p.SetReadTimeout(t)
+	}
+	if t != NoTimeout {
+		return &PortError{code: FunctionNotImplemented}
+	}
+	return nil
+}
+
+// Breaker is implemented by the ports that can send a break (a continuous
+// space condition on the line). The ports returned by Open implement it.
+type Breaker interface {
+	// Break sends a break for the given duration
+	Break(d time.Duration) error
+}
+
+// NoTimeout should be used as a parameter to SetReadTimeout to disable
+// the read timeout.
+const NoTimeout time.Duration = -1
+
 // ModemStatusBits contains all the modem status bits for a serial port (CTS, DSR, etc...).
 // It can be retrieved with the Port.GetModemStatusBits() method.
 type ModemStatusBits struct {
@@ -69,6 +107,8 @@ type Mode struct {
 	DataBits int      // Size of the character (must be 5, 6, 7 or 8)
 	Parity   Parity   // Parity (see Parity type for more info)
 	StopBits StopBits // Stop bits (see StopBits type for more info)
+
+	FlowControl FlowControl // Flow control (see FlowControl type for more info)
 }
 
 // Parity describes a serial port parity setting
@@ -99,6 +139,18 @@ const (
 	TwoStopBits
 )
 
+// FlowControl describes a serial port flow control setting
+type FlowControl int
+
+const (
+	// NoFlowControl disable flow control (default)
+	NoFlowControl FlowControl = iota
+	// HardwareFlowControl enable RTS/CTS hardware flow control
+	HardwareFlowControl
+	// SoftwareFlowControl enable XON/XOFF software flow control
+	SoftwareFlowControl
+)
+
 // PortError is a platform independent error type for serial ports
 type PortError struct {
 	code     PortErrorCode
@@ -131,6 +183,41 @@ const (
 	PortClosed
 	// FunctionNotImplemented the requested function is not implemented
 	FunctionNotImplemented
+	// InvalidFlowControl the selected flow control is not valid or not supported
+	InvalidFlowControl
+	// InvalidTimeoutValue the timeout value is not valid or not supported
+	InvalidTimeoutValue
+	// ReadTimeout the timeout expired before the requested data was received
+	ReadTimeout
+	// BaudRateNotDetected no data was received with any of the candidate modes
+	BaudRateNotDetected
+	// PortDisconnected the device has been removed or has hung up
+	PortDisconnected
+)
+
+// Errors with each PortErrorCode, that match with errors.Is any PortError
+// with the same code:
+//
+//	if errors.Is(err, serial.ErrPortBusy) {
+//		...
+//	}
+var (
+	ErrPortBusy               = &PortError{code: PortBusy}
+	ErrPortNotFound           = &PortError{code: PortNotFound}
+	ErrInvalidSerialPort      = &PortError{code: InvalidSerialPort}
+	ErrPermissionDenied       = &PortError{code: PermissionDenied}
+	ErrInvalidSpeed           = &PortError{code: InvalidSpeed}
+	ErrInvalidDataBits        = &PortError{code: InvalidDataBits}
+	ErrInvalidParity          = &PortError{code: InvalidParity}
+	ErrInvalidStopBits        = &PortError{code: InvalidStopBits}
+	ErrEnumeratingPorts       = &PortError{code: ErrorEnumeratingPorts}
+	ErrPortClosed             = &PortError{code: PortClosed}
+	ErrFunctionNotImplemented = &PortError{code: FunctionNotImplemented}
+	ErrInvalidFlowControl     = &PortError{code: InvalidFlowControl}
+	ErrInvalidTimeoutValue    = &PortError{code: InvalidTimeoutValue}
+	ErrReadTimeout            = &PortError{code: ReadTimeout}
+	ErrBaudRateNotDetected    = &PortError{code: BaudRateNotDetected}
+	ErrPortDisconnected       = &PortError{code: PortDisconnected}
 )
 
 // EncodedErrorString returns a string explaining the error code
@@ -158,6 +245,16 @@ func (e PortError) EncodedErrorString() string {
 		return "Port has been closed"
 	case FunctionNotImplemented:
 		return "Function not implemented"
+	case InvalidFlowControl:
+		return "Port flow control invalid or not supported"
+	case InvalidTimeoutValue:
+		return "Timeout value invalid or not supported"
+	case ReadTimeout:
+		return "Read timeout expired"
+	case BaudRateNotDetected:
+		return "Baud rate not detected"
+	case PortDisconnected:
+		return "Port has been disconnected"
 	default:
 		return "Other error"
 	}
@@ -175,3 +272,36 @@ func (e PortError) Error() string {
 func (e PortError) Code() PortErrorCode {
 	return e.code
 }
+
+// wrapError returns err if it's a PortError, otherwise a PortError with
+// the given code caused by err.
+func wrapError(code PortErrorCode, err error) error {
+	if _, ok := err.(*PortError); ok {
+		return err
+	}
+	return &PortError{code: code, causedBy: err}
+}
+
+// Unwrap returns the underlying error that caused the error, if any
+func (e PortError) Unwrap() error {
+	return e.causedBy
+}
+
+// Is reports whether target is a PortError without a cause and with the
+// same code, like the Err* variables, or the error of the os package
+// corresponding to the code: os.ErrPermission, os.ErrNotExist or
+// os.ErrClosed.
+func (e PortError) Is(target error) bool {
+	if t, ok := target.(*PortError); ok {
+		return t.causedBy == nil && t.code == e.code
+	}
+	switch e.code {
+	case PermissionDenied:
+		return target == os.ErrPermission
+	case PortNotFound:
+		return target == os.ErrNotExist
+	case PortClosed:
+		return target == os.ErrClosed
+	}
+	return false
+}
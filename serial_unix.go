@@ -9,23 +9,46 @@
 package serial
 
 import (
+	"errors"
+	"io"
 	"io/ioutil"
+	"os"
 	"regexp"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
+	"time"
 	"unsafe"
 
 	"go.bug.st/serial/unixutils"
 	"golang.org/x/sys/unix"
 )
 
+// unixPort reads and writes the port through an os.File: the descriptor is
+// non-blocking, so the pending operations wait in the runtime poller, that
+// implements the read timeout and is woken up by Close. The os.File also
+// keeps the descriptor open until the operations in progress return, so
+// that its number can't be reused by another file meanwhile.
+//
+// If the poller rejects the descriptor, it's put in blocking mode and the
+// reads wait in a select on the descriptor and on closeSignal, that is
+// written by Close. In this mode Close doesn't interrupt the writes.
 type unixPort struct {
-	handle int
+	readTimeout int64 // a time.Duration accessed atomically, first to be aligned
+	file        *os.File
 
 	closeLock   sync.RWMutex
-	closeSignal *unixutils.Pipe
+	closeSignal *unixutils.Pipe // nil if the descriptor is in the poller
 	opened      uint32
+
+	// The deadlines of a Conn, kept apart from the read timeout: they're
+	// set on the file only during connRead and connWrite
+	deadlineLock      sync.Mutex
+	connReadDeadline  time.Time
+	connWriteDeadline time.Time
+	connReading       bool
+	connWriting       bool
 }
 
 func (port *unixPort) Close() error {
@@ -33,24 +56,22 @@ func (port *unixPort) Close() error {
 		return nil
 	}
 
-	// Close port
+	// Close port, this interrupts the pending reads and writes (if any)
 	port.releaseExclusiveAccess()
-	if err := unix.Close(port.handle); err != nil {
-		return err
+	if err := port.file.Close(); err != nil {
+		return errno(err)
 	}
 
 	if port.closeSignal != nil {
 		// Send close signal to all pending reads (if any)
 		port.closeSignal.Write([]byte{0})
+	}
 
-		// Wait for all readers to complete
-		port.closeLock.Lock()
-		defer port.closeLock.Unlock()
-
-		// Close signaling pipe
-		if err := port.closeSignal.Close(); err != nil {
-			return err
-		}
+	// Wait for all readers and writers to complete
+	port.closeLock.Lock()
+	defer port.closeLock.Unlock()
+	if port.closeSignal != nil {
+		return port.closeSignal.Close()
 	}
 	return nil
 }
@@ -62,43 +83,259 @@ func (port *unixPort) Read(p []byte) (int, error) {
 		return 0, &PortError{code: PortClosed}
 	}
 
-	fds := unixutils.NewFDSet(port.handle, port.closeSignal.ReadFD())
-	for {
-		res, err := unixutils.Select(fds, nil, fds, -1)
-		if err == unix.EINTR {
-			continue
-		}
-		if err != nil {
-			return 0, err
-		}
-		if res.IsReadable(port.closeSignal.ReadFD()) {
-			return 0, &PortError{code: PortClosed}
-		}
-		n, err := unix.Read(port.handle, p)
-		if err == unix.EINTR {
-			continue
-		}
-		if n < 0 { // Do not return -1 unix errors
-			n = 0
-		}
-		return n, err
+	timeout := time.Duration(atomic.LoadInt64(&port.readTimeout))
+	if port.closeSignal != nil {
+		return port.readSelect(p, timeout)
+	}
+	if timeout == 0 {
+		return port.readNonblock(p)
+	}
+	var deadline time.Time
+	if timeout != NoTimeout {
+		deadline = time.Now().Add(timeout)
+	}
+	if err := port.file.SetReadDeadline(deadline); err != nil {
+		return 0, errno(err)
 	}
+	n, err := port.file.Read(p)
+	if os.IsTimeout(err) {
+		return 0, nil
+	}
+	return n, port.ioError(err)
 }
 
-func (port *unixPort) Write(p []byte) (n int, err error) {
-	n, err = unix.Write(port.handle, p)
+// readNonblock reads the data already received, without waiting.
+func (port *unixPort) readNonblock(p []byte) (int, error) {
+	// Clear the deadline of a previous read, or the poller fails at once
+	if err := port.file.SetReadDeadline(time.Time{}); err != nil {
+		return 0, errno(err)
+	}
+	conn, err := port.file.SyscallConn()
+	if err != nil {
+		return 0, errno(err)
+	}
+	var n int
+	var rerr error
+	err = conn.Read(func(fd uintptr) bool {
+		n, rerr = unix.Read(int(fd), p)
+		return true
+	})
+	if err == nil {
+		err = rerr
+	}
+	if err == unix.EAGAIN {
+		return 0, nil
+	}
 	if n < 0 { // Do not return -1 unix errors
 		n = 0
 	}
-	return
+	if n == 0 && err == nil {
+		err = io.EOF
+	}
+	return n, port.ioError(err)
+}
+
+// readSelect reads the descriptor in blocking mode, after waiting for the
+// data with a select until the timeout expires or the port is closed.
+func (port *unixPort) readSelect(p []byte, timeout time.Duration) (int, error) {
+	conn, err := port.file.SyscallConn()
+	if err != nil {
+		return 0, errno(err)
+	}
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	var n int
+	var rerr error
+	err = conn.Control(func(fd uintptr) {
+		fds := unixutils.NewFDSet(int(fd), port.closeSignal.ReadFD())
+		for {
+			if !deadline.IsZero() {
+				if timeout = time.Until(deadline); timeout < 0 {
+					timeout = 0
+				}
+			}
+			res, err := unixutils.Select(fds, nil, fds, timeout)
+			if err == unix.EINTR {
+				continue
+			}
+			if err != nil {
+				rerr = err
+				return
+			}
+			if res.IsReadable(port.closeSignal.ReadFD()) {
+				rerr = &PortError{code: PortClosed}
+				return
+			}
+			if !res.IsReadable(int(fd)) && !res.IsError(int(fd)) {
+				return // timeout
+			}
+			n, rerr = unix.Read(int(fd), p)
+			if rerr == unix.EINTR {
+				continue
+			}
+			if n < 0 { // Do not return -1 unix errors
+				n = 0
+			}
+			if n == 0 && rerr == nil {
+				rerr = io.EOF
+			}
+			return
+		}
+	})
+	if err == nil {
+		err = rerr
+	}
+	return n, port.ioError(err)
+}
+
+// ioError maps the errors of a read or a write to a PortError.
+func (port *unixPort) ioError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, os.ErrClosed) || atomic.LoadUint32(&port.opened) != 1:
+		return &PortError{code: PortClosed}
+	case err == io.EOF:
+		// The port is readable, so no data means a hangup
+		return &PortError{code: PortDisconnected}
+	case isDisconnection(errno(err)):
+		return &PortError{code: PortDisconnected, causedBy: errno(err)}
+	}
+	return errno(err)
+}
+
+// errno returns the system error wrapped in the errors of an os.File.
+func errno(err error) error {
+	if perr, ok := err.(*os.PathError); ok {
+		return perr.Err
+	}
+	return err
+}
+
+// isDisconnection returns true if err is returned by the operations on a
+// device that has been removed or has hung up.
+func isDisconnection(err error) bool {
+	return err == unix.EIO || err == unix.ENXIO || err == unix.ENODEV
+}
+
+func (port *unixPort) SetReadTimeout(timeout time.Duration) error {
+	if timeout < 0 && timeout != NoTimeout {
+		return &PortError{code: InvalidTimeoutValue}
+	}
+	atomic.StoreInt64(&port.readTimeout, int64(timeout))
+	return nil
+}
+
+func (port *unixPort) getReadTimeout() time.Duration {
+	return time.Duration(atomic.LoadInt64(&port.readTimeout))
+}
+
+func (port *unixPort) Write(p []byte) (int, error) {
+	if port.closeSignal == nil {
+		// Without the poller Close can't interrupt the write: don't wait
+		// for it
+		port.closeLock.RLock()
+		defer port.closeLock.RUnlock()
+	}
+	if atomic.LoadUint32(&port.opened) != 1 {
+		return 0, &PortError{code: PortClosed}
+	}
+
+	n, err := port.file.Write(p)
+	return n, port.ioError(err)
+}
+
+// connRead reads p until the read deadline of a Conn, the read timeout is
+// ignored.
+func (port *unixPort) connRead(p []byte) (int, error) {
+	port.closeLock.RLock()
+	defer port.closeLock.RUnlock()
+	if atomic.LoadUint32(&port.opened) != 1 {
+		return 0, &PortError{code: PortClosed}
+	}
+
+	port.deadlineLock.Lock()
+	err := port.file.SetReadDeadline(port.connReadDeadline)
+	port.connReading = err == nil
+	port.deadlineLock.Unlock()
+	if err != nil {
+		return 0, port.ioError(err)
+	}
+	n, err := port.file.Read(p)
+	port.deadlineLock.Lock()
+	port.connReading = false
+	port.deadlineLock.Unlock()
+	return n, port.ioError(err)
+}
+
+// connWrite writes p until the write deadline of a Conn.
+func (port *unixPort) connWrite(p []byte) (int, error) {
+	port.closeLock.RLock()
+	defer port.closeLock.RUnlock()
+	if atomic.LoadUint32(&port.opened) != 1 {
+		return 0, &PortError{code: PortClosed}
+	}
+
+	port.deadlineLock.Lock()
+	err := port.file.SetWriteDeadline(port.connWriteDeadline)
+	port.connWriting = err == nil
+	port.deadlineLock.Unlock()
+	if err != nil {
+		return 0, port.ioError(err)
+	}
+	n, err := port.file.Write(p)
+	port.deadlineLock.Lock()
+	port.connWriting = false
+	// Write doesn't set a deadline: clear it for the next ones
+	port.file.SetWriteDeadline(time.Time{})
+	port.deadlineLock.Unlock()
+	return n, port.ioError(err)
+}
+
+// setConnReadDeadline sets the read deadline of a Conn, that applies at
+// once to the connRead in progress, if any.
+func (port *unixPort) setConnReadDeadline(t time.Time) error {
+	if port.closeSignal != nil {
+		return &PortError{code: FunctionNotImplemented}
+	}
+	port.deadlineLock.Lock()
+	defer port.deadlineLock.Unlock()
+	port.connReadDeadline = t
+	if !port.connReading {
+		return nil
+	}
+	return port.ioError(port.file.SetReadDeadline(t))
+}
+
+// setConnWriteDeadline sets the write deadline of a Conn, that applies at
+// once to the connWrite in progress, if any.
+func (port *unixPort) setConnWriteDeadline(t time.Time) error {
+	if port.closeSignal != nil {
+		return &PortError{code: FunctionNotImplemented}
+	}
+	port.deadlineLock.Lock()
+	defer port.deadlineLock.Unlock()
+	port.connWriteDeadline = t
+	if !port.connWriting {
+		return nil
+	}
+	return port.ioError(port.file.SetWriteDeadline(t))
+}
+
+// SyscallConn returns a raw connection to the descriptor of the port, it
+// implements the syscall.Conn interface.
+func (port *unixPort) SyscallConn() (syscall.RawConn, error) {
+	return port.file.SyscallConn()
 }
 
 func (port *unixPort) ResetInputBuffer() error {
-	return ioctl(port.handle, ioctlTcflsh, unix.TCIFLUSH)
+	return port.ioctlValue(ioctlTcflsh, unix.TCIFLUSH)
 }
 
 func (port *unixPort) ResetOutputBuffer() error {
-	return ioctl(port.handle, ioctlTcflsh, unix.TCOFLUSH)
+	return port.ioctlValue(ioctlTcflsh, unix.TCOFLUSH)
 }
 
 func (port *unixPort) SetMode(mode *Mode) error {
@@ -118,6 +355,9 @@ func (port *unixPort) SetMode(mode *Mode) error {
 	if err := setTermSettingsStopBits(mode.StopBits, settings); err != nil {
 		return err
 	}
+	if err := setTermSettingsFlowControl(mode.FlowControl, settings); err != nil {
+		return err
+	}
 	return port.setTermSettings(settings)
 }
 
@@ -160,64 +400,104 @@ func (port *unixPort) GetModemStatusBits() (*ModemStatusBits, error) {
 	}, nil
 }
 
+func (port *unixPort) Break(d time.Duration) error {
+	if err := port.ioctlValue(unix.TIOCSBRK, 0); err != nil {
+		return err
+	}
+	time.Sleep(d)
+	return port.ioctlValue(unix.TIOCCBRK, 0)
+}
+
 func nativeOpen(portName string, mode *Mode) (*unixPort, error) {
 	h, err := unix.Open(portName, unix.O_RDWR|unix.O_NOCTTY|unix.O_NDELAY, 0)
 	if err != nil {
-		switch err {
-		case unix.EBUSY:
-			return nil, &PortError{code: PortBusy}
-		case unix.EACCES:
-			return nil, &PortError{code: PermissionDenied}
-		}
-		return nil, err
+		return nil, openError(err)
+	}
+	// The descriptor is kept in non-blocking mode, so that os.NewFile
+	// registers it in the runtime poller
+	if disablePoller {
+		unix.SetNonblock(h, false)
 	}
 	port := &unixPort{
-		handle: h,
-		opened: 1,
+		file:        os.NewFile(uintptr(h), portName),
+		readTimeout: int64(NoTimeout),
+		opened:      1,
 	}
 
 	// Setup serial port
-	if port.SetMode(mode) != nil {
+	if err := port.SetMode(mode); err != nil {
 		port.Close()
-		return nil, &PortError{code: InvalidSerialPort}
+		return nil, wrapError(InvalidSerialPort, err)
 	}
 
 	settings, err := port.getTermSettings()
 	if err != nil {
 		port.Close()
-		return nil, &PortError{code: InvalidSerialPort}
+		return nil, &PortError{code: InvalidSerialPort, causedBy: err}
 	}
 
 	// Set raw mode
 	setRawMode(settings)
 
-	// Explicitly disable RTS/CTS flow control
-	setTermSettingsCtsRts(false, settings)
+	// Raw mode clears XON/XOFF: apply the requested flow control again
+	if err := setTermSettingsFlowControl(mode.FlowControl, settings); err != nil {
+		port.Close()
+		return nil, err
+	}
 
-	if port.setTermSettings(settings) != nil {
+	if err := port.setTermSettings(settings); err != nil {
 		port.Close()
-		return nil, &PortError{code: InvalidSerialPort}
+		return nil, &PortError{code: InvalidSerialPort, causedBy: err}
 	}
 
-	unix.SetNonblock(h, false)
+	// Without the poller the reads wait in a select, in blocking mode
+	if port.file.SetDeadline(time.Time{}) != nil {
+		if err := port.openCloseSignal(); err != nil {
+			port.Close()
+			return nil, &PortError{code: InvalidSerialPort, causedBy: err}
+		}
+	}
 
 	port.acquireExclusiveAccess()
 
-	// This pipe is used as a signal to cancel blocking Read
-	pipe := &unixutils.Pipe{}
-	if err := pipe.Open(); err != nil {
-		port.Close()
-		return nil, &PortError{code: InvalidSerialPort, causedBy: err}
+	return port, nil
+}
+
+// disablePoller opens the ports in blocking mode, so that the poller
+// doesn't handle them: it's set by the tests of the fallback.
+var disablePoller = false
+
+// openCloseSignal puts the descriptor in blocking mode and creates the pipe
+// that interrupts the reads on Close.
+func (port *unixPort) openCloseSignal() error {
+	if err := port.control(func(fd int) error { return unix.SetNonblock(fd, false) }); err != nil {
+		return err
 	}
-	port.closeSignal = pipe
+	closeSignal := &unixutils.Pipe{}
+	if err := closeSignal.Open(); err != nil {
+		return err
+	}
+	port.closeSignal = closeSignal
+	return nil
+}
 
-	return port, nil
+// openError maps the errors of open(2) to a PortError.
+func openError(err error) error {
+	switch err {
+	case unix.EBUSY:
+		return &PortError{code: PortBusy, causedBy: err}
+	case unix.EACCES, unix.EPERM:
+		return &PortError{code: PermissionDenied, causedBy: err}
+	case unix.ENOENT, unix.ENXIO, unix.ENODEV:
+		return &PortError{code: PortNotFound, causedBy: err}
+	}
+	return &PortError{code: InvalidSerialPort, causedBy: err}
 }
 
 func nativeGetPortsList() ([]string, error) {
 	files, err := ioutil.ReadDir(devFolder)
 	if err != nil {
-		return nil, err
+		return nil, &PortError{code: ErrorEnumeratingPorts, causedBy: err}
 	}
 
 	ports := make([]string, 0, len(files))
@@ -243,7 +523,7 @@ func nativeGetPortsList() ([]string, error) {
 			port, err := nativeOpen(portName, &Mode{})
 			if err != nil {
 				serr, ok := err.(*PortError)
-				if ok && serr.Code() == InvalidSerialPort {
+				if ok && (serr.Code() == InvalidSerialPort || serr.Code() == PortNotFound) {
 					continue
 				}
 			} else {
@@ -349,6 +629,33 @@ func setTermSettingsCtsRts(enable bool, settings *unix.Termios) {
 	}
 }
 
+func setTermSettingsXonXoff(enable bool, settings *unix.Termios) {
+	if enable {
+		settings.Iflag |= unix.IXON
+		settings.Iflag |= unix.IXOFF
+	} else {
+		settings.Iflag &^= unix.IXON
+		settings.Iflag &^= unix.IXOFF
+	}
+}
+
+func setTermSettingsFlowControl(flow FlowControl, settings *unix.Termios) error {
+	switch flow {
+	case NoFlowControl:
+		setTermSettingsCtsRts(false, settings)
+		setTermSettingsXonXoff(false, settings)
+	case HardwareFlowControl:
+		setTermSettingsCtsRts(true, settings)
+		setTermSettingsXonXoff(false, settings)
+	case SoftwareFlowControl:
+		setTermSettingsCtsRts(false, settings)
+		setTermSettingsXonXoff(true, settings)
+	default:
+		return &PortError{code: InvalidFlowControl}
+	}
+	return nil
+}
+
 func setRawMode(settings *unix.Termios) {
 	// Set local mode
 	settings.Cflag |= unix.CREAD
@@ -391,28 +698,65 @@ func setRawMode(settings *unix.Termios) {
 
 func (port *unixPort) getTermSettings() (*unix.Termios, error) {
 	settings := &unix.Termios{}
-	err := ioctl(port.handle, ioctlTcgetattr, uintptr(unsafe.Pointer(settings)))
+	err := port.ioctl(ioctlTcgetattr, unsafe.Pointer(settings))
 	return settings, err
 }
 
 func (port *unixPort) setTermSettings(settings *unix.Termios) error {
-	return ioctl(port.handle, ioctlTcsetattr, uintptr(unsafe.Pointer(settings)))
+	return port.ioctl(ioctlTcsetattr, unsafe.Pointer(settings))
 }
 
 func (port *unixPort) getModemBitsStatus() (int, error) {
 	var status int
-	err := ioctl(port.handle, unix.TIOCMGET, uintptr(unsafe.Pointer(&status)))
+	err := port.ioctl(unix.TIOCMGET, unsafe.Pointer(&status))
 	return status, err
 }
 
 func (port *unixPort) setModemBitsStatus(status int) error {
-	return ioctl(port.handle, unix.TIOCMSET, uintptr(unsafe.Pointer(&status)))
+	return port.ioctl(unix.TIOCMSET, unsafe.Pointer(&status))
+}
+
+// control runs f on the descriptor of the port, that is kept open until f
+// returns.
+func (port *unixPort) control(f func(fd int) error) error {
+	conn, err := port.file.SyscallConn()
+	if err != nil {
+		return &PortError{code: PortClosed}
+	}
+	var ferr error
+	if err := conn.Control(func(fd uintptr) {
+		ferr = f(int(fd))
+	}); err != nil {
+		// Control fails only if the file is closed
+		return &PortError{code: PortClosed}
+	}
+	return ferr
+}
+
+// ioctl runs an ioctl whose argument points to data.
+func (port *unixPort) ioctl(req uint64, data unsafe.Pointer) error {
+	return port.control(func(fd int) error {
+		// The pointer is converted in the syscall expression, so that data
+		// is kept alive until the call returns
+		_, _, e1 := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), uintptr(req), uintptr(data))
+		if e1 != 0 {
+			return e1
+		}
+		return nil
+	})
+}
+
+// ioctlValue runs an ioctl whose argument is a value.
+func (port *unixPort) ioctlValue(req uint64, arg uintptr) error {
+	return port.control(func(fd int) error {
+		return ioctl(fd, req, arg)
+	})
 }
 
 func (port *unixPort) acquireExclusiveAccess() error {
-	return ioctl(port.handle, unix.TIOCEXCL, 0)
+	return port.ioctlValue(unix.TIOCEXCL, 0)
 }
 
 func (port *unixPort) releaseExclusiveAccess() error {
-	return ioctl(port.handle, unix.TIOCNXCL, 0)
+	return port.ioctlValue(unix.TIOCNXCL, 0)
 }
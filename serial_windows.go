@@ -20,17 +20,20 @@ package serial
 import (
 	"syscall"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type windowsPort struct {
-	mu sync.Mutex
-	handle syscall.Handle
+	readTimeout int64 // a time.Duration accessed atomically, first to be aligned
+	mu          sync.Mutex
+	handle      syscall.Handle
 }
 
 func nativeGetPortsList() ([]string, error) {
 	subKey, err := syscall.UTF16PtrFromString("HARDWARE\\DEVICEMAP\\SERIALCOMM\\")
 	if err != nil {
-		return nil, &PortError{code: ErrorEnumeratingPorts}
+		return nil, &PortError{code: ErrorEnumeratingPorts, causedBy: err}
 	}
 
 	var h syscall.Handle
@@ -38,13 +41,13 @@ func nativeGetPortsList() ([]string, error) {
 		if errno, isErrno := err.(syscall.Errno); isErrno && errno == syscall.ERROR_FILE_NOT_FOUND {
 			return []string{}, nil
 		}
-		return nil, &PortError{code: ErrorEnumeratingPorts}
+		return nil, &PortError{code: ErrorEnumeratingPorts, causedBy: err}
 	}
 	defer syscall.RegCloseKey(h)
 
 	var valuesCount uint32
-	if syscall.RegQueryInfoKey(h, nil, nil, nil, nil, nil, nil, &valuesCount, nil, nil, nil, nil) != nil {
-		return nil, &PortError{code: ErrorEnumeratingPorts}
+	if err := syscall.RegQueryInfoKey(h, nil, nil, nil, nil, nil, nil, &valuesCount, nil, nil, nil, nil); err != nil {
+		return nil, &PortError{code: ErrorEnumeratingPorts, causedBy: err}
 	}
 
 	list := make([]string, valuesCount)
@@ -53,8 +56,8 @@ func nativeGetPortsList() ([]string, error) {
 		dataSize := uint32(len(data))
 		var name [1024]uint16
 		nameSize := uint32(len(name))
-		if regEnumValue(h, uint32(i), &name[0], &nameSize, nil, nil, &data[0], &dataSize) != nil {
-			return nil, &PortError{code: ErrorEnumeratingPorts}
+		if err := regEnumValue(h, uint32(i), &name[0], &nameSize, nil, nil, &data[0], &dataSize); err != nil {
+			return nil, &PortError{code: ErrorEnumeratingPorts, causedBy: err}
 		}
 		list[i] = syscall.UTF16ToString(data[:])
 	}
@@ -70,9 +73,26 @@ func (port *windowsPort) Close() error {
 	if port.handle == 0 {
 		return nil
 	}
+	// Interrupt the pending reads and writes (if any), that closing the
+	// handle doesn't abort
+	syscall.CancelIoEx(port.handle, nil)
 	return syscall.CloseHandle(port.handle)
 }
 
+// ioError maps the error of an overlapped read or write to a PortError if
+// it has been aborted by Close.
+func (port *windowsPort) ioError(err error) error {
+	if err != syscall.ERROR_OPERATION_ABORTED {
+		return err
+	}
+	port.mu.Lock()
+	defer port.mu.Unlock()
+	if port.handle == 0 {
+		return &PortError{code: PortClosed}
+	}
+	return err
+}
+
 func (port *windowsPort) Read(p []byte) (int, error) {
 	var readed uint32
 	params := &dcb{}
@@ -89,7 +109,7 @@ func (port *windowsPort) Read(p []byte) (int, error) {
 		case syscall.ERROR_IO_PENDING:
 			// wait for overlapped I/O to complete
 			if err := getOverlappedResult(port.handle, ev, &readed, true); err != nil {
-				return int(readed), err
+				return int(readed), port.ioError(err)
 			}
 		default:
 			// error happened
@@ -110,11 +130,48 @@ func (port *windowsPort) Read(p []byte) (int, error) {
 		getCommState(port.handle, params)
 		if err := setCommState(port.handle, params); err != nil {
 			port.Close()
-			return 0, err
+			return 0, &PortError{code: PortDisconnected, causedBy: err}
+		}
+
+		if time.Duration(atomic.LoadInt64(&port.readTimeout)) != NoTimeout {
+			// Timeout happened
+			return 0, nil
 		}
 	}
 }
 
+func (port *windowsPort) SetReadTimeout(timeout time.Duration) error {
+	// Without a read timeout, ReadFile returns every second to check if
+	// the port is still alive
+	timeouts := &commTimeouts{
+		ReadIntervalTimeout:         0xFFFFFFFF,
+		ReadTotalTimeoutMultiplier:  0xFFFFFFFF,
+		ReadTotalTimeoutConstant:    1000, // 1 sec
+		WriteTotalTimeoutConstant:   0,
+		WriteTotalTimeoutMultiplier: 0,
+	}
+	if timeout != NoTimeout {
+		ms := int64(timeout / time.Millisecond)
+		if timeout < 0 || ms > 0xFFFFFFFE {
+			return &PortError{code: InvalidTimeoutValue}
+		}
+		if ms == 0 {
+			// Return immediately with the bytes already received
+			timeouts.ReadTotalTimeoutMultiplier = 0
+		}
+		timeouts.ReadTotalTimeoutConstant = uint32(ms)
+	}
+	if err := setCommTimeouts(port.handle, timeouts); err != nil {
+		return &PortError{code: InvalidTimeoutValue, causedBy: err}
+	}
+	atomic.StoreInt64(&port.readTimeout, int64(timeout))
+	return nil
+}
+
+func (port *windowsPort) getReadTimeout() time.Duration {
+	return time.Duration(atomic.LoadInt64(&port.readTimeout))
+}
+
 func (port *windowsPort) Write(p []byte) (int, error) {
 	var writed uint32
 	ev, err := createOverlappedEvent()
@@ -127,7 +184,7 @@ func (port *windowsPort) Write(p []byte) (int, error) {
 		// wait for write to complete
 		err = getOverlappedResult(port.handle, ev, &writed, true)
 	}
-	return int(writed), err
+	return int(writed), port.ioError(err)
 }
 
 const (
@@ -248,6 +305,16 @@ const (
 	commFunctionClrBreak = 9
 )
 
+// errorSharingViolation is returned opening a port already open
+const errorSharingViolation syscall.Errno = 32
+
+// errorInvalidFunction and errorNotSupported are returned by the drivers
+// that don't implement a function
+const (
+	errorInvalidFunction syscall.Errno = 1
+	errorNotSupported    syscall.Errno = 50
+)
+
 const (
 	msCTSOn  = 0x0010
 	msDSROn  = 0x0020
@@ -257,9 +324,9 @@ const (
 
 func (port *windowsPort) SetMode(mode *Mode) error {
 	params := dcb{}
-	if getCommState(port.handle, &params) != nil {
+	if err := getCommState(port.handle, &params); err != nil {
 		port.Close()
-		return &PortError{code: InvalidSerialPort}
+		return &PortError{code: InvalidSerialPort, causedBy: err}
 	}
 	if mode.BaudRate == 0 {
 		params.BaudRate = 9600 // Default to 9600
@@ -273,9 +340,35 @@ func (port *windowsPort) SetMode(mode *Mode) error {
 	}
 	params.StopBits = stopBitsMap[mode.StopBits]
 	params.Parity = parityMap[mode.Parity]
-	if setCommState(port.handle, &params) != nil {
+	if err := setDCBFlowControl(&params, mode.FlowControl); err != nil {
+		return err
+	}
+	if err := setCommState(port.handle, &params); err != nil {
 		port.Close()
-		return &PortError{code: InvalidSerialPort}
+		return &PortError{code: InvalidSerialPort, causedBy: err}
+	}
+	return nil
+}
+
+func setDCBFlowControl(params *dcb, flow FlowControl) error {
+	params.Flags &^= dcbOutXCTSFlow
+	params.Flags &^= dcbOutX
+	params.Flags &^= dcbInX
+	if params.Flags&^dcbRTSControlDisbaleMask == dcbRTSControlHandshake {
+		params.Flags &= dcbRTSControlDisbaleMask
+		params.Flags |= dcbRTSControlEnable
+	}
+	switch flow {
+	case NoFlowControl:
+	case HardwareFlowControl:
+		params.Flags |= dcbOutXCTSFlow
+		params.Flags &= dcbRTSControlDisbaleMask
+		params.Flags |= dcbRTSControlHandshake
+	case SoftwareFlowControl:
+		params.Flags |= dcbOutX
+		params.Flags |= dcbInX
+	default:
+		return &PortError{code: InvalidFlowControl}
 	}
 	return nil
 }
@@ -290,14 +383,14 @@ func (port *windowsPort) SetDTR(dtr bool) error {
 	//
 	// In addition this way the CommState Flags are not updated
 	/*
-		var res bool
+		var err error
 		if dtr {
-			res = escapeCommFunction(port.handle, commFunctionSetDTR)
+			err = escapeCommFunction(port.handle, commFunctionSetDTR)
 		} else {
-			res = escapeCommFunction(port.handle, commFunctionClrDTR)
+			err = escapeCommFunction(port.handle, commFunctionClrDTR)
 		}
-		if !res {
-			return &PortError{}
+		if err != nil {
+			return &PortError{causedBy: err}
 		}
 		return nil
 	*/
@@ -328,14 +421,14 @@ func (port *windowsPort) SetRTS(rts bool) error {
 	// In addition this way the CommState Flags are not updated
 
 	/*
-		var res bool
+		var err error
 		if rts {
-			res = escapeCommFunction(port.handle, commFunctionSetRTS)
+			err = escapeCommFunction(port.handle, commFunctionSetRTS)
 		} else {
-			res = escapeCommFunction(port.handle, commFunctionClrRTS)
+			err = escapeCommFunction(port.handle, commFunctionClrRTS)
 		}
-		if !res {
-			return &PortError{}
+		if err != nil {
+			return &PortError{causedBy: err}
 		}
 		return nil
 	*/
@@ -369,6 +462,26 @@ func (port *windowsPort) GetModemStatusBits() (*ModemStatusBits, error) {
 	}, nil
 }
 
+func (port *windowsPort) Break(d time.Duration) error {
+	if err := escapeCommFunction(port.handle, commFunctionSetBreak); err != nil {
+		return breakError(err)
+	}
+	time.Sleep(d)
+	if err := escapeCommFunction(port.handle, commFunctionClrBreak); err != nil {
+		return breakError(err)
+	}
+	return nil
+}
+
+// breakError maps the errors of EscapeCommFunction to a PortError.
+func breakError(err error) error {
+	switch err {
+	case errorInvalidFunction, errorNotSupported:
+		return wrapError(FunctionNotImplemented, err)
+	}
+	return wrapError(InvalidSerialPort, err)
+}
+
 func createOverlappedEvent() (*syscall.Overlapped, error) {
 	h, err := createEvent(nil, true, false, nil)
 	return &syscall.Overlapped{HEvent: h}, err
@@ -378,7 +491,7 @@ func nativeOpen(portName string, mode *Mode) (*windowsPort, error) {
 	portName = "\\\\.\\" + portName
 	path, err := syscall.UTF16PtrFromString(portName)
 	if err != nil {
-		return nil, err
+		return nil, &PortError{code: InvalidSerialPort, causedBy: err}
 	}
 	handle, err := syscall.CreateFile(
 		path,
@@ -389,28 +502,29 @@ func nativeOpen(portName string, mode *Mode) (*windowsPort, error) {
 		0)
 	if err != nil {
 		switch err {
-		case syscall.ERROR_ACCESS_DENIED:
-			return nil, &PortError{code: PortBusy}
-		case syscall.ERROR_FILE_NOT_FOUND:
-			return nil, &PortError{code: PortNotFound}
+		case syscall.ERROR_ACCESS_DENIED, errorSharingViolation:
+			return nil, &PortError{code: PortBusy, causedBy: err}
+		case syscall.ERROR_FILE_NOT_FOUND, syscall.ERROR_PATH_NOT_FOUND:
+			return nil, &PortError{code: PortNotFound, causedBy: err}
 		}
-		return nil, err
+		return nil, &PortError{code: InvalidSerialPort, causedBy: err}
 	}
 	// Create the serial port
 	port := &windowsPort{
-		handle: handle,
+		handle:      handle,
+		readTimeout: int64(NoTimeout),
 	}
 
 	// Set port parameters
-	if port.SetMode(mode) != nil {
+	if err := port.SetMode(mode); err != nil {
 		port.Close()
-		return nil, &PortError{code: InvalidSerialPort}
+		return nil, wrapError(InvalidSerialPort, err)
 	}
 
 	params := &dcb{}
-	if getCommState(port.handle, params) != nil {
+	if err := getCommState(port.handle, params); err != nil {
 		port.Close()
-		return nil, &PortError{code: InvalidSerialPort}
+		return nil, &PortError{code: InvalidSerialPort, causedBy: err}
 	}
 	params.Flags &= dcbRTSControlDisbaleMask
 	params.Flags |= dcbRTSControlEnable
@@ -429,22 +543,18 @@ func nativeOpen(portName string, mode *Mode) (*windowsPort, error) {
 	params.XoffLim = 512
 	params.XonChar = 17  // DC1
 	params.XoffChar = 19 // C3
-	if setCommState(port.handle, params) != nil {
+	if err := setDCBFlowControl(params, mode.FlowControl); err != nil {
 		port.Close()
-		return nil, &PortError{code: InvalidSerialPort}
+		return nil, err
 	}
-
-	// Set timeouts to 1 second
-	timeouts := &commTimeouts{
-		ReadIntervalTimeout:         0xFFFFFFFF,
-		ReadTotalTimeoutMultiplier:  0xFFFFFFFF,
-		ReadTotalTimeoutConstant:    1000, // 1 sec
-		WriteTotalTimeoutConstant:   0,
-		WriteTotalTimeoutMultiplier: 0,
+	if err := setCommState(port.handle, params); err != nil {
+		port.Close()
+		return nil, &PortError{code: InvalidSerialPort, causedBy: err}
 	}
-	if setCommTimeouts(port.handle, timeouts) != nil {
+
+	if err := port.SetReadTimeout(NoTimeout); err != nil {
 		port.Close()
-		return nil, &PortError{code: InvalidSerialPort}
+		return nil, wrapError(InvalidSerialPort, err)
 	}
 
 	return port, nil
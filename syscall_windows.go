@@ -14,7 +14,7 @@ package serial
 
 //sys setCommTimeouts(handle syscall.Handle, timeouts *commTimeouts) (err error) = SetCommTimeouts
 
-//sys escapeCommFunction(handle syscall.Handle, function uint32) (res bool) = EscapeCommFunction
+//sys escapeCommFunction(handle syscall.Handle, function uint32) (err error) = EscapeCommFunction
 
 //sys getCommModemStatus(handle syscall.Handle, bits *uint32) (res bool) = GetCommModemStatus
 
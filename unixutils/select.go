@@ -9,15 +9,13 @@
 package unixutils
 
 import (
+	"sort"
 	"time"
-
-	"github.com/creack/goselect"
 )
 
 // FDSet is a set of file descriptors suitable for a select call
 type FDSet struct {
-	set goselect.FDSet
-	max uintptr
+	fds []int // sorted
 }
 
 // NewFDSet creates a set of file descriptors suitable for a Select call.
@@ -30,72 +28,63 @@ func NewFDSet(fds ...int) *FDSet {
 // Add adds the file descriptors passed as parameter to the FDSet.
 func (s *FDSet) Add(fds ...int) {
 	for _, fd := range fds {
-		f := uintptr(fd)
-		s.set.Set(f)
-		if f > s.max {
-			s.max = f
+		if l := len(s.fds); l == 0 || s.fds[l-1] < fd {
+			s.fds = append(s.fds, fd)
+			continue
+		}
+		i := sort.SearchInts(s.fds, fd)
+		if i < len(s.fds) && s.fds[i] == fd {
+			continue
 		}
+		s.fds = append(s.fds, 0)
+		copy(s.fds[i+1:], s.fds[i:])
+		s.fds[i] = fd
+	}
+}
+
+func (s *FDSet) isSet(fd int) bool {
+	if s == nil {
+		return false
 	}
+	i := sort.SearchInts(s.fds, fd)
+	return i < len(s.fds) && s.fds[i] == fd
 }
 
 // FDResultSets contains the result of a Select operation.
 type FDResultSets struct {
-	readable  *goselect.FDSet
-	writeable *goselect.FDSet
-	errors    *goselect.FDSet
+	readable  *FDSet
+	writeable *FDSet
+	errors    *FDSet
 }
 
 // IsReadable test if a file descriptor is ready to be read.
 func (r *FDResultSets) IsReadable(fd int) bool {
-	return r.readable.IsSet(uintptr(fd))
+	return r.readable.isSet(fd)
 }
 
 // IsWritable test if a file descriptor is ready to be written.
 func (r *FDResultSets) IsWritable(fd int) bool {
-	return r.writeable.IsSet(uintptr(fd))
+	return r.writeable.isSet(fd)
 }
 
 // IsError test if a file descriptor is in error state.
 func (r *FDResultSets) IsError(fd int) bool {
-	return r.errors.IsSet(uintptr(fd))
+	return r.errors.isSet(fd)
 }
 
-// Select performs a select system call,
+// Select waits for the events on the file descriptors,
 // file descriptors in the rd set are tested for read-events,
 // file descriptors in the wd set are tested for write-events and
 // file descriptors in the er set are tested for error-events.
-// The function will block until an event happens or the timeout expires.
+// The function will block until an event happens or the timeout expires,
+// a negative timeout waits forever.
 // The function return an FDResultSets that contains all the file descriptor
 // that have a pending read/write/error event.
+//
+// Select is implemented with a poll system call, that has no limit on the
+// values of the file descriptors, except on darwin where poll doesn't
+// support the character devices: there a select system call is used and
+// Select fails if a descriptor is not below FD_SETSIZE.
 func Select(rd, wr, er *FDSet, timeout time.Duration) (*FDResultSets, error) {
-	max := uintptr(0)
-	res := &FDResultSets{}
-	if rd != nil {
-		// fdsets are copied so the parameters are left untouched
-		copyOfRd := rd.set
-		res.readable = &copyOfRd
-		// Determine max fd.
-		max = rd.max
-	}
-	if wr != nil {
-		// fdsets are copied so the parameters are left untouched
-		copyOfWr := wr.set
-		res.writeable = &copyOfWr
-		// Determine max fd.
-		if wr.max > max {
-			max = wr.max
-		}
-	}
-	if er != nil {
-		// fdsets are copied so the parameters are left untouched
-		copyOfEr := er.set
-		res.errors = &copyOfEr
-		// Determine max fd.
-		if er.max > max {
-			max = er.max
-		}
-	}
-
-	err := goselect.Select(int(max+1), res.readable, res.writeable, res.errors, timeout)
-	return res, err
+	return nativeSelect(rd, wr, er, timeout)
 }
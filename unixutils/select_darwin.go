@@ -0,0 +1,76 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package unixutils
+
+import (
+	"errors"
+	"time"
+
+	"github.com/creack/goselect"
+)
+
+// errFDSetSize is returned by Select for the descriptors out of the range
+// of a select call.
+var errFDSetSize = errors.New("unixutils: file descriptor not below FD_SETSIZE")
+
+// toGoselect converts s to a goselect.FDSet and updates the max file
+// descriptor.
+func toGoselect(s *FDSet, max *int) (*goselect.FDSet, error) {
+	if s == nil {
+		return nil, nil
+	}
+	set := &goselect.FDSet{}
+	for _, fd := range s.fds {
+		if fd < 0 || fd >= goselect.FD_SETSIZE {
+			return nil, errFDSetSize
+		}
+		set.Set(uintptr(fd))
+		if fd > *max {
+			*max = fd
+		}
+	}
+	return set, nil
+}
+
+// fromGoselect returns the file descriptors of s that are set in set.
+func fromGoselect(s *FDSet, set *goselect.FDSet) *FDSet {
+	if s == nil {
+		return nil
+	}
+	res := &FDSet{}
+	for _, fd := range s.fds {
+		if set.IsSet(uintptr(fd)) {
+			res.Add(fd)
+		}
+	}
+	return res
+}
+
+func nativeSelect(rd, wr, er *FDSet, timeout time.Duration) (*FDResultSets, error) {
+	max := 0
+	rdSet, err := toGoselect(rd, &max)
+	if err != nil {
+		return nil, err
+	}
+	wrSet, err := toGoselect(wr, &max)
+	if err != nil {
+		return nil, err
+	}
+	erSet, err := toGoselect(er, &max)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := goselect.Select(max+1, rdSet, wrSet, erSet, timeout); err != nil {
+		return nil, err
+	}
+	return &FDResultSets{
+		readable:  fromGoselect(rd, rdSet),
+		writeable: fromGoselect(wr, wrSet),
+		errors:    fromGoselect(er, erSet),
+	}, nil
+}
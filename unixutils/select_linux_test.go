@@ -0,0 +1,144 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package unixutils
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/creack/goselect"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func openPipe(t testing.TB) *Pipe {
+	p := &Pipe{}
+	require.NoError(t, p.Open())
+	return p
+}
+
+func TestSelectReadable(t *testing.T) {
+	p := openPipe(t)
+	defer p.Close()
+	fds := NewFDSet(p.ReadFD())
+
+	res, err := Select(fds, nil, fds, 10*time.Millisecond)
+	require.NoError(t, err)
+	require.False(t, res.IsReadable(p.ReadFD()))
+	require.False(t, res.IsError(p.ReadFD()))
+
+	_, err = p.Write([]byte{1})
+	require.NoError(t, err)
+	res, err = Select(fds, NewFDSet(p.WriteFD()), fds, -1)
+	require.NoError(t, err)
+	require.True(t, res.IsReadable(p.ReadFD()))
+	require.True(t, res.IsWritable(p.WriteFD()))
+	require.False(t, res.IsError(p.ReadFD()))
+}
+
+func TestSelectHangup(t *testing.T) {
+	p := openPipe(t)
+	defer unix.Close(p.ReadFD())
+	unix.Close(p.WriteFD())
+
+	// A hangup makes the descriptor readable, like select
+	res, err := Select(NewFDSet(p.ReadFD()), nil, nil, time.Second)
+	require.NoError(t, err)
+	require.True(t, res.IsReadable(p.ReadFD()))
+}
+
+func TestSelectHighFD(t *testing.T) {
+	var rlim unix.Rlimit
+	require.NoError(t, unix.Getrlimit(unix.RLIMIT_NOFILE, &rlim))
+	if rlim.Cur < 2048 {
+		t.Skip("the open files limit is too low")
+	}
+
+	p := openPipe(t)
+	defer p.Close()
+	// Move the read end above FD_SETSIZE, out of reach of select
+	fd, err := unix.FcntlInt(uintptr(p.ReadFD()), unix.F_DUPFD_CLOEXEC, 1500)
+	require.NoError(t, err)
+	defer unix.Close(fd)
+
+	_, err = p.Write([]byte{1})
+	require.NoError(t, err)
+	res, err := Select(NewFDSet(fd), nil, nil, time.Second)
+	require.NoError(t, err)
+	require.True(t, res.IsReadable(fd))
+}
+
+// The benchmarks measure a round trip of a byte through a pipe, with other
+// idle pipes in the set, waited with Select, that uses poll, and with the
+// select system call. Besides the latency they report the CPU time used by
+// the process for each round trip.
+
+// cpuTime returns the user and system CPU time used by the process.
+func cpuTime(b *testing.B) time.Duration {
+	var ru unix.Rusage
+	require.NoError(b, unix.Getrusage(unix.RUSAGE_SELF, &ru))
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+}
+
+// benchmarkWait runs wait on the read ends of count pipes, the first of
+// which receives a byte at each round trip.
+func benchmarkWait(b *testing.B, count int, wait func(fds []int) error) {
+	var fds []int
+	for i := 0; i < count; i++ {
+		p := openPipe(b)
+		defer p.Close()
+		fds = append(fds, p.ReadFD())
+	}
+	p := openPipe(b)
+	defer p.Close()
+	fds = append(fds, p.ReadFD())
+
+	buf := []byte{0}
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := cpuTime(b)
+	for i := 0; i < b.N; i++ {
+		p.Write(buf)
+		if err := wait(fds); err != nil {
+			b.Fatal(err)
+		}
+		p.Read(buf)
+	}
+	b.ReportMetric(float64(cpuTime(b)-start)/float64(b.N), "cpu-ns/op")
+}
+
+func BenchmarkSelect(b *testing.B) {
+	for _, count := range []int{0, 256} {
+		b.Run(strconv.Itoa(count)+"-idle", func(b *testing.B) {
+			benchmarkWait(b, count, func(fds []int) error {
+				set := NewFDSet(fds...)
+				_, err := Select(set, nil, set, time.Second)
+				return err
+			})
+		})
+	}
+}
+
+func BenchmarkSelectSyscall(b *testing.B) {
+	for _, count := range []int{0, 256} {
+		b.Run(strconv.Itoa(count)+"-idle", func(b *testing.B) {
+			benchmarkWait(b, count, func(fds []int) error {
+				rd := &goselect.FDSet{}
+				max := 0
+				for _, fd := range fds {
+					rd.Set(uintptr(fd))
+					if fd > max {
+						max = fd
+					}
+				}
+				er := *rd
+				return goselect.Select(max+1, rd, nil, &er, time.Second)
+			})
+		})
+	}
+}
@@ -0,0 +1,117 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+// +build linux freebsd openbsd
+
+package unixutils
+
+import (
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func nativeSelect(rd, wr, er *FDSet, timeout time.Duration) (*FDResultSets, error) {
+	// The descriptors of all the sets, merged
+	var all []int
+	for _, s := range []*FDSet{rd, wr, er} {
+		if s != nil {
+			all = merge(all, s.fds)
+		}
+	}
+	fds := make([]unix.PollFd, len(all))
+	for i, fd := range all {
+		fds[i].Fd = int32(fd)
+	}
+	// The sets are sorted like fds, and are walked together with it
+	addEvents := func(s *FDSet, events int16) {
+		if s == nil {
+			return
+		}
+		i := 0
+		for _, fd := range s.fds {
+			for int(fds[i].Fd) != fd {
+				i++
+			}
+			fds[i].Events |= events
+		}
+	}
+	addEvents(rd, unix.POLLIN)
+	addEvents(wr, unix.POLLOUT)
+	addEvents(er, unix.POLLPRI)
+
+	var deadline time.Time
+	if timeout >= 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	for {
+		ms := -1
+		if timeout >= 0 {
+			// Rounded up, to not wake up before the timeout
+			ms = int((time.Until(deadline) + time.Millisecond - 1) / time.Millisecond)
+			if ms < 0 {
+				ms = 0
+			}
+		}
+		_, err := unix.Poll(fds, ms)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		break
+	}
+
+	// Like select, a hangup or an error make a descriptor readable and
+	// writable, since the operations on it don't block
+	for _, p := range fds {
+		if p.Revents&unix.POLLNVAL != 0 {
+			return nil, unix.EBADF
+		}
+	}
+	result := func(s *FDSet, revents int16) *FDSet {
+		if s == nil {
+			return nil
+		}
+		res := &FDSet{}
+		i := 0
+		for _, fd := range s.fds {
+			for int(fds[i].Fd) != fd {
+				i++
+			}
+			if fds[i].Revents&revents != 0 {
+				res.fds = append(res.fds, fd)
+			}
+		}
+		return res
+	}
+	return &FDResultSets{
+		readable:  result(rd, unix.POLLIN|unix.POLLHUP|unix.POLLERR),
+		writeable: result(wr, unix.POLLOUT|unix.POLLHUP|unix.POLLERR),
+		errors:    result(er, unix.POLLPRI|unix.POLLERR),
+	}, nil
+}
+
+// merge returns the union of the sorted sets of descriptors a and b.
+func merge(a, b []int) []int {
+	res := make([]int, 0, len(a)+len(b))
+	for len(a) > 0 && len(b) > 0 {
+		switch {
+		case a[0] < b[0]:
+			res = append(res, a[0])
+			a = a[1:]
+		case a[0] > b[0]:
+			res = append(res, b[0])
+			b = b[1:]
+		default:
+			res = append(res, a[0])
+			a, b = a[1:], b[1:]
+		}
+	}
+	res = append(res, a...)
+	return append(res, b...)
+}
@@ -0,0 +1,287 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package xmodem
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// Receiver receives data with XMODEM or YMODEM. The fields must be set
+// before starting a transfer.
+type Receiver struct {
+	// Mode is the integrity check requested to the sender. In CRCMode
+	// (default) the XMODEM receiver falls back to ChecksumMode if the
+	// sender doesn't answer.
+	Mode Mode
+	// Timeout is the time to wait for a block. If zero, DefaultTimeout is
+	// used.
+	Timeout time.Duration
+	// Retries is the number of consecutive errors or timeouts tolerated
+	// before the transfer is aborted. If zero, DefaultRetries is used.
+	Retries int
+	// Progress, if set, is called after each block received, with the
+	// number of bytes received and the total size (-1 if unknown).
+	Progress func(received, total int64)
+
+	port serial.Port
+}
+
+// NewReceiver creates a new Receiver that receives data from port.
+func NewReceiver(port serial.Port) *Receiver {
+	return &Receiver{port: port}
+}
+
+// receiver is the state of a transfer in progress.
+type receiver struct {
+	*link
+	mode     Mode
+	fallback bool
+	progress func(received, total int64)
+}
+
+func (r *Receiver) newReceiver(fallback bool) *receiver {
+	return &receiver{
+		link:     newLink(r.port, r.Timeout, r.Retries),
+		mode:     r.Mode,
+		fallback: fallback && r.Mode == CRCMode,
+		progress: r.Progress,
+	}
+}
+
+// Receive receives a stream with XMODEM and writes it to w. The last block
+// is written with its padding. It returns the number of bytes written.
+func (r *Receiver) Receive(w io.Writer) (int64, error) {
+	return r.newReceiver(true).receiveData(w, -1, false)
+}
+
+// ReceiveFiles receives a batch of files with YMODEM. For each file create
+// is called with its name and size (-1 if unknown) and must return the
+// io.Writer where the content is written: the padding of the last block
+// is removed if the size is known. If create returns an error the transfer
+// is canceled.
+func (r *Receiver) ReceiveFiles(create func(name string, size int64) (io.Writer, error)) error {
+	rx := r.newReceiver(false)
+	for {
+		header, err := rx.readBlock(0, true)
+		if err != nil {
+			return err
+		}
+		if header == nil {
+			// Repeated EOT of the previous file
+			if err := rx.write(ACK); err != nil {
+				return err
+			}
+			continue
+		}
+		name, size, err := parseHeader(header)
+		if err != nil {
+			rx.cancel()
+			return err
+		}
+		if err := rx.write(ACK); err != nil {
+			return err
+		}
+		if name == "" {
+			return nil
+		}
+		w, err := create(name, size)
+		if err != nil {
+			rx.cancel()
+			return err
+		}
+		if _, err := rx.receiveData(w, size, true); err != nil {
+			return err
+		}
+	}
+}
+
+// parseHeader parses a YMODEM header block.
+func parseHeader(block []byte) (string, int64, error) {
+	end := bytes.IndexByte(block, 0)
+	if end < 0 {
+		return "", 0, ErrInvalidHeader
+	}
+	name := string(block[:end])
+	if name == "" {
+		return "", 0, nil
+	}
+	info := block[end+1:]
+	if i := bytes.IndexByte(info, 0); i >= 0 {
+		info = info[:i]
+	}
+	fields := strings.Fields(string(info))
+	if len(fields) == 0 {
+		return name, -1, nil
+	}
+	size, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil || size < 0 {
+		return "", 0, ErrInvalidHeader
+	}
+	return name, size, nil
+}
+
+func (rx *receiver) startChar() byte {
+	if rx.mode == CRCMode {
+		return CRC
+	}
+	return NAK
+}
+
+// receiveData receives the data blocks of a file until EOT and writes up
+// to size bytes to w (everything if size is -1). A YMODEM receiver answers
+// the first EOT with a NAK.
+func (rx *receiver) receiveData(w io.Writer, size int64, batch bool) (int64, error) {
+	var received int64
+	expect := byte(1)
+	soliciting := true
+	eotNAKed := !batch
+	for {
+		data, err := rx.readBlock(expect, soliciting)
+		if err != nil {
+			return received, err
+		}
+		if data == nil {
+			if !eotNAKed {
+				eotNAKed = true
+				if err := rx.write(NAK); err != nil {
+					return received, err
+				}
+				continue
+			}
+			return received, rx.write(ACK)
+		}
+		soliciting = false
+		if size >= 0 && received+int64(len(data)) > size {
+			data = data[:size-received]
+		}
+		if _, err := w.Write(data); err != nil {
+			rx.cancel()
+			return received, err
+		}
+		received += int64(len(data))
+		if err := rx.write(ACK); err != nil {
+			return received, err
+		}
+		expect++
+		if rx.progress != nil {
+			rx.progress(received, size)
+		}
+	}
+}
+
+// readBlock waits for the block number expect and returns its data, or
+// nil if EOT is received. While soliciting, the start character is sent
+// until the sender answers; afterwards the errors are answered with NAK.
+// Duplicated blocks are acknowledged and discarded.
+func (rx *receiver) readBlock(expect byte, soliciting bool) ([]byte, error) {
+	errors := 0
+	if soliciting {
+		if err := rx.write(rx.startChar()); err != nil {
+			return nil, err
+		}
+	}
+	retry := func() error {
+		errors++
+		if errors > rx.retries {
+			rx.cancel()
+			return ErrTooManyRetries
+		}
+		if soliciting {
+			// Fall back to the checksum if the sender doesn't support
+			// the CRC
+			if rx.fallback && errors >= 3 {
+				rx.mode = ChecksumMode
+			}
+			return rx.write(rx.startChar())
+		}
+		return rx.write(NAK)
+	}
+
+	for {
+		timeout := rx.timeout
+		if soliciting && timeout > startInterval {
+			timeout = startInterval
+		}
+		b, err := rx.readByte(timeout)
+		if isTimeout(err) {
+			if err := retry(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		size := 128
+		switch b {
+		case SOH:
+		case STX:
+			size = 1024
+		case EOT:
+			return nil, nil
+		case CAN:
+			if rx.canceled() {
+				return nil, ErrCanceled
+			}
+			continue
+		default:
+			continue
+		}
+
+		checkLen := 1
+		if rx.mode == CRCMode {
+			checkLen = 2
+		}
+		packet, err := rx.read(2+size+checkLen, rx.timeout)
+		if err != nil && !isTimeout(err) {
+			return nil, err
+		}
+		if err != nil || !rx.valid(packet) {
+			if err := rx.purge(); err != nil {
+				return nil, err
+			}
+			if err := retry(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		num := packet[0]
+		if num == expect-1 {
+			if err := rx.write(ACK); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if num != expect {
+			rx.cancel()
+			return nil, ErrSequence
+		}
+		return packet[2 : 2+size], nil
+	}
+}
+
+// valid checks the block number and the integrity of a block.
+func (rx *receiver) valid(packet []byte) bool {
+	if packet[0] != ^packet[1] {
+		return false
+	}
+	if rx.mode == CRCMode {
+		data := packet[2 : len(packet)-2]
+		crc := CRC16(data)
+		return packet[len(packet)-2] == byte(crc>>8) && packet[len(packet)-1] == byte(crc)
+	}
+	data := packet[2 : len(packet)-1]
+	return packet[len(packet)-1] == Checksum(data)
+}
@@ -0,0 +1,249 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package xmodem
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// File is a file sent with YMODEM.
+type File struct {
+	// Name is the name of the file, without directories
+	Name string
+	// Size is the size of the file
+	Size int64
+	// ModTime is the modification time of the file, optional
+	ModTime time.Time
+	// Data is the content of the file
+	Data io.Reader
+}
+
+// Sender sends data with XMODEM or YMODEM. The fields must be set before
+// starting a transfer.
+type Sender struct {
+	// BlockSize is the size of the XMODEM blocks: 128 (default) or 1024
+	// (XMODEM-1K). YMODEM always uses 1024 bytes blocks. The 1024 bytes
+	// blocks are used only if the receiver requests the CRC mode.
+	BlockSize int
+	// Timeout is the time to wait for the receiver to acknowledge a block.
+	// If zero, DefaultTimeout is used.
+	Timeout time.Duration
+	// Retries is the number of retransmissions of a block before the
+	// transfer is aborted. If zero, DefaultRetries is used.
+	Retries int
+	// Progress, if set, is called after each block acknowledged, with the
+	// number of bytes sent and the total size (-1 if unknown).
+	Progress func(sent, total int64)
+
+	port serial.Port
+}
+
+// NewSender creates a new Sender that sends data on port.
+func NewSender(port serial.Port) *Sender {
+	return &Sender{port: port}
+}
+
+// Send sends data with XMODEM. size is the total size of the data, used
+// only for the Progress callback: use -1 if unknown.
+func (s *Sender) Send(data io.Reader, size int64) error {
+	l := newLink(s.port, s.Timeout, s.Retries)
+	mode, err := l.waitStart()
+	if err != nil {
+		return err
+	}
+	blockSize := 128
+	if s.BlockSize == 1024 && mode == CRCMode {
+		blockSize = 1024
+	}
+	return s.sendData(l, mode, blockSize, data, size)
+}
+
+// SendFiles sends a batch of files with YMODEM.
+func (s *Sender) SendFiles(files ...*File) error {
+	l := newLink(s.port, s.Timeout, s.Retries)
+	for _, f := range files {
+		mode, err := l.waitStart()
+		if err != nil {
+			return err
+		}
+		if err := l.sendBlock(mode, 0, headerBlock(f)); err != nil {
+			return err
+		}
+		if mode, err = l.waitStart(); err != nil {
+			return err
+		}
+		if err := s.sendData(l, mode, 1024, f.Data, f.Size); err != nil {
+			return err
+		}
+	}
+
+	// An empty header terminates the batch
+	mode, err := l.waitStart()
+	if err != nil {
+		return err
+	}
+	return l.sendBlock(mode, 0, make([]byte, 128))
+}
+
+// headerBlock returns the YMODEM header block of a file: the name and the
+// size in decimal, followed by the modification time in octal if known.
+func headerBlock(f *File) []byte {
+	info := fmt.Sprintf("%d", f.Size)
+	if !f.ModTime.IsZero() {
+		info += fmt.Sprintf(" %o", f.ModTime.Unix())
+	}
+	header := append([]byte(f.Name), 0)
+	header = append(header, info...)
+	size := 128
+	if len(header) >= size {
+		size = 1024
+	}
+	block := make([]byte, size)
+	copy(block, header)
+	return block
+}
+
+// sendData sends data in blocks, followed by EOT.
+func (s *Sender) sendData(l *link, mode Mode, blockSize int, data io.Reader, size int64) error {
+	buf := make([]byte, blockSize)
+	num := byte(1)
+	var sent int64
+	for {
+		n, err := io.ReadFull(data, buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			l.cancel()
+			return err
+		}
+		// A short block that fits is sent as a 128 bytes block
+		block := buf
+		if n <= 128 {
+			block = buf[:128]
+		}
+		for i := n; i < len(block); i++ {
+			block[i] = SUB
+		}
+		if err := l.sendBlock(mode, num, block); err != nil {
+			return err
+		}
+		num++
+		sent += int64(n)
+		if s.Progress != nil {
+			s.Progress(sent, size)
+		}
+		if n < blockSize {
+			break
+		}
+	}
+	return l.sendEOT()
+}
+
+// waitStart waits for the start character sent by the receiver and
+// returns the mode requested.
+func (l *link) waitStart() (Mode, error) {
+	for i := 0; i <= l.retries; i++ {
+		b, err := l.readByte(l.timeout)
+		if isTimeout(err) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		switch b {
+		case CRC:
+			return CRCMode, nil
+		case NAK:
+			return ChecksumMode, nil
+		case CAN:
+			if l.canceled() {
+				return 0, ErrCanceled
+			}
+		}
+	}
+	return 0, ErrTooManyRetries
+}
+
+// sendBlock sends a block until it's acknowledged.
+func (l *link) sendBlock(mode Mode, num byte, data []byte) error {
+	packet := make([]byte, 0, len(data)+5)
+	if len(data) == 1024 {
+		packet = append(packet, STX)
+	} else {
+		packet = append(packet, SOH)
+	}
+	packet = append(packet, num, ^num)
+	packet = append(packet, data...)
+	if mode == CRCMode {
+		crc := CRC16(data)
+		packet = append(packet, byte(crc>>8), byte(crc))
+	} else {
+		packet = append(packet, Checksum(data))
+	}
+
+	for i := 0; i <= l.retries; i++ {
+		if _, err := l.port.Write(packet); err != nil {
+			return err
+		}
+	wait:
+		for {
+			b, err := l.readByte(l.timeout)
+			if isTimeout(err) {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			switch b {
+			case ACK:
+				return nil
+			case NAK:
+				break wait
+			case CAN:
+				if l.canceled() {
+					return ErrCanceled
+				}
+			}
+			// Other characters, like the start characters sent by the
+			// receiver before the first block, are ignored
+		}
+	}
+	l.cancel()
+	return ErrTooManyRetries
+}
+
+// sendEOT ends the transmission of a file.
+func (l *link) sendEOT() error {
+	for i := 0; i <= l.retries; i++ {
+		if err := l.write(EOT); err != nil {
+			return err
+		}
+		b, err := l.readByte(l.timeout)
+		if isTimeout(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		switch b {
+		case ACK:
+			return nil
+		case CAN:
+			if l.canceled() {
+				return ErrCanceled
+			}
+		}
+		// YMODEM receivers answer to the first EOT with a NAK
+	}
+	l.cancel()
+	return ErrTooManyRetries
+}
@@ -0,0 +1,192 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+/*
+Package xmodem implements the XMODEM and YMODEM file transfer protocols
+on top of a serial.Port.
+
+A Sender sends a single stream with XMODEM (128 or 1024 bytes blocks) or
+a batch of files with YMODEM. The receiver chooses between the 8-bit
+checksum and the CRC-16:
+
+	sender := xmodem.NewSender(port)
+	sender.BlockSize = 1024 // XMODEM-1K
+	sender.Progress = func(sent, total int64) {
+		fmt.Printf("%d/%d\n", sent, total)
+	}
+	err := sender.Send(firmware, size)
+
+A Receiver receives a single XMODEM stream, padded to the block size with
+SUB characters, or a YMODEM batch:
+
+	receiver := xmodem.NewReceiver(port)
+	err := receiver.ReceiveFiles(func(name string, size int64) (io.Writer, error) {
+		return os.Create(name)
+	})
+*/
+package xmodem
+
+import (
+	"errors"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// Control characters of the protocols
+const (
+	SOH byte = 0x01 // start of a 128 bytes block
+	STX byte = 0x02 // start of a 1024 bytes block
+	EOT byte = 0x04 // end of transmission
+	ACK byte = 0x06 // block received
+	NAK byte = 0x15 // block not received, or start in checksum mode
+	CAN byte = 0x18 // cancel the transfer
+	SUB byte = 0x1A // padding of the last block
+	CRC byte = 'C'  // start in CRC mode
+)
+
+// Default values of the transfer parameters
+const (
+	DefaultTimeout = 10 * time.Second
+	DefaultRetries = 10
+)
+
+// startInterval is the maximum interval between the start characters sent
+// by a receiver.
+const startInterval = 3 * time.Second
+
+// purgeTimeout is the line silence waited before a NAK, to discard the
+// rest of a corrupted block.
+const purgeTimeout = 200 * time.Millisecond
+
+var (
+	// ErrCanceled is returned when the remote side cancels the transfer
+	ErrCanceled = errors.New("xmodem: transfer canceled by remote")
+	// ErrTooManyRetries is returned when a block can't be transferred
+	// within the allowed retries
+	ErrTooManyRetries = errors.New("xmodem: too many retries")
+	// ErrSequence is returned when a block is received out of sequence
+	ErrSequence = errors.New("xmodem: block out of sequence")
+	// ErrInvalidHeader is returned when a YMODEM header block is invalid
+	ErrInvalidHeader = errors.New("xmodem: invalid YMODEM header")
+)
+
+// Mode selects the integrity check of the blocks
+type Mode int
+
+const (
+	// CRCMode uses the CRC-16 (default)
+	CRCMode Mode = iota
+	// ChecksumMode uses the 8-bit checksum of the original XMODEM
+	ChecksumMode
+)
+
+var crcTable [256]uint16
+
+func init() {
+	for i := range crcTable {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = (crc << 1) ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+		crcTable[i] = crc
+	}
+}
+
+// CRC16 computes the CRC-16 used by XMODEM (CRC-16/XMODEM).
+func CRC16(data []byte) uint16 {
+	crc := uint16(0)
+	for _, b := range data {
+		crc = (crc << 8) ^ crcTable[byte(crc>>8)^b]
+	}
+	return crc
+}
+
+// Checksum computes the 8-bit arithmetic checksum used by XMODEM.
+func Checksum(data []byte) byte {
+	var sum byte
+	for _, b := range data {
+		sum += b
+	}
+	return sum
+}
+
+// link is the serial port used by a transfer.
+type link struct {
+	port    serial.Port
+	reader  *serial.Reader
+	timeout time.Duration
+	retries int
+}
+
+func newLink(port serial.Port, timeout time.Duration, retries int) *link {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	if retries <= 0 {
+		retries = DefaultRetries
+	}
+	return &link{
+		port:    port,
+		reader:  serial.NewReader(port),
+		timeout: timeout,
+		retries: retries,
+	}
+}
+
+// isTimeout returns true if err is a read timeout.
+func isTimeout(err error) bool {
+	portErr, ok := err.(*serial.PortError)
+	return ok && portErr.Code() == serial.ReadTimeout
+}
+
+// read reads exactly n bytes within the timeout.
+func (l *link) read(n int, timeout time.Duration) ([]byte, error) {
+	return l.reader.ReadExactly(n, timeout)
+}
+
+// readByte reads a byte within the timeout.
+func (l *link) readByte(timeout time.Duration) (byte, error) {
+	b, err := l.reader.ReadExactly(1, timeout)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (l *link) write(data ...byte) error {
+	_, err := l.port.Write(data)
+	return err
+}
+
+// purge discards the data received until the line is silent.
+func (l *link) purge() error {
+	for {
+		_, err := l.readByte(purgeTimeout)
+		if isTimeout(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// cancel aborts the transfer.
+func (l *link) cancel() {
+	l.write(CAN, CAN, CAN, CAN, CAN)
+}
+
+// canceled checks if a CAN received is followed by another one, as
+// required to cancel a transfer.
+func (l *link) canceled() bool {
+	b, err := l.readByte(time.Second)
+	return err == nil && b == CAN
+}
@@ -0,0 +1,214 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package xmodem
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.bug.st/serial"
+	"go.bug.st/serial/internal/ptytest"
+)
+
+func newTestPair(t *testing.T) (serial.Port, serial.Port) {
+	port, master, err := ptytest.Pair(&serial.Mode{BaudRate: 115200})
+	require.NoError(t, err)
+	return port, master
+}
+
+func randomData(n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(int64(n))).Read(data)
+	return data
+}
+
+// noisyPort corrupts the data of the block number corrupt the first time
+// it's sent.
+type noisyPort struct {
+	serial.Port
+	corrupt byte
+
+	mu   sync.Mutex
+	done bool
+}
+
+func (p *noisyPort) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	if !p.done && len(b) > 128 && b[1] == p.corrupt {
+		p.done = true
+		c := append([]byte{}, b...)
+		c[10] ^= 0xFF
+		p.mu.Unlock()
+		return p.Port.Write(c)
+	}
+	p.mu.Unlock()
+	return p.Port.Write(b)
+}
+
+func (p *noisyPort) SetReadTimeout(t time.Duration) error {
+	return p.Port.(serial.ReadTimeouter).SetReadTimeout(t)
+}
+
+func TestXMODEM(t *testing.T) {
+	tests := []struct {
+		name      string
+		blockSize int
+		mode      Mode
+		size      int
+		blocks    int
+	}{
+		{"crc", 128, CRCMode, 1000, 8},
+		{"checksum", 128, ChecksumMode, 300, 3},
+		{"1k", 1024, CRCMode, 3000, 3},
+		{"1k-checksum", 1024, ChecksumMode, 256, 2},
+		{"empty", 128, CRCMode, 0, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a, b := newTestPair(t)
+			defer a.Close()
+			defer b.Close()
+			data := randomData(test.size)
+
+			sender := NewSender(a)
+			sender.BlockSize = test.blockSize
+			sender.Timeout = time.Second
+			progress := []int64{}
+			sender.Progress = func(sent, total int64) {
+				require.Equal(t, int64(test.size), total)
+				progress = append(progress, sent)
+			}
+			sent := make(chan error, 1)
+			go func() {
+				sent <- sender.Send(bytes.NewReader(data), int64(len(data)))
+			}()
+
+			receiver := NewReceiver(b)
+			receiver.Mode = test.mode
+			receiver.Timeout = time.Second
+			var out bytes.Buffer
+			n, err := receiver.Receive(&out)
+			require.NoError(t, err)
+			require.NoError(t, <-sent)
+			require.Equal(t, int64(out.Len()), n)
+
+			// The last block is padded with SUB
+			require.True(t, bytes.Equal(data, out.Bytes()[:len(data)]))
+			for _, c := range out.Bytes()[len(data):] {
+				require.Equal(t, SUB, c)
+			}
+			require.Len(t, progress, test.blocks)
+			if test.blocks > 0 {
+				require.Equal(t, int64(test.size), progress[len(progress)-1])
+			}
+		})
+	}
+}
+
+func TestXMODEMRetransmission(t *testing.T) {
+	a, b := newTestPair(t)
+	defer a.Close()
+	defer b.Close()
+	data := randomData(2000)
+
+	sender := NewSender(&noisyPort{Port: a, corrupt: 2})
+	sender.BlockSize = 1024
+	sender.Timeout = time.Second
+	sent := make(chan error, 1)
+	go func() {
+		sent <- sender.Send(bytes.NewReader(data), -1)
+	}()
+
+	receiver := NewReceiver(b)
+	receiver.Timeout = time.Second
+	var out bytes.Buffer
+	_, err := receiver.Receive(&out)
+	require.NoError(t, err)
+	require.NoError(t, <-sent)
+	require.Equal(t, data, out.Bytes()[:len(data)])
+}
+
+func TestYMODEM(t *testing.T) {
+	a, b := newTestPair(t)
+	defer a.Close()
+	defer b.Close()
+
+	files := []*File{
+		{Name: "firmware.bin", Size: 5000, ModTime: time.Unix(1600000000, 0)},
+		{Name: "small.txt", Size: 10},
+		{Name: "empty", Size: 0},
+	}
+	contents := map[string][]byte{}
+	for _, f := range files {
+		contents[f.Name] = randomData(int(f.Size))
+		f.Data = bytes.NewReader(contents[f.Name])
+	}
+
+	sender := NewSender(&noisyPort{Port: a, corrupt: 3})
+	sender.Timeout = time.Second
+	sent := make(chan error, 1)
+	go func() {
+		sent <- sender.SendFiles(files...)
+	}()
+
+	receiver := NewReceiver(b)
+	receiver.Timeout = time.Second
+	received := map[string]*bytes.Buffer{}
+	names := []string{}
+	err := receiver.ReceiveFiles(func(name string, size int64) (io.Writer, error) {
+		require.Equal(t, int64(len(contents[name])), size)
+		names = append(names, name)
+		received[name] = &bytes.Buffer{}
+		return received[name], nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, <-sent)
+	require.Equal(t, []string{"firmware.bin", "small.txt", "empty"}, names)
+	for name, data := range contents {
+		require.True(t, bytes.Equal(data, received[name].Bytes()), name)
+	}
+}
+
+func TestYMODEMCancel(t *testing.T) {
+	a, b := newTestPair(t)
+	defer a.Close()
+	defer b.Close()
+
+	sender := NewSender(a)
+	sender.Timeout = time.Second
+	sent := make(chan error, 1)
+	go func() {
+		sent <- sender.SendFiles(&File{Name: "denied", Size: 3, Data: bytes.NewReader([]byte("abc"))})
+	}()
+
+	receiver := NewReceiver(b)
+	receiver.Timeout = time.Second
+	denied := errors.New("denied")
+	err := receiver.ReceiveFiles(func(name string, size int64) (io.Writer, error) {
+		return nil, denied
+	})
+	require.Equal(t, denied, err)
+	require.Equal(t, ErrCanceled, <-sent)
+}
+
+func TestReceiverTimeout(t *testing.T) {
+	a, b := newTestPair(t)
+	defer a.Close()
+	defer b.Close()
+
+	receiver := NewReceiver(b)
+	receiver.Timeout = 50 * time.Millisecond
+	receiver.Retries = 2
+	_, err := receiver.Receive(&bytes.Buffer{})
+	require.Equal(t, ErrTooManyRetries, err)
+}
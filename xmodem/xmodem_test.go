@@ -0,0 +1,48 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package xmodem
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCRC16(t *testing.T) {
+	require.Equal(t, uint16(0x31C3), CRC16([]byte("123456789")))
+	require.Equal(t, uint16(0), CRC16(nil))
+	require.Equal(t, byte(0xDD), Checksum([]byte("123456789")))
+}
+
+func TestHeaderBlock(t *testing.T) {
+	f := &File{Name: "fw.bin", Size: 1234, ModTime: time.Unix(0x1234, 0)}
+	block := headerBlock(f)
+	require.Len(t, block, 128)
+	require.Equal(t, "fw.bin\x001234 11064\x00", string(block[:18]))
+
+	name, size, err := parseHeader(block)
+	require.NoError(t, err)
+	require.Equal(t, "fw.bin", name)
+	require.Equal(t, int64(1234), size)
+
+	name, _, err = parseHeader(make([]byte, 128))
+	require.NoError(t, err)
+	require.Equal(t, "", name)
+
+	name, size, err = parseHeader([]byte("nosize\x00\x00\x00"))
+	require.NoError(t, err)
+	require.Equal(t, "nosize", name)
+	require.Equal(t, int64(-1), size)
+
+	_, _, err = parseHeader([]byte("bad\x00abc\x00"))
+	require.Equal(t, ErrInvalidHeader, err)
+
+	long := &File{Name: strings.Repeat("x", 200), Size: 1}
+	require.Len(t, headerBlock(long), 1024)
+}
@@ -0,0 +1,433 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package zmodem
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"hash/crc32"
+	"time"
+
+	"go.bug.st/serial"
+	"go.bug.st/serial/xmodem"
+)
+
+// header is a ZMODEM frame header: the frame type and four bytes of data,
+// a position (little endian) or flags (ZF0 is the last byte).
+type header struct {
+	typ  byte
+	data [4]byte
+	// crc32 is true if the header was received with a 32-bit CRC: the
+	// subpackets that follow use the same CRC.
+	crc32 bool
+}
+
+func posHeader(typ byte, pos int64) header {
+	h := header{typ: typ}
+	binary.LittleEndian.PutUint32(h.data[:], uint32(pos))
+	return h
+}
+
+func flagsHeader(typ byte, zf0 byte) header {
+	return header{typ: typ, data: [4]byte{0, 0, 0, zf0}}
+}
+
+func (h header) pos() int64 {
+	return int64(binary.LittleEndian.Uint32(h.data[:]))
+}
+
+func (h header) zf0() byte {
+	return h.data[3]
+}
+
+// link is the serial port used by a transfer, with the ZMODEM encoding.
+type link struct {
+	port    serial.Port
+	timeout time.Duration
+	retries int
+	// escapeCtl escapes all the control characters sent
+	escapeCtl bool
+
+	buf      []byte
+	pos, end int
+	out      []byte
+	lastSent byte
+}
+
+func newLink(port serial.Port, timeout time.Duration, retries int) *link {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	if retries <= 0 {
+		retries = DefaultRetries
+	}
+	return &link{
+		port:    port,
+		timeout: timeout,
+		retries: retries,
+		buf:     make([]byte, 1024),
+	}
+}
+
+// fill reads the data available within the timeout.
+func (l *link) fill(timeout time.Duration) error {
+	timeouter, ok := l.port.(serial.ReadTimeouter)
+	if !ok {
+		return serial.ErrFunctionNotImplemented
+	}
+	if err := timeouter.SetReadTimeout(timeout); err != nil {
+		return err
+	}
+	n, err := l.port.Read(l.buf)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errTimeout
+	}
+	l.pos, l.end = 0, n
+	return nil
+}
+
+// readByte reads a byte within the timeout of the link.
+func (l *link) readByte() (byte, error) {
+	if l.pos == l.end {
+		if err := l.fill(l.timeout); err != nil {
+			return 0, err
+		}
+	}
+	b := l.buf[l.pos]
+	l.pos++
+	return b, nil
+}
+
+// unread pushes back the last byte read.
+func (l *link) unread() {
+	l.pos--
+}
+
+// poll returns true if there is data available to read, without waiting.
+func (l *link) poll() (bool, error) {
+	if l.pos < l.end {
+		return true, nil
+	}
+	err := l.fill(0)
+	if err == errTimeout {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// purge discards the data received until the line is silent.
+func (l *link) purge() error {
+	l.pos, l.end = 0, 0
+	for {
+		err := l.fill(purgeTimeout)
+		if err == errTimeout {
+			l.pos, l.end = 0, 0
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (l *link) write(data []byte) error {
+	_, err := l.port.Write(data)
+	return err
+}
+
+// flush writes the encoded data pending.
+func (l *link) flush() error {
+	err := l.write(l.out)
+	l.out = l.out[:0]
+	return err
+}
+
+// cancel aborts the session.
+func (l *link) cancel() {
+	l.write([]byte{
+		zdle, zdle, zdle, zdle, zdle, zdle, zdle, zdle, zdle, zdle,
+		8, 8, 8, 8, 8, 8, 8, 8, 8, 8,
+	})
+}
+
+// putEscaped appends a byte to the output, escaped if needed.
+func (l *link) putEscaped(b byte) {
+	escape := false
+	switch b {
+	case zdle, 0x10, xon, xoff, 0x90, 0x91, 0x93:
+		escape = true
+	case '\r', '\r' | 0x80:
+		// CR after @ is escaped, to avoid the Telenet escape sequence
+		escape = l.escapeCtl || l.lastSent&0x7F == '@'
+	default:
+		escape = l.escapeCtl && b&0x60 == 0
+	}
+	if escape {
+		b ^= 0x40
+		l.out = append(l.out, zdle, b)
+	} else {
+		l.out = append(l.out, b)
+	}
+	l.lastSent = b
+}
+
+func (l *link) putAllEscaped(data []byte) {
+	for _, b := range data {
+		l.putEscaped(b)
+	}
+}
+
+// sendHexHeader sends a header in hex format, used before the CRC-32 is
+// negotiated and by the receiver.
+func (l *link) sendHexHeader(h header) error {
+	raw := []byte{h.typ, h.data[0], h.data[1], h.data[2], h.data[3]}
+	crc := xmodem.CRC16(raw)
+	raw = append(raw, byte(crc>>8), byte(crc))
+	l.out = append(l.out, zpad, zpad, zdle, zhex)
+	l.out = append(l.out, hex.EncodeToString(raw)...)
+	l.out = append(l.out, '\r', '\n'|0x80)
+	if h.typ != zack && h.typ != zfin {
+		l.out = append(l.out, xon)
+	}
+	l.lastSent = 0
+	return l.flush()
+}
+
+// sendBinHeader sends a header in binary format, with a 32-bit CRC if
+// use32 is true.
+func (l *link) sendBinHeader(h header, use32 bool) error {
+	raw := []byte{h.typ, h.data[0], h.data[1], h.data[2], h.data[3]}
+	if use32 {
+		l.out = append(l.out, zpad, zdle, zbin32)
+		l.putAllEscaped(raw)
+		var crc [4]byte
+		binary.LittleEndian.PutUint32(crc[:], crc32.ChecksumIEEE(raw))
+		l.putAllEscaped(crc[:])
+	} else {
+		l.out = append(l.out, zpad, zdle, zbin)
+		l.putAllEscaped(raw)
+		crc := xmodem.CRC16(raw)
+		l.putAllEscaped([]byte{byte(crc >> 8), byte(crc)})
+	}
+	return l.flush()
+}
+
+// sendSubpacket sends a data subpacket terminated by end.
+func (l *link) sendSubpacket(data []byte, end byte, use32 bool) error {
+	l.putAllEscaped(data)
+	l.out = append(l.out, zdle, end)
+	if use32 {
+		crc := crc32.Update(crc32.ChecksumIEEE(data), crc32.IEEETable, []byte{end})
+		var b [4]byte
+		binary.LittleEndian.PutUint32(b[:], crc)
+		l.putAllEscaped(b[:])
+	} else {
+		crc := xmodem.CRC16(append(append([]byte{}, data...), end))
+		l.putAllEscaped([]byte{byte(crc >> 8), byte(crc)})
+	}
+	if end == zcrcw {
+		l.out = append(l.out, xon)
+	}
+	return l.flush()
+}
+
+// frameEnd is added to the value returned by readEscaped for the
+// subpacket terminators.
+const frameEnd = 0x100
+
+// readEscaped reads a byte of binary data, removing the escaping. The
+// subpacket terminators are returned as frameEnd plus the terminator.
+func (l *link) readEscaped() (int, error) {
+	for {
+		c, err := l.readByte()
+		if err != nil {
+			return 0, err
+		}
+		switch c {
+		case xon, xoff, xon | 0x80, xoff | 0x80:
+			// Flow control characters are not part of the data
+			continue
+		case zdle:
+		default:
+			return int(c), nil
+		}
+
+		cancels := 1
+		for {
+			c, err := l.readByte()
+			if err != nil {
+				return 0, err
+			}
+			switch {
+			case c == zdle:
+				// Five CAN in a row cancel the session
+				cancels++
+				if cancels == 5 {
+					return 0, ErrCanceled
+				}
+				continue
+			case c == xon || c == xoff || c == xon|0x80 || c == xoff|0x80:
+				continue
+			case c >= zcrce && c <= zcrcw:
+				return frameEnd + int(c), nil
+			case c == zrub0:
+				return 0x7F, nil
+			case c == zrub1:
+				return 0xFF, nil
+			case c&0x60 == 0x40:
+				return int(c ^ 0x40), nil
+			}
+			return 0, errBadEscape
+		}
+	}
+}
+
+// readHeader waits for a header, skipping the data received before it.
+func (l *link) readHeader() (header, error) {
+	cancels := 0
+	garbage := 0
+	for {
+		c, err := l.readByte()
+		if err != nil {
+			return header{}, err
+		}
+		if c == zdle {
+			cancels++
+			if cancels == 5 {
+				return header{}, ErrCanceled
+			}
+		} else {
+			cancels = 0
+		}
+		if c&0x7F != zpad {
+			// Give up if the data received is not a ZMODEM session
+			garbage++
+			if garbage > 2*maxSubpacketSize {
+				return header{}, errGarbage
+			}
+			continue
+		}
+		for c&0x7F == zpad {
+			if c, err = l.readByte(); err != nil {
+				return header{}, err
+			}
+		}
+		if c != zdle {
+			l.unread()
+			continue
+		}
+		if c, err = l.readByte(); err != nil {
+			return header{}, err
+		}
+		switch c & 0x7F {
+		case zbin:
+			return l.readBinHeader(false)
+		case zbin32:
+			return l.readBinHeader(true)
+		case zhex:
+			return l.readHexHeader()
+		}
+	}
+}
+
+func (l *link) readBinHeader(use32 bool) (header, error) {
+	n := 7
+	if use32 {
+		n = 9
+	}
+	raw := make([]byte, n)
+	for i := range raw {
+		c, err := l.readEscaped()
+		if err != nil {
+			return header{}, err
+		}
+		if c >= frameEnd {
+			return header{}, errFrameEnded
+		}
+		raw[i] = byte(c)
+	}
+	if use32 {
+		if crc32.ChecksumIEEE(raw[:5]) != binary.LittleEndian.Uint32(raw[5:]) {
+			return header{}, errBadCRC
+		}
+	} else if xmodem.CRC16(raw) != 0 {
+		return header{}, errBadCRC
+	}
+	h := header{typ: raw[0], crc32: use32}
+	copy(h.data[:], raw[1:5])
+	return h, nil
+}
+
+func (l *link) readHexHeader() (header, error) {
+	digits := make([]byte, 14)
+	for i := range digits {
+		c, err := l.readByte()
+		if err != nil {
+			return header{}, err
+		}
+		digits[i] = c & 0x7F
+	}
+	raw := make([]byte, 7)
+	if _, err := hex.Decode(raw, digits); err != nil {
+		return header{}, errBadCRC
+	}
+	if xmodem.CRC16(raw) != 0 {
+		return header{}, errBadCRC
+	}
+	// The CR LF that follow are consumed while waiting the next header
+	h := header{typ: raw[0]}
+	copy(h.data[:], raw[1:5])
+	return h, nil
+}
+
+// readSubpacket reads a data subpacket and returns its data and its
+// terminator.
+func (l *link) readSubpacket(use32 bool) ([]byte, byte, error) {
+	var data []byte
+	for {
+		c, err := l.readEscaped()
+		if err != nil {
+			return nil, 0, err
+		}
+		if c < frameEnd {
+			if len(data) == maxSubpacketSize {
+				return nil, 0, errBadPacket
+			}
+			data = append(data, byte(c))
+			continue
+		}
+
+		end := byte(c - frameEnd)
+		n := 2
+		if use32 {
+			n = 4
+		}
+		crc := make([]byte, n)
+		for i := range crc {
+			c, err := l.readEscaped()
+			if err != nil {
+				return nil, 0, err
+			}
+			if c >= frameEnd {
+				return nil, 0, errFrameEnded
+			}
+			crc[i] = byte(c)
+		}
+		if use32 {
+			sum := crc32.Update(crc32.ChecksumIEEE(data), crc32.IEEETable, []byte{end})
+			if sum != binary.LittleEndian.Uint32(crc) {
+				return nil, 0, errBadCRC
+			}
+		} else if xmodem.CRC16(append(append(data, end), crc...)) != 0 {
+			return nil, 0, errBadCRC
+		}
+		return data, end, nil
+	}
+}
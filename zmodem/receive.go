@@ -0,0 +1,303 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package zmodem
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// FileInfo describes a file offered by the sender.
+type FileInfo struct {
+	// Name is the name of the file
+	Name string
+	// Size is the size of the file, -1 if unknown
+	Size int64
+	// ModTime is the modification time of the file, zero if unknown
+	ModTime time.Time
+	// Mode is the file mode, zero if unknown
+	Mode os.FileMode
+	// Resume is true if the sender asks to continue a file partially
+	// received in a previous transfer
+	Resume bool
+}
+
+// Receiver receives files with ZMODEM. The fields must be set before
+// starting a transfer.
+type Receiver struct {
+	// Timeout is the time to wait for data from the sender. If zero,
+	// DefaultTimeout is used.
+	Timeout time.Duration
+	// Retries is the number of consecutive errors or timeouts tolerated
+	// before the transfer is aborted. If zero, DefaultRetries is used.
+	Retries int
+	// BufferSize, if not zero, asks the sender to wait for an
+	// acknowledgement every BufferSize bytes (up to 65535).
+	BufferSize int
+	// EscapeControl asks the sender to escape all the control characters,
+	// for links that don't pass them through.
+	EscapeControl bool
+	// Progress, if set, is called after each subpacket received, with the
+	// name of the file, the position in the file and its size (-1 if
+	// unknown).
+	Progress func(name string, received, total int64)
+
+	port serial.Port
+}
+
+// NewReceiver creates a new Receiver that receives files from port.
+func NewReceiver(port serial.Port) *Receiver {
+	return &Receiver{port: port}
+}
+
+// receiver is the state of a session in progress.
+type receiver struct {
+	*Receiver
+	*link
+	errors int
+}
+
+// Receive receives the files sent with ZMODEM until the end of the
+// session. For each file open is called with its description and must
+// return the io.Writer where the content is written and the offset to
+// resume from: the size of the data already received in a previous
+// transfer, or 0. If open returns ErrSkip the file is skipped, any other
+// error cancels the transfer.
+func (r *Receiver) Receive(open func(info *FileInfo) (io.Writer, int64, error)) error {
+	rx := &receiver{Receiver: r, link: newLink(r.port, r.Timeout, r.Retries)}
+	rx.escapeCtl = r.EscapeControl
+	if err := rx.sendInit(); err != nil {
+		return err
+	}
+	for {
+		h, err := rx.readHeader()
+		if retryable(err) {
+			if err := rx.retry(); err != nil {
+				return err
+			}
+			if err := rx.sendInit(); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		switch h.typ {
+		case zrqinit:
+			if err := rx.sendInit(); err != nil {
+				return err
+			}
+		case zsinit:
+			// The attention string is not used
+			if _, _, err := rx.readSubpacket(h.crc32); err != nil && !retryable(err) {
+				return err
+			}
+			if err := rx.sendHexHeader(header{typ: zack}); err != nil {
+				return err
+			}
+		case zfile:
+			data, _, err := rx.readSubpacket(h.crc32)
+			if retryable(err) {
+				if err := rx.sendHexHeader(header{typ: znak}); err != nil {
+					return err
+				}
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			info, err := parseFileInfo(data)
+			if err != nil {
+				rx.cancel()
+				return err
+			}
+			info.Resume = h.zf0() == zcresum
+			w, offset, err := open(info)
+			if err == ErrSkip {
+				if err := rx.sendHexHeader(header{typ: zskip}); err != nil {
+					return err
+				}
+				continue
+			}
+			if err != nil {
+				rx.cancel()
+				return err
+			}
+			if err := rx.receiveFile(info, w, offset); err != nil {
+				return err
+			}
+			if err := rx.sendInit(); err != nil {
+				return err
+			}
+		case zfin:
+			if err := rx.sendHexHeader(header{typ: zfin}); err != nil {
+				return err
+			}
+			// Consume the "OO" that closes the session
+			rx.timeout = purgeTimeout
+			rx.readByte()
+			rx.readByte()
+			return nil
+		}
+	}
+}
+
+// parseFileInfo parses the ZFILE subpacket.
+func parseFileInfo(data []byte) (*FileInfo, error) {
+	end := bytes.IndexByte(data, 0)
+	if end <= 0 {
+		return nil, errBadPacket
+	}
+	info := &FileInfo{Name: string(data[:end]), Size: -1}
+	rest := data[end+1:]
+	if i := bytes.IndexByte(rest, 0); i >= 0 {
+		rest = rest[:i]
+	}
+	fields := strings.Fields(string(rest))
+	if len(fields) > 0 {
+		size, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil || size < 0 {
+			return nil, errBadPacket
+		}
+		info.Size = size
+	}
+	if len(fields) > 1 {
+		if t, err := strconv.ParseInt(fields[1], 8, 64); err == nil && t > 0 {
+			info.ModTime = time.Unix(t, 0)
+		}
+	}
+	if len(fields) > 2 {
+		if mode, err := strconv.ParseUint(fields[2], 8, 32); err == nil {
+			info.Mode = os.FileMode(mode).Perm()
+		}
+	}
+	return info, nil
+}
+
+// sendInit sends the capabilities of the receiver.
+func (rx *receiver) sendInit() error {
+	flags := canFDX | canOVIO | canFC32
+	if rx.EscapeControl {
+		flags |= escCtl
+	}
+	bufSize := rx.BufferSize
+	if bufSize > 0xFFFF {
+		bufSize = 0xFFFF
+	}
+	return rx.sendHexHeader(header{typ: zrinit, data: [4]byte{byte(bufSize), byte(bufSize >> 8), 0, flags}})
+}
+
+// retry counts a consecutive error.
+func (rx *receiver) retry() error {
+	rx.errors++
+	if rx.errors > rx.retries {
+		rx.cancel()
+		return ErrTooManyRetries
+	}
+	return nil
+}
+
+// requestPos discards the data in transit and asks the sender to restart
+// from pos.
+func (rx *receiver) requestPos(pos int64) error {
+	if err := rx.retry(); err != nil {
+		return err
+	}
+	if err := rx.purge(); err != nil {
+		return err
+	}
+	return rx.sendHexHeader(posHeader(zrpos, pos))
+}
+
+// receiveFile receives the data of a file from offset until ZEOF.
+func (rx *receiver) receiveFile(info *FileInfo, w io.Writer, offset int64) error {
+	pos := offset
+	rx.errors = 0
+	if err := rx.sendHexHeader(posHeader(zrpos, pos)); err != nil {
+		return err
+	}
+	for {
+		h, err := rx.readHeader()
+		if retryable(err) {
+			if err := rx.requestPos(pos); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		switch h.typ {
+		case zdata:
+			if h.pos() != pos {
+				if err := rx.requestPos(pos); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := rx.receiveData(info, w, &pos, h.crc32); err != nil {
+				return err
+			}
+		case zeof:
+			// A ZEOF at another position belongs to data discarded
+			if h.pos() == pos {
+				return nil
+			}
+		case zfile:
+			// The sender didn't receive the ZRPOS
+			if _, _, err := rx.readSubpacket(h.crc32); err != nil && !retryable(err) {
+				return err
+			}
+			if err := rx.sendHexHeader(posHeader(zrpos, pos)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// receiveData receives the subpackets of a ZDATA frame and advances pos.
+// The errors are recovered asking the sender to restart from pos.
+func (rx *receiver) receiveData(info *FileInfo, w io.Writer, pos *int64, use32 bool) error {
+	for {
+		data, end, err := rx.readSubpacket(use32)
+		if retryable(err) {
+			return rx.requestPos(*pos)
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			rx.cancel()
+			return err
+		}
+		*pos += int64(len(data))
+		rx.errors = 0
+		if rx.Progress != nil {
+			rx.Progress(info.Name, *pos, info.Size)
+		}
+
+		switch end {
+		case zcrcw:
+			return rx.sendHexHeader(posHeader(zack, *pos))
+		case zcrcq:
+			if err := rx.sendHexHeader(posHeader(zack, *pos)); err != nil {
+				return err
+			}
+		case zcrce:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,371 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package zmodem
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// File is a file sent with ZMODEM.
+type File struct {
+	// Name is the name of the file, without directories
+	Name string
+	// Size is the size of the file
+	Size int64
+	// ModTime is the modification time of the file, optional
+	ModTime time.Time
+	// Mode is the file mode, optional
+	Mode os.FileMode
+	// Data is the content of the file. It's read at the position requested
+	// by the receiver, to resume a transfer or to recover from errors.
+	Data io.ReaderAt
+}
+
+// Sender sends files with ZMODEM. The fields must be set before starting
+// a transfer.
+type Sender struct {
+	// BlockSize is the size of the data subpackets. If zero,
+	// DefaultBlockSize is used.
+	BlockSize int
+	// WindowSize is the number of bytes sent before waiting for an
+	// acknowledgement from the receiver. If zero the data is streamed
+	// without waiting, unless the receiver has a limited buffer.
+	WindowSize int
+	// Timeout is the time to wait for an answer from the receiver. If
+	// zero, DefaultTimeout is used.
+	Timeout time.Duration
+	// Retries is the number of consecutive errors tolerated before the
+	// transfer is aborted. If zero, DefaultRetries is used.
+	Retries int
+	// Resume asks the receiver to continue the files partially received
+	// in a previous transfer.
+	Resume bool
+	// EscapeControl escapes all the control characters, for links that
+	// don't pass them through. The receiver can request it as well.
+	EscapeControl bool
+	// Progress, if set, is called after each subpacket sent, with the
+	// name of the file, the position in the file and its size.
+	Progress func(name string, sent, total int64)
+
+	port serial.Port
+}
+
+// NewSender creates a new Sender that sends files on port.
+func NewSender(port serial.Port) *Sender {
+	return &Sender{port: port}
+}
+
+// sender is the state of a session in progress.
+type sender struct {
+	*Sender
+	*link
+	use32     bool
+	rxBufSize int
+}
+
+// Send sends files with ZMODEM. The files skipped by the receiver are not
+// reported as errors.
+func (s *Sender) Send(files ...*File) error {
+	tx := &sender{Sender: s, link: newLink(s.port, s.Timeout, s.Retries)}
+	tx.escapeCtl = s.EscapeControl
+	if err := tx.init(); err != nil {
+		return err
+	}
+
+	var bytesLeft int64
+	for _, f := range files {
+		bytesLeft += f.Size
+	}
+	for i, f := range files {
+		if err := tx.sendFile(f, len(files)-i, bytesLeft); err != nil {
+			return err
+		}
+		bytesLeft -= f.Size
+	}
+	return tx.finish()
+}
+
+// init starts the session and waits for the capabilities of the receiver.
+func (tx *sender) init() error {
+	// Start the receiver program, if the other side is a shell
+	if err := tx.write([]byte("rz\r")); err != nil {
+		return err
+	}
+	for i := 0; i <= tx.retries; i++ {
+		if err := tx.sendHexHeader(header{typ: zrqinit}); err != nil {
+			return err
+		}
+		h, err := tx.readHeader()
+		if retryable(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		switch h.typ {
+		case zrinit:
+			flags := h.zf0()
+			tx.use32 = flags&canFC32 != 0
+			tx.escapeCtl = tx.escapeCtl || flags&escCtl != 0
+			tx.rxBufSize = int(h.data[0]) | int(h.data[1])<<8
+			return nil
+		case zchallenge:
+			if err := tx.sendHexHeader(header{typ: zack, data: h.data}); err != nil {
+				return err
+			}
+		}
+	}
+	tx.cancel()
+	return ErrTooManyRetries
+}
+
+// fileInfo returns the ZFILE subpacket of a file: the name followed by
+// the size in decimal, the modification time and the mode in octal, the
+// serial number, the files and bytes left.
+func fileInfo(f *File, filesLeft int, bytesLeft int64) []byte {
+	var modTime int64
+	if !f.ModTime.IsZero() {
+		modTime = f.ModTime.Unix()
+	}
+	info := append([]byte(f.Name), 0)
+	info = append(info, fmt.Sprintf("%d %o %o 0 %d %d", f.Size, modTime, uint32(f.Mode.Perm()), filesLeft, bytesLeft)...)
+	return append(info, 0)
+}
+
+// sendFile offers a file to the receiver and sends it from the position
+// requested.
+func (tx *sender) sendFile(f *File, filesLeft int, bytesLeft int64) error {
+	conv := zcbin
+	if tx.Resume {
+		conv = zcresum
+	}
+	info := fileInfo(f, filesLeft, bytesLeft)
+	for i := 0; i <= tx.retries; i++ {
+		if err := tx.sendBinHeader(flagsHeader(zfile, conv), tx.use32); err != nil {
+			return err
+		}
+		if err := tx.sendSubpacket(info, zcrcw, tx.use32); err != nil {
+			return err
+		}
+	wait:
+		for {
+			h, err := tx.readHeader()
+			if retryable(err) {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			switch h.typ {
+			case zrpos:
+				return tx.sendData(f, h.pos())
+			case zskip:
+				return nil
+			case znak:
+				// The file header was corrupted
+				break wait
+			case zrinit:
+				// A duplicate of the ZRINIT received at the start: if the
+				// file header was lost the timeout triggers a new one
+				continue
+			case zcrc:
+				// The CRC of the file is not supported: the receiver
+				// falls back to the transfer
+				if err := tx.sendHexHeader(header{typ: zcrc}); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	tx.cancel()
+	return ErrTooManyRetries
+}
+
+// sendData streams the file from pos until the receiver acknowledges the
+// ZEOF, restarting from the position requested by the receiver after an
+// error.
+func (tx *sender) sendData(f *File, pos int64) error {
+	blockSize := tx.BlockSize
+	if blockSize <= 0 {
+		blockSize = DefaultBlockSize
+	}
+	window := tx.WindowSize
+	if tx.rxBufSize > 0 && (window <= 0 || tx.rxBufSize < window) {
+		window = tx.rxBufSize
+	}
+	buf := make([]byte, blockSize)
+	errors := 0
+	retry := func() error {
+		errors++
+		if errors > tx.retries {
+			tx.cancel()
+			return ErrTooManyRetries
+		}
+		return nil
+	}
+
+frame:
+	for {
+		if err := tx.sendBinHeader(posHeader(zdata, pos), tx.use32); err != nil {
+			return err
+		}
+		unacked := 0
+		for {
+			n, err := f.Data.ReadAt(buf, pos)
+			if err != nil && err != io.EOF {
+				tx.cancel()
+				return err
+			}
+			eof := err == io.EOF
+			end := zcrcg
+			if eof {
+				end = zcrce
+			} else if window > 0 && unacked+n >= window {
+				end = zcrcw
+			}
+			if err := tx.sendSubpacket(buf[:n], end, tx.use32); err != nil {
+				return err
+			}
+			pos += int64(n)
+			unacked += n
+			if tx.Progress != nil {
+				tx.Progress(f.Name, pos, f.Size)
+			}
+			if eof {
+				break
+			}
+
+			if end == zcrcw {
+				h, err := tx.waitAck(pos)
+				if retryable(err) {
+					if err := retry(); err != nil {
+						return err
+					}
+					continue frame
+				}
+				if err != nil {
+					return err
+				}
+				switch h.typ {
+				case zack:
+					errors = 0
+				case zrpos:
+					pos = h.pos()
+					if err := retry(); err != nil {
+						return err
+					}
+				case zskip:
+					return nil
+				}
+				continue frame
+			}
+
+			// Check if the receiver requested a retransmission
+			h, ok, err := tx.pollHeader()
+			if err != nil {
+				return err
+			}
+			if ok && h.typ == zrpos {
+				pos = h.pos()
+				if err := retry(); err != nil {
+					return err
+				}
+				continue frame
+			}
+			if ok && h.typ == zskip {
+				return nil
+			}
+		}
+
+		// End of file
+		for {
+			if err := tx.sendBinHeader(posHeader(zeof, pos), tx.use32); err != nil {
+				return err
+			}
+			h, err := tx.waitAck(-1)
+			if retryable(err) {
+				if err := retry(); err != nil {
+					return err
+				}
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			switch h.typ {
+			case zrinit, zskip:
+				return nil
+			case zrpos:
+				pos = h.pos()
+				if err := retry(); err != nil {
+					return err
+				}
+				continue frame
+			}
+		}
+	}
+}
+
+// waitAck waits for the answer to a ZCRCW subpacket or to a ZEOF,
+// skipping the acknowledgements of positions other than pos.
+func (tx *sender) waitAck(pos int64) (header, error) {
+	for {
+		h, err := tx.readHeader()
+		if err == nil && h.typ == zack && h.pos() != pos {
+			continue
+		}
+		return h, err
+	}
+}
+
+// pollHeader reads a header sent by the receiver while streaming, if any.
+func (tx *sender) pollHeader() (header, bool, error) {
+	for {
+		ok, err := tx.poll()
+		if err != nil || !ok {
+			return header{}, false, err
+		}
+		c, err := tx.readByte()
+		if err != nil {
+			return header{}, false, err
+		}
+		if c != zpad && c != zdle {
+			continue
+		}
+		tx.unread()
+		h, err := tx.readHeader()
+		if retryable(err) {
+			return header{}, false, nil
+		}
+		return h, err == nil, err
+	}
+}
+
+// finish ends the session.
+func (tx *sender) finish() error {
+	for i := 0; i <= tx.retries; i++ {
+		if err := tx.sendHexHeader(header{typ: zfin}); err != nil {
+			return err
+		}
+		h, err := tx.readHeader()
+		if retryable(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if h.typ == zfin {
+			return tx.write([]byte("OO"))
+		}
+	}
+	return ErrTooManyRetries
+}
@@ -0,0 +1,143 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+/*
+Package zmodem implements the ZMODEM file transfer protocol on top of a
+serial.Port, compatible with the sz and rz programs of lrzsz.
+
+The data is streamed in subpackets protected by a 32-bit CRC (or a 16-bit
+CRC if the receiver doesn't support it) and the sender restarts from the
+position requested by the receiver after an error, without waiting for an
+acknowledgement of each block:
+
+	sender := zmodem.NewSender(port)
+	err := sender.Send(&zmodem.File{
+		Name: "log.txt",
+		Size: size,
+		Data: file, // io.ReaderAt
+	})
+
+A transfer cut off halfway can be resumed: the receiver returns the size
+of the data already received as offset and the sender starts from there:
+
+	receiver := zmodem.NewReceiver(port)
+	err := receiver.Receive(func(info *zmodem.FileInfo) (io.Writer, int64, error) {
+		f, err := os.OpenFile(info.Name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, 0, err
+		}
+		st, err := f.Stat()
+		if err != nil {
+			return nil, 0, err
+		}
+		return f, st.Size(), nil
+	})
+*/
+package zmodem
+
+import (
+	"errors"
+	"time"
+)
+
+// Special characters
+const (
+	zpad   byte = '*'  // padding before a header
+	zdle   byte = 0x18 // escape character (also CAN)
+	zbin   byte = 'A'  // binary header with CRC-16
+	zhex   byte = 'B'  // hex header with CRC-16
+	zbin32 byte = 'C'  // binary header with CRC-32
+	xon    byte = 0x11
+	xoff   byte = 0x13
+)
+
+// Subpacket terminators
+const (
+	zcrce byte = 'h' // end of frame, header follows
+	zcrcg byte = 'i' // frame continues nonstop
+	zcrcq byte = 'j' // frame continues, ZACK expected
+	zcrcw byte = 'k' // end of frame, ZACK expected
+	zrub0 byte = 'l' // escaped 0x7F
+	zrub1 byte = 'm' // escaped 0xFF
+)
+
+// Frame types
+const (
+	zrqinit    byte = 0
+	zrinit     byte = 1
+	zsinit     byte = 2
+	zack       byte = 3
+	zfile      byte = 4
+	zskip      byte = 5
+	znak       byte = 6
+	zabort     byte = 7
+	zfin       byte = 8
+	zrpos      byte = 9
+	zdata      byte = 10
+	zeof       byte = 11
+	zferr      byte = 12
+	zcrc       byte = 13
+	zchallenge byte = 14
+	zcompl     byte = 15
+	zcan       byte = 16
+	zfreecnt   byte = 17
+	zcommand   byte = 18
+)
+
+// Receiver capabilities sent in ZF0 of ZRINIT
+const (
+	canFDX  byte = 0x01 // full duplex
+	canOVIO byte = 0x02 // can receive data during disk I/O
+	canFC32 byte = 0x20 // can use the 32-bit CRC
+	escCtl  byte = 0x40 // all control characters must be escaped
+)
+
+// Conversion options sent in ZF0 of ZFILE
+const (
+	zcbin   byte = 1 // binary transfer
+	zcresum byte = 3 // resume an interrupted transfer
+)
+
+// Default values of the transfer parameters
+const (
+	DefaultTimeout   = 10 * time.Second
+	DefaultRetries   = 10
+	DefaultBlockSize = 1024
+)
+
+// maxSubpacketSize is the largest subpacket accepted by a receiver.
+const maxSubpacketSize = 8192
+
+// purgeTimeout is the line silence waited to discard the rest of a
+// corrupted frame.
+const purgeTimeout = 200 * time.Millisecond
+
+var (
+	// ErrCanceled is returned when the remote side cancels the transfer
+	ErrCanceled = errors.New("zmodem: transfer canceled by remote")
+	// ErrTooManyRetries is returned when the transfer can't progress within
+	// the allowed retries
+	ErrTooManyRetries = errors.New("zmodem: too many retries")
+	// ErrSkip can be returned by the function passed to Receiver.Receive
+	// to skip a file
+	ErrSkip = errors.New("zmodem: skip file")
+
+	errTimeout    = errors.New("zmodem: timeout")
+	errBadCRC     = errors.New("zmodem: bad CRC")
+	errBadEscape  = errors.New("zmodem: bad escape sequence")
+	errBadPacket  = errors.New("zmodem: invalid subpacket")
+	errGarbage    = errors.New("zmodem: garbage received")
+	errFrameEnded = errors.New("zmodem: unexpected end of frame")
+)
+
+// retryable returns true if the error can be recovered by retrying.
+func retryable(err error) bool {
+	switch err {
+	case errTimeout, errBadCRC, errBadEscape, errBadPacket, errGarbage, errFrameEnded:
+		return true
+	}
+	return false
+}
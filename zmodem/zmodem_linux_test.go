@@ -0,0 +1,330 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package zmodem
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.bug.st/serial"
+	"go.bug.st/serial/internal/ptytest"
+)
+
+func newTestPair(t *testing.T) (serial.Port, serial.Port) {
+	port, master, err := ptytest.Pair(&serial.Mode{BaudRate: 115200})
+	require.NoError(t, err)
+	return port, master
+}
+
+func randomData(n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(int64(n))).Read(data)
+	return data
+}
+
+// noisyPort corrupts a byte of the first write longer than 512 bytes.
+type noisyPort struct {
+	serial.Port
+
+	mu   sync.Mutex
+	done bool
+}
+
+func (p *noisyPort) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	if !p.done && len(b) > 512 {
+		p.done = true
+		c := append([]byte{}, b...)
+		c[300] ^= 0x01
+		p.mu.Unlock()
+		return p.Port.Write(c)
+	}
+	p.mu.Unlock()
+	return p.Port.Write(b)
+}
+
+func (p *noisyPort) SetReadTimeout(t time.Duration) error {
+	return p.Port.(serial.ReadTimeouter).SetReadTimeout(t)
+}
+
+// failingWriter fails after n bytes.
+type failingWriter struct {
+	bytes.Buffer
+	n int
+}
+
+var errDiskFull = errors.New("disk full")
+
+func (w *failingWriter) Write(b []byte) (int, error) {
+	if w.Len()+len(b) > w.n {
+		return 0, errDiskFull
+	}
+	return w.Buffer.Write(b)
+}
+
+func TestZMODEM(t *testing.T) {
+	tests := []struct {
+		name       string
+		windowSize int
+		bufferSize int
+		escapeCtl  bool
+	}{
+		{"streaming", 0, 0, false},
+		{"window", 2048, 0, false},
+		{"receiver-buffer", 0, 3000, false},
+		{"escape-control", 0, 0, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a, b := newTestPair(t)
+			defer a.Close()
+			defer b.Close()
+
+			files := []*File{
+				{Name: "log.txt", Size: 20000, ModTime: time.Unix(1600000000, 0), Mode: 0640},
+				{Name: "small.txt", Size: 10},
+				{Name: "empty", Size: 0},
+			}
+			contents := map[string][]byte{}
+			for _, f := range files {
+				contents[f.Name] = randomData(int(f.Size))
+				f.Data = bytes.NewReader(contents[f.Name])
+			}
+
+			sender := NewSender(a)
+			sender.Timeout = time.Second
+			sender.WindowSize = test.windowSize
+			progress := int64(0)
+			sender.Progress = func(name string, sent, total int64) {
+				if name == "log.txt" {
+					require.Equal(t, int64(20000), total)
+					progress = sent
+				}
+			}
+			sent := make(chan error, 1)
+			go func() {
+				sent <- sender.Send(files...)
+			}()
+
+			receiver := NewReceiver(b)
+			receiver.Timeout = time.Second
+			receiver.BufferSize = test.bufferSize
+			receiver.EscapeControl = test.escapeCtl
+			received := map[string]*bytes.Buffer{}
+			infos := []*FileInfo{}
+			err := receiver.Receive(func(info *FileInfo) (io.Writer, int64, error) {
+				infos = append(infos, info)
+				received[info.Name] = &bytes.Buffer{}
+				return received[info.Name], 0, nil
+			})
+			require.NoError(t, err)
+			require.NoError(t, <-sent)
+			require.Equal(t, int64(20000), progress)
+
+			require.Len(t, infos, 3)
+			require.Equal(t, &FileInfo{Name: "log.txt", Size: 20000, ModTime: time.Unix(1600000000, 0), Mode: 0640}, infos[0])
+			for name, data := range contents {
+				require.True(t, bytes.Equal(data, received[name].Bytes()), name)
+			}
+		})
+	}
+}
+
+func TestZMODEMRetransmission(t *testing.T) {
+	a, b := newTestPair(t)
+	defer a.Close()
+	defer b.Close()
+	data := randomData(10000)
+
+	sender := NewSender(&noisyPort{Port: a})
+	sender.Timeout = time.Second
+	sent := make(chan error, 1)
+	go func() {
+		sent <- sender.Send(&File{Name: "data", Size: int64(len(data)), Data: bytes.NewReader(data)})
+	}()
+
+	receiver := NewReceiver(b)
+	receiver.Timeout = time.Second
+	var out bytes.Buffer
+	err := receiver.Receive(func(info *FileInfo) (io.Writer, int64, error) {
+		return &out, 0, nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, <-sent)
+	require.True(t, bytes.Equal(data, out.Bytes()))
+}
+
+func TestZMODEMResume(t *testing.T) {
+	a, b := newTestPair(t)
+	defer a.Close()
+	defer b.Close()
+	data := randomData(30000)
+	file := &File{Name: "log.txt", Size: int64(len(data)), Data: bytes.NewReader(data)}
+
+	// The first transfer is interrupted
+	sender := NewSender(a)
+	sender.Timeout = time.Second
+	sent := make(chan error, 1)
+	go func() {
+		sent <- sender.Send(file)
+	}()
+	receiver := NewReceiver(b)
+	receiver.Timeout = time.Second
+	out := &failingWriter{n: 12345}
+	err := receiver.Receive(func(info *FileInfo) (io.Writer, int64, error) {
+		return out, 0, nil
+	})
+	require.Equal(t, errDiskFull, err)
+	// Discard the data in transit until the sender stops
+	done := make(chan bool)
+	go func() {
+		buf := make([]byte, 1024)
+		b.(serial.ReadTimeouter).SetReadTimeout(10 * time.Millisecond)
+		for {
+			select {
+			case <-done:
+				done <- true
+				return
+			default:
+				b.Read(buf)
+			}
+		}
+	}()
+	require.Equal(t, ErrCanceled, <-sent)
+	done <- true
+	<-done
+	partial := out.Len()
+	require.True(t, partial > 0 && partial < len(data))
+
+	// The second transfer continues from the data already received
+	sender.Resume = true
+	go func() {
+		sent <- sender.Send(file)
+	}()
+	var sentFrom int64 = -1
+	receiver.Progress = func(name string, received, total int64) {
+		if sentFrom < 0 {
+			sentFrom = received
+		}
+	}
+	out.n = len(data)
+	err = receiver.Receive(func(info *FileInfo) (io.Writer, int64, error) {
+		require.True(t, info.Resume)
+		return out, int64(out.Len()), nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, <-sent)
+	require.True(t, sentFrom > int64(partial))
+	require.True(t, sentFrom <= int64(partial)+DefaultBlockSize)
+	require.True(t, bytes.Equal(data, out.Bytes()))
+}
+
+func TestZMODEMSkip(t *testing.T) {
+	a, b := newTestPair(t)
+	defer a.Close()
+	defer b.Close()
+
+	sender := NewSender(a)
+	sender.Timeout = time.Second
+	sent := make(chan error, 1)
+	go func() {
+		sent <- sender.Send(
+			&File{Name: "skipped", Size: 5000, Data: bytes.NewReader(randomData(5000))},
+			&File{Name: "wanted", Size: 3, Data: bytes.NewReader([]byte("abc"))},
+		)
+	}()
+
+	receiver := NewReceiver(b)
+	receiver.Timeout = time.Second
+	var out bytes.Buffer
+	err := receiver.Receive(func(info *FileInfo) (io.Writer, int64, error) {
+		if info.Name == "skipped" {
+			return nil, 0, ErrSkip
+		}
+		return &out, 0, nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, <-sent)
+	require.Equal(t, "abc", out.String())
+}
+
+func TestZMODEMReceiverTimeout(t *testing.T) {
+	a, b := newTestPair(t)
+	defer a.Close()
+	defer b.Close()
+
+	receiver := NewReceiver(b)
+	receiver.Timeout = 50 * time.Millisecond
+	receiver.Retries = 2
+	err := receiver.Receive(func(info *FileInfo) (io.Writer, int64, error) {
+		return nil, 0, ErrSkip
+	})
+	require.Equal(t, ErrTooManyRetries, err)
+}
+
+// TestLrzsz checks the interoperability with the sz and rz programs, if
+// they are installed.
+func TestLrzsz(t *testing.T) {
+	sz, err := exec.LookPath("sz")
+	if err != nil {
+		t.Skip("lrzsz not installed")
+	}
+	rz, err := exec.LookPath("rz")
+	if err != nil {
+		t.Skip("lrzsz not installed")
+	}
+	dir, err := ioutil.TempDir("", "zmodem")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	data := randomData(50000)
+
+	// The program runs on the terminal side of a pseudo-terminal
+	run := func(name string, args ...string) (serial.Port, *exec.Cmd) {
+		master, slaveName, err := ptytest.Open()
+		require.NoError(t, err)
+		slave, err := os.OpenFile(slaveName, os.O_RDWR, 0)
+		require.NoError(t, err)
+		defer slave.Close()
+		cmd := exec.Command(name, args...)
+		cmd.Dir = dir
+		cmd.Stdin = slave
+		cmd.Stdout = slave
+		require.NoError(t, cmd.Start())
+		return &ptytest.Port{File: master}, cmd
+	}
+
+	// Our sender to rz
+	port, cmd := run(rz, "--binary", "--overwrite")
+	sender := NewSender(port)
+	require.NoError(t, sender.Send(&File{Name: "to-rz.bin", Size: int64(len(data)), Data: bytes.NewReader(data)}))
+	require.NoError(t, cmd.Wait())
+	port.Close()
+	received, err := ioutil.ReadFile(filepath.Join(dir, "to-rz.bin"))
+	require.NoError(t, err)
+	require.True(t, bytes.Equal(data, received))
+
+	// sz to our receiver
+	port, cmd = run(sz, "--binary", "to-rz.bin")
+	var out bytes.Buffer
+	err = NewReceiver(port).Receive(func(info *FileInfo) (io.Writer, int64, error) {
+		return &out, 0, nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, cmd.Wait())
+	port.Close()
+	require.True(t, bytes.Equal(data, out.Bytes()))
+}
@@ -0,0 +1,153 @@
+//
+// Copyright 2014-2020 Cristian Maglie. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package zmodem
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.bug.st/serial"
+)
+
+// loopbackPort returns the data written when read.
+type loopbackPort struct {
+	serial.Port
+	bytes.Buffer
+}
+
+func (p *loopbackPort) Read(b []byte) (int, error) {
+	if p.Len() == 0 {
+		return 0, nil
+	}
+	return p.Buffer.Read(b)
+}
+
+func (p *loopbackPort) Write(b []byte) (int, error) {
+	return p.Buffer.Write(b)
+}
+
+func (p *loopbackPort) SetReadTimeout(t time.Duration) error {
+	return nil
+}
+
+func TestHexHeader(t *testing.T) {
+	port := &loopbackPort{}
+	l := newLink(port, 0, 0)
+
+	// The headers sent by lrzsz
+	require.NoError(t, l.sendHexHeader(header{typ: zrqinit}))
+	require.Equal(t, "**\x18B00000000000000\r\x8a\x11", port.String())
+	port.Reset()
+	require.NoError(t, l.sendHexHeader(header{typ: zrinit, data: [4]byte{0, 0, 0, canFDX | canOVIO | canFC32}}))
+	require.Equal(t, "**\x18B0100000023be50\r\x8a\x11", port.String())
+
+	h, err := l.readHeader()
+	require.NoError(t, err)
+	require.Equal(t, zrinit, h.typ)
+	require.Equal(t, canFDX|canOVIO|canFC32, h.zf0())
+}
+
+func TestFrameRoundTrip(t *testing.T) {
+	data := make([]byte, 512)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	for _, use32 := range []bool{false, true} {
+		for _, escapeCtl := range []bool{false, true} {
+			port := &loopbackPort{}
+			l := newLink(port, time.Millisecond, 0)
+			l.escapeCtl = escapeCtl
+
+			require.NoError(t, l.sendBinHeader(posHeader(zdata, 0x12345678), use32))
+			require.NoError(t, l.sendSubpacket(data, zcrcg, use32))
+			require.NoError(t, l.sendSubpacket([]byte("@\r@\x8d"), zcrce, use32))
+			require.NoError(t, l.sendSubpacket(nil, zcrcw, use32))
+			// Only the XON that follows ZCRCW is sent unescaped
+			sent := port.Bytes()
+			require.Equal(t, xon, sent[len(sent)-1])
+			for _, c := range sent[:len(sent)-1] {
+				require.False(t, bytes.IndexByte([]byte{0x10, xon, xoff, 0x90, 0x91, 0x93}, c) >= 0, "%02x not escaped", c)
+				if escapeCtl && c != zdle {
+					require.NotZero(t, c&0x60, "%02x not escaped", c)
+				}
+			}
+
+			h, err := l.readHeader()
+			require.NoError(t, err)
+			require.Equal(t, zdata, h.typ)
+			require.Equal(t, int64(0x12345678), h.pos())
+			require.Equal(t, use32, h.crc32)
+
+			sub, end, err := l.readSubpacket(use32)
+			require.NoError(t, err)
+			require.Equal(t, zcrcg, end)
+			require.Equal(t, data, sub)
+			sub, end, err = l.readSubpacket(use32)
+			require.NoError(t, err)
+			require.Equal(t, zcrce, end)
+			require.Equal(t, "@\r@\x8d", string(sub))
+			sub, end, err = l.readSubpacket(use32)
+			require.NoError(t, err)
+			require.Equal(t, zcrcw, end)
+			require.Empty(t, sub)
+			_, err = l.readHeader()
+			require.Equal(t, errTimeout, err)
+		}
+	}
+}
+
+func TestFrameErrors(t *testing.T) {
+	port := &loopbackPort{}
+	l := newLink(port, time.Millisecond, 0)
+	require.NoError(t, l.sendSubpacket([]byte("data"), zcrcw, true))
+	corrupted := port.Bytes()
+	corrupted[1] ^= 0x01
+	_, _, err := l.readSubpacket(true)
+	require.Equal(t, errBadCRC, err)
+
+	port.Reset()
+	require.NoError(t, l.sendHexHeader(posHeader(zrpos, 100)))
+	port.Bytes()[6] = '9'
+	_, err = l.readHeader()
+	require.Equal(t, errBadCRC, err)
+
+	// Five CAN cancel the session
+	port.Reset()
+	port.WriteString("garbage\x18\x18\x18\x18\x18")
+	_, err = l.readHeader()
+	require.Equal(t, ErrCanceled, err)
+}
+
+func TestFileInfo(t *testing.T) {
+	f := &File{Name: "log.txt", Size: 1234, ModTime: time.Unix(0x1234, 0), Mode: 0644}
+	data := fileInfo(f, 2, 5000)
+	require.Equal(t, "log.txt\x001234 11064 644 0 2 5000\x00", string(data))
+
+	info, err := parseFileInfo(data)
+	require.NoError(t, err)
+	require.Equal(t, "log.txt", info.Name)
+	require.Equal(t, int64(1234), info.Size)
+	require.Equal(t, time.Unix(0x1234, 0), info.ModTime)
+	require.EqualValues(t, 0644, info.Mode)
+
+	// sz sends the file type bits in the mode
+	info, err = parseFileInfo([]byte("a.bin\x0010 13520000000 100755 0 1 10\x00"))
+	require.NoError(t, err)
+	require.EqualValues(t, 0755, info.Mode)
+
+	info, err = parseFileInfo([]byte("nosize\x00\x00"))
+	require.NoError(t, err)
+	require.Equal(t, int64(-1), info.Size)
+	require.True(t, info.ModTime.IsZero())
+
+	_, err = parseFileInfo([]byte("\x00"))
+	require.Equal(t, errBadPacket, err)
+	_, err = parseFileInfo([]byte("bad\x00abc\x00"))
+	require.Equal(t, errBadPacket, err)
+}
@@ -96,9 +96,15 @@ func setCommTimeouts(handle syscall.Handle, timeouts *commTimeouts) (err error)
 	return
 }
 
-func escapeCommFunction(handle syscall.Handle, function uint32) (res bool) {
-	r0, _, _ := syscall.Syscall(procEscapeCommFunction.Addr(), 2, uintptr(handle), uintptr(function), 0)
-	res = r0 != 0
+func escapeCommFunction(handle syscall.Handle, function uint32) (err error) {
+	r1, _, e1 := syscall.Syscall(procEscapeCommFunction.Addr(), 2, uintptr(handle), uintptr(function), 0)
+	if r1 == 0 {
+		if e1 != 0 {
+			err = errnoErr(e1)
+		} else {
+			err = syscall.EINVAL
+		}
+	}
 	return
 }
 